@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/cs3305-team-4/api/pkg/routes"
+	"github.com/cs3305-team-4/api/pkg/services"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/spf13/viper"
+)
+
+func main() {
+	log.Info("grindsapp api starting")
+	services.Init()
+
+	bindStr := fmt.Sprintf(
+		"%s:%s",
+		viper.GetString("bind.address"),
+		viper.GetString("bind.port"),
+	)
+
+	scheduler := services.NewScheduler(services.LessonJobs()...)
+	scheduler.Start()
+	defer scheduler.Stop()
+
+	log.Infof("binding to %s", bindStr)
+	router := routes.GetHandler()
+	http.ListenAndServe(bindStr, router)
+}
@@ -3,13 +3,11 @@ package routes
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"net/http"
 
 	"github.com/cs3305-team-4/api/pkg/services"
 	"github.com/gorilla/mux"
-
-	stripe "github.com/stripe/stripe-go/v72"
+	log "github.com/sirupsen/logrus"
 )
 
 func InjectAccountsRoutes(subrouter *mux.Router) {
@@ -24,12 +22,17 @@ func InjectAccountsRoutes(subrouter *mux.Router) {
 	accountResource.HandleFunc("/password", handleAccountsUpdatePassword).Methods("POST")
 	accountResource.HandleFunc("/lessons", handleAccountsLessonsGet).Methods("GET")
 
+	accountResource.HandleFunc("/two-factor", handleAccountsTwoFactorGet).Methods("GET")
+	accountResource.HandleFunc("/two-factor", handleAccountsTwoFactorEnroll).Methods("POST")
+	accountResource.HandleFunc("/two-factor/confirm", handleAccountsTwoFactorConfirm).Methods("POST")
+	accountResource.HandleFunc("/two-factor/disable", handleAccountsTwoFactorDisable).Methods("POST")
+
 	accountResource.HandleFunc("/billing/tutor-onboard", handleTutorBillingGetOnboard).Methods("GET")
 	accountResource.HandleFunc("/billing/tutor-onboard-url", handleTutorBillingGetOnboardURL).Methods("GET")
 	accountResource.HandleFunc("/billing/tutor-requirements-met", handleTutorBillingGetRequirementsMet).Methods("GET")
 	accountResource.HandleFunc("/billing/tutor-panel-url", handleTutorBillingGetPanelURL).Methods("GET")
 	accountResource.HandleFunc("/billing/payout-info", handleTutorBillingGetPayoutInfo).Methods("GET")
-	accountResource.HandleFunc("/billing/payout", handleTutorBillingCreatePayout).Methods("POST")
+	accountResource.Handle("/billing/payout", authTwoFactor()(http.HandlerFunc(handleTutorBillingCreatePayout))).Methods("POST")
 	accountResource.HandleFunc("/billing/payees-payments", handleStudentBillingGetPayeesPayments).Methods("GET")
 	accountResource.HandleFunc("/billing/payers-payments", handleTutorBillingGetPayersPayments).Methods("GET")
 	accountResource.HandleFunc("/billing/card-setup-session", handleStudentBillingCreateCardSetupSession).Methods("POST")
@@ -137,8 +140,45 @@ func handleTutorBillingGetPayersPayments(w http.ResponseWriter, r *http.Request)
 	})
 }
 
+// CardResponseDTO represents a saved card, it keeps the shape of a Stripe payment method that the UI reads
+type CardResponseDTO struct {
+	ID             string                 `json:"id"`
+	Card           CardDetailsResponseDTO `json:"card"`
+	BillingDetails CardBillingResponseDTO `json:"billing_details"`
+}
+
+type CardDetailsResponseDTO struct {
+	Brand    string `json:"brand"`
+	Last4    string `json:"last4"`
+	ExpMonth uint64 `json:"exp_month"`
+	ExpYear  uint64 `json:"exp_year"`
+}
+
+type CardBillingResponseDTO struct {
+	Name string `json:"name"`
+}
+
 type AccountCardsResponseDTO struct {
-	Cards []stripe.PaymentMethod `json:"cards" `
+	Cards []CardResponseDTO `json:"cards" `
+}
+
+func dtoFromCards(cards []services.Card) []CardResponseDTO {
+	dtoCards := []CardResponseDTO{}
+
+	for _, c := range cards {
+		dtoCards = append(dtoCards, CardResponseDTO{
+			ID: c.ID,
+			Card: CardDetailsResponseDTO{
+				Brand:    c.Brand,
+				Last4:    c.Last4,
+				ExpMonth: c.ExpMonth,
+				ExpYear:  c.ExpYear,
+			},
+			BillingDetails: CardBillingResponseDTO{Name: c.Name},
+		})
+	}
+
+	return dtoCards
 }
 
 func handleStudentBillingGetCards(w http.ResponseWriter, r *http.Request) {
@@ -160,7 +200,7 @@ func handleStudentBillingGetCards(w http.ResponseWriter, r *http.Request) {
 	}
 
 	WriteBody(w, r, &AccountCardsResponseDTO{
-		Cards: cards,
+		Cards: dtoFromCards(cards),
 	})
 }
 
@@ -273,7 +313,7 @@ func handleTutorBillingCreatePayout(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if ready {
-		err = serviceAccount.Payout()
+		err = serviceAccount.Payout(r.Context())
 		if err != nil {
 			restError(w, r, err, http.StatusInternalServerError)
 			return
@@ -393,14 +433,13 @@ func handleAccountsUpdateEmail(w http.ResponseWriter, r *http.Request) {
 		restError(w, r, err, http.StatusBadRequest)
 		return
 	}
-	// TODO(ericm): Add email verification.
 	field := ParseUpdateString(w, r)
 	if err = validateUpdate("Email", field, &AccountRequestDTO{}); err != nil {
 		restError(w, r, err, http.StatusBadRequest)
 		return
 	}
 	var account *services.Account
-	if account, err = services.UpdateAccountField(id, "email", field); err != nil {
+	if account, err = services.UpdateAccountEmail(r.Context(), id, field); err != nil {
 		restError(w, r, err, http.StatusBadRequest)
 		return
 	}
@@ -440,7 +479,7 @@ func handleAccountsUpdatePassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = validateUpdate("Password", newPassword, &AccountResponseDTO{}); err != nil {
+	if err = validateUpdate("Password", newPassword, &AccountRequestDTO{}); err != nil {
 		restError(w, r, err, http.StatusBadRequest)
 		return
 	}
@@ -450,7 +489,7 @@ func handleAccountsUpdatePassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var account *services.Account
-	if account, err = passwordHash.SetOnAccountByID(id); err != nil {
+	if account, err = passwordHash.SetOnAccountByID(r.Context(), id); err != nil {
 		restError(w, r, err, http.StatusBadRequest)
 		return
 	}
@@ -473,7 +512,6 @@ func handleAccountsPost(w http.ResponseWriter, r *http.Request) {
 		restError(w, r, err, http.StatusInternalServerError)
 		return
 	}
-	// TODO(ericm): Add email verification.
 	serviceAccount := &services.Account{
 		Email:        account.Email,
 		Type:         accountType,
@@ -483,16 +521,145 @@ func handleAccountsPost(w http.ResponseWriter, r *http.Request) {
 		restError(w, r, err, http.StatusInternalServerError)
 		return
 	}
+	// The account exists either way, the verification email can be resent from /accounts/{uuid}/verify
+	if err = serviceAccount.SendEmailVerification(); err != nil {
+		log.WithContext(r.Context()).WithError(err).Error("Could not send email verification")
+	}
 	outAccount := dtoFromAccount(serviceAccount)
 	WriteBody(w, r, outAccount)
 }
 
+// handleAccountsVerify resends the email verification link of an account
 func handleAccountsVerify(w http.ResponseWriter, r *http.Request) {
 	id, err := getUUID(r, "uuid")
 	if err != nil {
 		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	account, err := services.ReadAccountByID(id, nil)
+	if err != nil {
+		restError(w, r, err, http.StatusNotFound)
+		return
+	}
+	if err = account.SendEmailVerification(); err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+type AccountTwoFactorResponseDTO struct {
+	Enabled bool `json:"enabled"`
+}
+
+// AccountTwoFactorEnrollResponseDTO contains a new TOTP secret, the uri can be shown as a QR code for authenticator apps
+type AccountTwoFactorEnrollResponseDTO struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// AccountTwoFactorCodeDTO contains a TOTP or recovery code
+type AccountTwoFactorCodeDTO struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type AccountTwoFactorRecoveryCodesResponseDTO struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func handleAccountsTwoFactorGet(w http.ResponseWriter, r *http.Request) {
+	id, err := getUUID(r, "uuid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	account, err := services.ReadAccountByID(id, nil)
+	if err != nil {
+		restError(w, r, err, http.StatusNotFound)
+		return
+	}
+
+	enabled, err := account.TwoFactorEnabled()
+	if err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	WriteBody(w, r, &AccountTwoFactorResponseDTO{
+		Enabled: enabled,
+	})
+}
+
+func handleAccountsTwoFactorEnroll(w http.ResponseWriter, r *http.Request) {
+	id, err := getUUID(r, "uuid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
 	}
-	fmt.Println(id)
+	account, err := services.ReadAccountByID(id, nil)
+	if err != nil {
+		restError(w, r, err, http.StatusNotFound)
+		return
+	}
+
+	secret, uri, err := account.BeginTwoFactorEnrollment()
+	if err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	WriteBody(w, r, &AccountTwoFactorEnrollResponseDTO{
+		Secret: secret,
+		URI:    uri,
+	})
+}
+
+func handleAccountsTwoFactorConfirm(w http.ResponseWriter, r *http.Request) {
+	confirm := &AccountTwoFactorCodeDTO{}
+	if !ParseBody(w, r, confirm) {
+		return
+	}
+
+	authContext, err := ReadRequestAuthContext(r)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	codes, err := authContext.Account.ConfirmTwoFactorEnrollment(confirm.Code)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	// A code was just given, so this session doesn't need to log in again to do things that need two-factor authentication
+	if err = services.MarkSessionTwoFactorVerified(authContext.Session.ID); err != nil {
+		log.WithContext(r.Context()).WithError(err).Error("Could not mark session as two-factor verified")
+	}
+
+	WriteBody(w, r, &AccountTwoFactorRecoveryCodesResponseDTO{
+		RecoveryCodes: codes,
+	})
+}
+
+func handleAccountsTwoFactorDisable(w http.ResponseWriter, r *http.Request) {
+	disable := &AccountTwoFactorCodeDTO{}
+	if !ParseBody(w, r, disable) {
+		return
+	}
+
+	authContext, err := ReadRequestAuthContext(r)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if err = authContext.Account.DisableTwoFactor(disable.Code); err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
 }
 
 func handleAccountsLessonsGet(w http.ResponseWriter, r *http.Request) {
@@ -508,7 +675,7 @@ func handleAccountsLessonsGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dtoLessons := dtoFromLessons(lessons)
+	dtoLessons := dtoFromLessons(lessons, services.ReadAccountLocation(id))
 	if err = json.NewEncoder(w).Encode(dtoLessons); err != nil {
 		restError(w, r, err, http.StatusInternalServerError)
 		return
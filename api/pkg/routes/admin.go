@@ -0,0 +1,450 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cs3305-team-4/api/pkg/services"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+func InjectAdminRoutes(subrouter *mux.Router) {
+	subrouter.Use(authAdmin())
+
+	subrouter.HandleFunc("/accounts", handleAdminAccountsGet).Methods("GET")
+	subrouter.HandleFunc("/accounts/{uuid}/suspend", handleAdminAccountsSuspend).Methods("POST")
+	subrouter.HandleFunc("/accounts/{uuid}/unsuspend", handleAdminAccountsUnsuspend).Methods("POST")
+
+	subrouter.HandleFunc("/qualifications", handleAdminQualificationsGet).Methods("GET")
+	subrouter.HandleFunc("/qualifications/{uuid}/verify", handleAdminQualificationsVerify).Methods("POST")
+	subrouter.HandleFunc("/qualifications/{uuid}/reject", handleAdminQualificationsReject).Methods("POST")
+
+	subrouter.HandleFunc("/work-experience", handleAdminWorkExperienceGet).Methods("GET")
+	subrouter.HandleFunc("/work-experience/{uuid}/verify", handleAdminWorkExperienceVerify).Methods("POST")
+	subrouter.HandleFunc("/work-experience/{uuid}/reject", handleAdminWorkExperienceReject).Methods("POST")
+
+	subrouter.HandleFunc("/subject-requests", handleAdminSubjectRequestsGet).Methods("GET")
+	subrouter.HandleFunc("/subject-requests/{uuid}/approve", handleAdminSubjectRequestsApprove).Methods("POST")
+	subrouter.HandleFunc("/subject-requests/{uuid}/deny", handleAdminSubjectRequestsDeny).Methods("POST")
+
+	subrouter.HandleFunc("/reviews/{uuid}", handleAdminReviewsDelete).Methods("DELETE")
+
+	subrouter.HandleFunc("/disputes", handleAdminDisputesGet).Methods("GET")
+	subrouter.HandleFunc("/disputes/{uuid}/refund", handleAdminDisputesRefund).Methods("POST")
+	subrouter.HandleFunc("/disputes/{uuid}/release", handleAdminDisputesRelease).Methods("POST")
+
+	subrouter.HandleFunc("/audit-events", handleAdminAuditEventsGet).Methods("GET")
+}
+
+// AdminAccountResponseDTO is what admins see of an account
+type AdminAccountResponseDTO struct {
+	ID            string    `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	Type          string    `json:"type"`
+	Suspended     bool      `json:"suspended"`
+}
+
+// AdminQualificationResponseDTO is a qualification along with the profile it is on
+type AdminQualificationResponseDTO struct {
+	QualificationsResponseDTO
+	ProfileID string `json:"profile_id"`
+}
+
+// AdminWorkExperienceResponseDTO is a work experience entry along with the profile it is on
+type AdminWorkExperienceResponseDTO struct {
+	WorkExperienceResponseDTO
+	ProfileID string `json:"profile_id"`
+}
+
+type AdminSubjectRequestResponseDTO struct {
+	ID          string    `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	RequesterID string    `json:"requester_id"`
+	Name        string    `json:"name"`
+	Status      string    `json:"status"`
+	Reason      string    `json:"reason,omitempty"`
+}
+
+// AdminSubjectRequestDenyDTO contains why a subject request was turned down
+type AdminSubjectRequestDenyDTO struct {
+	Reason string `json:"reason" validate:"required"`
+}
+
+// AdminDisputeResolveDTO contains why a dispute was resolved the way it was
+type AdminDisputeResolveDTO struct {
+	Resolution string `json:"resolution" validate:"required"`
+}
+
+type AdminAuditEventResponseDTO struct {
+	ID         string           `json:"id"`
+	CreatedAt  time.Time        `json:"created_at"`
+	Action     string           `json:"action"`
+	ActorID    *uuid.UUID       `json:"actor_id"`
+	TargetType string           `json:"target_type"`
+	TargetID   string           `json:"target_id"`
+	Before     *json.RawMessage `json:"before"`
+	After      *json.RawMessage `json:"after"`
+	RequestID  string           `json:"request_id"`
+}
+
+func dtoFromAdminAccount(a *services.Account) *AdminAccountResponseDTO {
+	return &AdminAccountResponseDTO{
+		ID:            a.ID.String(),
+		CreatedAt:     a.CreatedAt,
+		Email:         a.Email,
+		EmailVerified: a.EmailVerified,
+		Type:          string(a.Type),
+		Suspended:     a.Suspended,
+	}
+}
+
+// handleAdminAccountsGet lists accounts, they can be searched by email with query and filtered by type
+func handleAdminAccountsGet(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	pageSize, err := strconv.Atoi(q.Get("page_size"))
+	if err != nil || pageSize <= 0 {
+		pageSize = 10
+	}
+	page, err := strconv.Atoi(q.Get("page"))
+	if err != nil || page <= 0 {
+		page = 1
+	}
+
+	var accountType services.AccountType
+	if t := q.Get("type"); t != "" {
+		accountType = services.AccountType(t)
+	}
+
+	accounts, totalPages, err := services.ReadAccountsPaginated(q.Get("query"), accountType, pageSize, page)
+	if err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	outAccounts := []AdminAccountResponseDTO{}
+	for i := range accounts {
+		outAccounts = append(outAccounts, *dtoFromAdminAccount(&accounts[i]))
+	}
+
+	WriteBody(w, r, ToPaginatedDTO(totalPages, outAccounts))
+}
+
+func handleAdminAccountsSuspend(w http.ResponseWriter, r *http.Request) {
+	handleAdminAccountsSetSuspended(w, r, true)
+}
+
+func handleAdminAccountsUnsuspend(w http.ResponseWriter, r *http.Request) {
+	handleAdminAccountsSetSuspended(w, r, false)
+}
+
+func handleAdminAccountsSetSuspended(w http.ResponseWriter, r *http.Request, suspended bool) {
+	id, err := getUUID(r, "uuid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	authContext, err := ReadRequestAuthContext(r)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if suspended && authContext.Account.ID == id {
+		restError(w, r, services.AdminErrorSelfSuspend, http.StatusBadRequest)
+		return
+	}
+
+	account, err := services.SetAccountSuspended(r.Context(), id, suspended)
+	if err != nil {
+		restError(w, r, err, http.StatusNotFound)
+		return
+	}
+
+	WriteBody(w, r, dtoFromAdminAccount(account))
+}
+
+// handleAdminQualificationsGet lists the qualifications waiting to be verified
+func handleAdminQualificationsGet(w http.ResponseWriter, r *http.Request) {
+	qualifications, err := services.ReadUnverifiedQualifications()
+	if err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	outQualifications := []AdminQualificationResponseDTO{}
+	for _, q := range qualifications {
+		outQualifications = append(outQualifications, AdminQualificationResponseDTO{
+			QualificationsResponseDTO: QualificationsResponseDTO{
+				ID:       q.ID.String(),
+				Field:    q.Field,
+				Degree:   q.Degree,
+				School:   q.School,
+				Verified: q.Verified,
+			},
+			ProfileID: q.ProfileID.String(),
+		})
+	}
+
+	WriteBody(w, r, outQualifications)
+}
+
+func handleAdminQualificationsVerify(w http.ResponseWriter, r *http.Request) {
+	handleAdminEntry(w, r, services.VerifyQualification)
+}
+
+func handleAdminQualificationsReject(w http.ResponseWriter, r *http.Request) {
+	handleAdminEntry(w, r, services.RejectQualification)
+}
+
+// handleAdminWorkExperienceGet lists the work experience waiting to be verified
+func handleAdminWorkExperienceGet(w http.ResponseWriter, r *http.Request) {
+	workExperience, err := services.ReadUnverifiedWorkExperience()
+	if err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	outWorkExperience := []AdminWorkExperienceResponseDTO{}
+	for _, e := range workExperience {
+		outWorkExperience = append(outWorkExperience, AdminWorkExperienceResponseDTO{
+			WorkExperienceResponseDTO: WorkExperienceResponseDTO{
+				ID:          e.ID.String(),
+				Role:        e.Role,
+				YearsExp:    e.YearsExp,
+				Description: e.Description,
+				Verified:    e.Verified,
+			},
+			ProfileID: e.ProfileID.String(),
+		})
+	}
+
+	WriteBody(w, r, outWorkExperience)
+}
+
+func handleAdminWorkExperienceVerify(w http.ResponseWriter, r *http.Request) {
+	handleAdminEntry(w, r, services.VerifyWorkExperience)
+}
+
+func handleAdminWorkExperienceReject(w http.ResponseWriter, r *http.Request) {
+	handleAdminEntry(w, r, services.RejectWorkExperience)
+}
+
+// handleAdminEntry runs a moderation action on the entry in the uuid path parameter
+func handleAdminEntry(w http.ResponseWriter, r *http.Request, action func(id uuid.UUID) error) {
+	id, err := getUUID(r, "uuid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if err = action(id); err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleAdminSubjectRequestsGet lists subject requests with the status query parameter, pending ones by default
+func handleAdminSubjectRequestsGet(w http.ResponseWriter, r *http.Request) {
+	status := services.SubjectRequestStatus(r.URL.Query().Get("status"))
+	if status == "" {
+		status = services.SubjectRequestPending
+	}
+
+	requests, err := services.ReadSubjectRequestsByStatus(status)
+	if err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	outRequests := []AdminSubjectRequestResponseDTO{}
+	for _, request := range requests {
+		outRequests = append(outRequests, AdminSubjectRequestResponseDTO{
+			ID:          request.ID.String(),
+			CreatedAt:   request.CreatedAt,
+			RequesterID: request.RequesterID.String(),
+			Name:        request.Name,
+			Status:      string(request.Status),
+			Reason:      request.Reason,
+		})
+	}
+
+	WriteBody(w, r, outRequests)
+}
+
+func handleAdminSubjectRequestsApprove(w http.ResponseWriter, r *http.Request) {
+	id, err := getUUID(r, "uuid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	subject, err := services.ApproveSubjectRequest(id)
+	if err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	WriteBody(w, r, &SubjectResponseDTO{
+		ID:   subject.ID,
+		Name: subject.Name,
+		Slug: subject.Slug,
+	})
+}
+
+func handleAdminSubjectRequestsDeny(w http.ResponseWriter, r *http.Request) {
+	deny := &AdminSubjectRequestDenyDTO{}
+	if !ParseBody(w, r, deny) {
+		return
+	}
+
+	id, err := getUUID(r, "uuid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if err = services.DenySubjectRequest(id, deny.Reason); err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleAdminDisputesGet lists disputes with the status query parameter, open ones by default
+func handleAdminDisputesGet(w http.ResponseWriter, r *http.Request) {
+	status := services.DisputeStatus(r.URL.Query().Get("status"))
+	if status == "" {
+		status = services.DisputeOpen
+	}
+
+	disputes, err := services.ReadDisputesByStatus(status)
+	if err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	WriteBody(w, r, dtoFromDisputes(disputes))
+}
+
+func handleAdminDisputesRefund(w http.ResponseWriter, r *http.Request) {
+	handleAdminDisputesResolve(w, r, true)
+}
+
+func handleAdminDisputesRelease(w http.ResponseWriter, r *http.Request) {
+	handleAdminDisputesResolve(w, r, false)
+}
+
+// handleAdminDisputesResolve closes a dispute, refunding the student or releasing the payment to the tutor
+func handleAdminDisputesResolve(w http.ResponseWriter, r *http.Request, refund bool) {
+	resolve := &AdminDisputeResolveDTO{}
+	if !ParseBody(w, r, resolve) {
+		return
+	}
+
+	authContext, err := ReadRequestAuthContext(r)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	id, err := getUUID(r, "uuid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	err = services.ResolveDispute(r.Context(), id, authContext.Account, refund, resolve.Resolution)
+	if err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleAdminReviewsDelete(w http.ResponseWriter, r *http.Request) {
+	handleAdminEntry(w, r, services.DeleteReviewByID)
+}
+
+func auditJSONToRaw(s *string) *json.RawMessage {
+	if s == nil {
+		return nil
+	}
+	raw := json.RawMessage(*s)
+	return &raw
+}
+
+// handleAdminAuditEventsGet lists audit events newest first. They can be filtered with the action, actor_id, target_type,
+// target_id and request_id query parameters, and from and to as RFC 3339 times.
+func handleAdminAuditEventsGet(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	pageSize, err := strconv.Atoi(q.Get("page_size"))
+	if err != nil || pageSize <= 0 {
+		pageSize = 50
+	}
+	page, err := strconv.Atoi(q.Get("page"))
+	if err != nil || page <= 0 {
+		page = 1
+	}
+
+	filter := &services.AuditEventFilter{
+		Action:     services.AuditAction(q.Get("action")),
+		TargetType: services.AuditTargetType(q.Get("target_type")),
+		RequestID:  q.Get("request_id"),
+	}
+
+	for param, dest := range map[string]**uuid.UUID{"actor_id": &filter.ActorID, "target_id": &filter.TargetID} {
+		if v := q.Get(param); v != "" {
+			id, err := uuid.Parse(v)
+			if err != nil {
+				restError(w, r, fmt.Errorf("%s must be a uuid", param), http.StatusBadRequest)
+				return
+			}
+			*dest = &id
+		}
+	}
+
+	for param, dest := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		if v := q.Get(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				restError(w, r, fmt.Errorf("%s must be an RFC 3339 time", param), http.StatusBadRequest)
+				return
+			}
+			*dest = &t
+		}
+	}
+
+	events, totalPages, err := services.ReadAuditEventsPaginated(filter, pageSize, page)
+	if err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	outEvents := []AdminAuditEventResponseDTO{}
+	for _, event := range events {
+		outEvents = append(outEvents, AdminAuditEventResponseDTO{
+			ID:         event.ID.String(),
+			CreatedAt:  event.CreatedAt,
+			Action:     string(event.Action),
+			ActorID:    event.ActorID,
+			TargetType: string(event.TargetType),
+			TargetID:   event.TargetID.String(),
+			Before:     auditJSONToRaw(event.Before),
+			After:      auditJSONToRaw(event.After),
+			RequestID:  event.RequestID,
+		})
+	}
+
+	WriteBody(w, r, ToPaginatedDTO(totalPages, outEvents))
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"strings"
 	"time"
@@ -19,7 +20,28 @@ import (
 
 func InjectAuthRoutes(subrouter *mux.Router) {
 
+	// This has to come before /login as that matches anything starting with it
+	subrouter.HandleFunc("/login/two-factor", authLoginTwoFactor).Methods("POST")
 	subrouter.PathPrefix("/login").HandlerFunc(authLogin).Methods("POST")
+
+	// The token is emailed to the account, so this doesn't need a JWT
+	subrouter.HandleFunc("/verify-email", authVerifyEmail).Methods("POST")
+
+	subrouter.HandleFunc("/password-reset/request", authPasswordResetRequest).Methods("POST")
+	subrouter.HandleFunc("/password-reset/confirm", authPasswordResetConfirm).Methods("POST")
+
+	// Sign in with an OpenID Connect provider, the ui sends the user to the returned url and posts the code
+	// the provider redirects back with to the callback
+	subrouter.HandleFunc("/oidc/{provider}", authOIDCBegin).Methods("GET")
+	subrouter.HandleFunc("/oidc/{provider}/callback", authOIDCCallback).Methods("POST")
+
+	subrouter.HandleFunc("/refresh", authRefresh).Methods("POST")
+	subrouter.Handle("/logout", authRequired(http.HandlerFunc(authLogout))).Methods("POST")
+}
+
+// VerifyEmailDTO contains the token from an email verification link
+type VerifyEmailDTO struct {
+	Token string `json:"token" validate:"required"`
 }
 
 type LoginDTO struct {
@@ -29,7 +51,35 @@ type LoginDTO struct {
 }
 
 type LoginResponseDTO struct {
-	JWT string `json:"jwt"`
+	JWT          string `json:"jwt,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+
+	// If the account has two-factor authentication enabled no JWT is returned, instead the challenge token has to be
+	// sent to /auth/login/two-factor along with a code
+	TwoFactorRequired bool   `json:"two_factor_required,omitempty"`
+	ChallengeToken    string `json:"challenge_token,omitempty"`
+}
+
+// OIDCBeginResponseDTO contains the url of the provider to send the user to
+type OIDCBeginResponseDTO struct {
+	URL string `json:"url"`
+}
+
+// OIDCCallbackDTO contains the query parameters the provider redirected back to the ui with
+type OIDCCallbackDTO struct {
+	State string `json:"state" validate:"required"`
+	Code  string `json:"code" validate:"required"`
+}
+
+// LoginTwoFactorDTO finishes logging into an account with two-factor authentication enabled
+type LoginTwoFactorDTO struct {
+	ChallengeToken string `json:"challenge_token" validate:"required"`
+	Code           string `json:"code" validate:"required"`
+}
+
+// RefreshDTO swaps a refresh token for a new access token and refresh token
+type RefreshDTO struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
 }
 
 type AuthClaims struct {
@@ -41,6 +91,76 @@ func (ac *AuthClaims) Valid() error {
 	return ac.StandardClaims.Valid()
 }
 
+const (
+	accessTokenAudience        = "grindsapp"
+	twoFactorChallengeAudience = "grindsapp-two-factor"
+)
+
+// PasswordResetRequestDTO asks for a password reset link to be emailed
+type PasswordResetRequestDTO struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// PasswordResetConfirmDTO sets a new password using the token from a password reset link
+type PasswordResetConfirmDTO struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,passwd"`
+}
+
+func authPasswordResetRequest(w http.ResponseWriter, r *http.Request) {
+	var request PasswordResetRequestDTO
+
+	if !ParseBody(w, r, &request) {
+		return
+	}
+
+	// The reply is the same whether or not the email belongs to an account, so the reset
+	// is handled in the background to avoid giving that away through the response time
+	go func() {
+		if err := services.RequestPasswordReset(request.Email); err != nil {
+			log.WithError(err).Error("Could not send password reset")
+		}
+	}()
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func authPasswordResetConfirm(w http.ResponseWriter, r *http.Request) {
+	var confirm PasswordResetConfirmDTO
+
+	if !ParseBody(w, r, &confirm) {
+		return
+	}
+
+	hash, err := services.NewPasswordHash(confirm.Password)
+	if err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	if _, err = services.ResetPassword(r.Context(), confirm.Token, hash); err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func authVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var verify VerifyEmailDTO
+
+	if !ParseBody(w, r, &verify) {
+		return
+	}
+
+	if _, err := services.VerifyEmail(verify.Token); err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
 func authLogin(w http.ResponseWriter, r *http.Request) {
 	var login LoginDTO
 
@@ -48,51 +168,289 @@ func authLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ip := clientIP(r)
+	if err := services.CheckLoginAllowed(ip, login.Email); err != nil {
+		restError(w, r, err, http.StatusTooManyRequests)
+		return
+	}
+
 	acc, err := services.ReadAccountByEmail(login.Email, nil)
 	if err != nil {
-		restError(w, r, errors.New("invalid email or password"), http.StatusForbidden)
+		authLoginFailed(w, r, ip, login.Email)
 		return
 	}
 
+	// Accounts that only sign in with a provider have no password, they fail the same way as a wrong password so
+	// logging in doesn't reveal how an account signs in
 	hash, err := services.ReadPasswordHashByAccountID(acc.ID)
 	if err != nil {
-		restError(w, r, errors.New("unknown error occured during authentication"), http.StatusForbidden)
+		authLoginFailed(w, r, ip, login.Email)
 		return
 	}
 
 	if hash.ValidMatch(login.Password) {
-		claims := &AuthClaims{
-			StandardClaims: jwt.StandardClaims{
-				Issuer:    "grindsapp",
-				Subject:   acc.ID.String(),
-				Audience:  "grindsapp",
-				IssuedAt:  int64(time.Now().Unix()),
-				ExpiresAt: int64(time.Now().Add(time.Second * time.Duration(viper.GetUint64("auth.jwt.ttl"))).Unix()),
-			},
-			Email: acc.Email,
-		}
+		authFirstFactorPassed(w, r, acc)
+	} else {
+		authLoginFailed(w, r, ip, login.Email)
+		return
+	}
+
+}
+
+// authFirstFactorPassed finishes logging in an account that has proven who they are, unless it
+// needs a two-factor code in which case a challenge token for it is returned instead
+func authFirstFactorPassed(w http.ResponseWriter, r *http.Request, acc *services.Account) {
+	twoFactor, err := acc.TwoFactorEnabled()
+	if err != nil {
+		restError(w, r, errors.New("unknown error occured during authentication"), http.StatusForbidden)
+		return
+	}
 
-		jwtStr, err := claims.serializeSignJWT()
+	// Failed logins aren't cleared until the code has been given too, so they still count towards a lockout
+	if twoFactor {
+		challenge, err := newTwoFactorChallengeToken(acc)
 		if err != nil {
 			restError(w, r, errors.New("unknown error occured during authentication"), http.StatusForbidden)
 			return
 		}
 
-		if err = json.NewEncoder(w).Encode(&LoginResponseDTO{JWT: jwtStr}); err != nil {
-			restError(w, r, err, http.StatusInternalServerError)
+		WriteBody(w, r, &LoginResponseDTO{TwoFactorRequired: true, ChallengeToken: challenge})
+		return
+	}
+
+	if err = services.RecordLoginSuccess(acc.Email); err != nil {
+		log.WithError(err).Error("Could not clear failed logins")
+	}
+
+	authStartSession(w, r, acc, false)
+}
+
+// authOIDCBegin starts signing in with a provider, the type query parameter is the type of account to
+// create if this is the first time the identity has been used
+func authOIDCBegin(w http.ResponseWriter, r *http.Request) {
+	var accountType services.AccountType
+	if t := r.URL.Query().Get("type"); t != "" {
+		var err error
+		accountType, err = services.ToAccountType(t)
+		if err != nil {
+			restError(w, r, err, http.StatusBadRequest)
 			return
 		}
-	} else {
-		restError(w, r, errors.New("invalid email or password"), http.StatusForbidden)
+	}
+
+	url, err := services.BeginOIDCLogin(mux.Vars(r)["provider"], accountType)
+	if err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	WriteBody(w, r, &OIDCBeginResponseDTO{
+		URL: url,
+	})
+}
+
+func authOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	var callback OIDCCallbackDTO
+
+	if !ParseBody(w, r, &callback) {
+		return
+	}
+
+	acc, err := services.FinishOIDCLogin(mux.Vars(r)["provider"], callback.State, callback.Code)
+	if err != nil {
+		restError(w, r, err, http.StatusForbidden)
+		return
+	}
+
+	if acc.Suspended {
+		restError(w, r, errors.New("this account has been suspended"), http.StatusForbidden)
+		return
+	}
+
+	authFirstFactorPassed(w, r, acc)
+}
+
+func authLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var login LoginTwoFactorDTO
+
+	if !ParseBody(w, r, &login) {
+		return
+	}
+
+	token, err := parseVerifyJWT(login.ChallengeToken, &jwt.StandardClaims{})
+	if err != nil {
+		restError(w, r, errors.New("two-factor challenge is invalid or has expired, please log in again"), http.StatusForbidden)
+		return
+	}
+
+	claims, ok := token.Claims.(*jwt.StandardClaims)
+	if !ok || !claims.VerifyAudience(twoFactorChallengeAudience, true) {
+		restError(w, r, errors.New("two-factor challenge is invalid or has expired, please log in again"), http.StatusForbidden)
+		return
+	}
+
+	accountID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		restError(w, r, errors.New("could not parse sub claim on jwt, expected account uuid"), http.StatusForbidden)
+		return
+	}
+
+	acc, err := services.ReadAccountByID(accountID, nil)
+	if err != nil {
+		restError(w, r, errors.New("unknown error occured during authentication"), http.StatusForbidden)
+		return
+	}
+
+	if acc.Suspended {
+		restError(w, r, errors.New("this account has been suspended"), http.StatusForbidden)
+		return
+	}
+
+	// Codes are throttled the same way as passwords so the challenge can't be used to guess them
+	ip := clientIP(r)
+	if err = services.CheckLoginAllowed(ip, acc.Email); err != nil {
+		restError(w, r, err, http.StatusTooManyRequests)
+		return
+	}
+
+	if err = acc.VerifyTwoFactor(login.Code); err != nil {
+		if err := services.RecordLoginFailure(ip, acc.Email); err != nil {
+			log.WithError(err).Error("Could not record failed login")
+		}
+		restError(w, r, err, http.StatusForbidden)
+		return
+	}
+
+	if err = services.RecordLoginSuccess(acc.Email); err != nil {
+		log.WithError(err).Error("Could not clear failed logins")
+	}
+
+	authStartSession(w, r, acc, true)
+}
+
+// authStartSession starts a session for an account that has logged in and returns its tokens
+func authStartSession(w http.ResponseWriter, r *http.Request, acc *services.Account, twoFactorVerified bool) {
+	session, refreshToken, err := services.CreateSession(acc.ID, twoFactorVerified)
+	if err != nil {
+		restError(w, r, errors.New("unknown error occured during authentication"), http.StatusForbidden)
+		return
+	}
+
+	jwtStr, err := newAccessToken(acc, session)
+	if err != nil {
+		restError(w, r, errors.New("unknown error occured during authentication"), http.StatusForbidden)
 		return
 	}
 
+	if err = json.NewEncoder(w).Encode(&LoginResponseDTO{JWT: jwtStr, RefreshToken: refreshToken}); err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+// authLoginFailed records a failed login and tells the user their email or password was wrong
+func authLoginFailed(w http.ResponseWriter, r *http.Request, ip string, email string) {
+	if err := services.RecordLoginFailure(ip, email); err != nil {
+		log.WithError(err).Error("Could not record failed login")
+	}
+
+	restError(w, r, errors.New("invalid email or password"), http.StatusForbidden)
+}
+
+// clientIP returns the address a request came from without its port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
 
-func parseVerifyJWT(jwtStr string) (*jwt.Token, error) {
+func authRefresh(w http.ResponseWriter, r *http.Request) {
+	var refresh RefreshDTO
+
+	if !ParseBody(w, r, &refresh) {
+		return
+	}
+
+	session, refreshToken, err := services.RefreshSession(refresh.RefreshToken)
+	if err != nil {
+		restError(w, r, err, http.StatusForbidden)
+		return
+	}
+
+	acc, err := services.ReadAccountByID(session.AccountID, nil)
+	if err != nil {
+		restError(w, r, err, http.StatusForbidden)
+		return
+	}
+
+	if acc.Suspended {
+		restError(w, r, errors.New("this account has been suspended"), http.StatusForbidden)
+		return
+	}
+
+	jwtStr, err := newAccessToken(acc, session)
+	if err != nil {
+		restError(w, r, errors.New("unknown error occured during authentication"), http.StatusForbidden)
+		return
+	}
+
+	WriteBody(w, r, &LoginResponseDTO{JWT: jwtStr, RefreshToken: refreshToken})
+}
+
+// authLogout revokes the session the request was authenticated with
+func authLogout(w http.ResponseWriter, r *http.Request) {
+	authContext, err := ReadRequestAuthContext(r)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if err = services.RevokeSession(authContext.Session.ID); err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// newAccessToken issues a JWT for the account, the jti is the session it belongs to
+func newAccessToken(acc *services.Account, session *services.Session) (string, error) {
+	claims := &AuthClaims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        session.ID.String(),
+			Issuer:    "grindsapp",
+			Subject:   acc.ID.String(),
+			Audience:  accessTokenAudience,
+			IssuedAt:  int64(time.Now().Unix()),
+			ExpiresAt: int64(time.Now().Add(time.Second * time.Duration(viper.GetUint64("auth.jwt.ttl"))).Unix()),
+		},
+		Email: acc.Email,
+	}
+
+	return claims.serializeSignJWT()
+}
+
+// newTwoFactorChallengeToken issues a short-lived token that proves the password of the account was given,
+// it can only be used to finish logging in with a two-factor code
+func newTwoFactorChallengeToken(acc *services.Account) (string, error) {
+	claims := &jwt.StandardClaims{
+		Id:        uuid.New().String(),
+		Issuer:    "grindsapp",
+		Subject:   acc.ID.String(),
+		Audience:  twoFactorChallengeAudience,
+		IssuedAt:  int64(time.Now().Unix()),
+		ExpiresAt: int64(time.Now().Add(time.Second * time.Duration(viper.GetUint64("auth.two_factor.challenge_ttl"))).Unix()),
+	}
+
+	return signJWT(claims)
+}
+
+func parseVerifyJWT(jwtStr string, claims jwt.Claims) (*jwt.Token, error) {
 	token, err := new(jwt.Parser).ParseWithClaims(
 		jwtStr,
-		&AuthClaims{},
+		claims,
 		func(token *jwt.Token) (interface{}, error) {
 			if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
 				return nil, errors.New("expected rsa signed jwt")
@@ -116,12 +474,16 @@ func parseVerifyJWT(jwtStr string) (*jwt.Token, error) {
 }
 
 func (a *AuthClaims) serializeSignJWT() (string, error) {
+	return signJWT(a)
+}
+
+func signJWT(claims jwt.Claims) (string, error) {
 	signKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(viper.GetString("auth.jwt.private_key")))
 	if err != nil {
 		return "", err
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, a)
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
 
 	jwtStr, err := token.SignedString(signKey)
 	if err != nil {
@@ -134,6 +496,7 @@ func (a *AuthClaims) serializeSignJWT() (string, error) {
 type AuthContext struct {
 	Claims  *AuthClaims
 	Account *services.Account
+	Session *services.Session
 }
 
 func (ac *AuthContext) Authenticated() bool {
@@ -175,6 +538,59 @@ func authRequired(next http.Handler) http.Handler {
 	}, true)(next)
 }
 
+// authContextFromJWT verifies a JWT and loads the account it was issued to
+func authContextFromJWT(jwtStr string) (*AuthContext, error) {
+	token, err := parseVerifyJWT(jwtStr, &AuthClaims{})
+	if err != nil {
+		return nil, errors.New("error verifying jwt")
+	}
+
+	claims, ok := token.Claims.(*AuthClaims)
+	if !ok {
+		return nil, errors.New("jwt claims invalid")
+	}
+
+	// Other tokens such as two-factor challenges are signed with the same key
+	if !claims.VerifyAudience(accessTokenAudience, true) {
+		return nil, errors.New("jwt is not an access token")
+	}
+
+	accountID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, errors.New("could not parse sub claim on jwt, expected account uuid")
+	}
+
+	account, err := services.ReadAccountByID(accountID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error occured when looking for account: %s", err)
+	}
+
+	if account.Suspended == true {
+		return nil, errors.New("this account has been suspended")
+	}
+
+	// The jti is the session the token was issued for, the token stops working once it is revoked
+	sessionID, err := uuid.Parse(claims.Id)
+	if err != nil {
+		return nil, errors.New("could not parse jti claim on jwt, expected session uuid")
+	}
+
+	session, err := services.ReadActiveSessionByID(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if session.AccountID != account.ID {
+		return nil, services.SessionErrorInvalid
+	}
+
+	return &AuthContext{
+		Claims:  claims,
+		Account: account,
+		Session: session,
+	}, nil
+}
+
 func authMiddleware(userSuppliedAuthCtxValidator func(w http.ResponseWriter, r *http.Request, ac *AuthContext) error, required bool) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -205,48 +621,22 @@ func authMiddleware(userSuppliedAuthCtxValidator func(w http.ResponseWriter, r *
 
 				jwtStr := bearerCheck[1]
 
-				token, err := parseVerifyJWT(jwtStr)
+				authContext, err := authContextFromJWT(jwtStr)
 				if err != nil {
-					restError(w, r, errors.New("error verifying jwt"), http.StatusForbidden)
+					restError(w, r, err, http.StatusForbidden)
 					return
 				}
 
-				if claims, ok := token.Claims.(*AuthClaims); ok {
-					uuid, err := uuid.Parse(claims.Subject)
-					if err != nil {
-						restError(w, r, errors.New("could not parse sub claim on jwt, expected account uuid"), http.StatusForbidden)
-						return
-					}
-
-					account, err := services.ReadAccountByID(uuid, nil)
-					if err != nil {
-						restError(w, r, fmt.Errorf("error occured when looking for account: %s", err), http.StatusForbidden)
-						return
-					}
-
-					if account.Suspended == true {
-						restError(w, r, errors.New("this account has been suspended"), http.StatusForbidden)
-						return
-					}
-
-					authContext := &AuthContext{
-						Claims:  claims,
-						Account: account,
-					}
-
-					err = userSuppliedAuthCtxValidator(w, r, authContext)
-					if err != nil {
-						restError(w, r, err, http.StatusForbidden)
-						return
-					}
-
-					ctx := context.WithValue(r.Context(), authContextKey, authContext)
-
-					next.ServeHTTP(w, r.WithContext(ctx))
+				err = userSuppliedAuthCtxValidator(w, r, authContext)
+				if err != nil {
+					restError(w, r, err, http.StatusForbidden)
 					return
 				}
 
-				restError(w, r, errors.New("jwt claims invalid"), http.StatusForbidden)
+				ctx := context.WithValue(r.Context(), authContextKey, authContext)
+				ctx = services.WithActor(ctx, authContext.Account.ID)
+
+				next.ServeHTTP(w, r.WithContext(ctx))
 				return
 			}
 
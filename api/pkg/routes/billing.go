@@ -0,0 +1,101 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/cs3305-team-4/api/pkg/services"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+
+	stripe "github.com/stripe/stripe-go/v72"
+	stripeWebhook "github.com/stripe/stripe-go/v72/webhook"
+)
+
+// Stripe webhook payloads are well under this size
+const billingWebhookMaxBodyBytes = 65536
+
+func InjectBillingRoutes(subrouter *mux.Router) {
+	// Stripe signs webhook requests, they don't carry a JWT
+	subrouter.HandleFunc("/webhook", handleBillingWebhook).Methods("POST")
+}
+
+func handleBillingWebhook(w http.ResponseWriter, r *http.Request) {
+	payload, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, billingWebhookMaxBodyBytes))
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	event, err := stripeWebhook.ConstructEvent(payload, r.Header.Get("Stripe-Signature"), viper.GetString("billing.stripe.webhook_secret"))
+	if err != nil {
+		restError(w, r, errors.New("could not verify webhook signature"), http.StatusBadRequest)
+		return
+	}
+
+	var handle func(tx *gorm.DB) error
+
+	switch event.Type {
+	case "payment_intent.succeeded":
+		var intent stripe.PaymentIntent
+		if err = json.Unmarshal(event.Data.Raw, &intent); err != nil {
+			restError(w, r, err, http.StatusBadRequest)
+			return
+		}
+		handle = func(tx *gorm.DB) error {
+			return services.LessonPaymentSucceeded(r.Context(), tx, intent.ID)
+		}
+
+	case "payment_intent.payment_failed":
+		var intent stripe.PaymentIntent
+		if err = json.Unmarshal(event.Data.Raw, &intent); err != nil {
+			restError(w, r, err, http.StatusBadRequest)
+			return
+		}
+		handle = func(tx *gorm.DB) error {
+			return services.LessonPaymentFailed(tx, intent.ID)
+		}
+
+	case "charge.refunded":
+		var charge stripe.Charge
+		if err = json.Unmarshal(event.Data.Raw, &charge); err != nil {
+			restError(w, r, err, http.StatusBadRequest)
+			return
+		}
+		if charge.PaymentIntent == nil {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		handle = func(tx *gorm.DB) error {
+			return services.LessonPaymentRefunded(tx, charge.PaymentIntent.ID)
+		}
+
+	case "account.updated":
+		var account stripe.Account
+		if err = json.Unmarshal(event.Data.Raw, &account); err != nil {
+			restError(w, r, err, http.StatusBadRequest)
+			return
+		}
+		// Onboarding status is read from Stripe when needed, so the update only needs to be acknowledged
+		handle = func(tx *gorm.DB) error {
+			log.WithContext(r.Context()).Infof("stripe account %s updated, charges enabled: %t", account.ID, account.ChargesEnabled)
+			return nil
+		}
+
+	default:
+		// Acknowledge events we don't use so Stripe doesn't retry them
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if err = services.HandleBillingEvent(event.ID, event.Type, handle); err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,283 @@
+package routes
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cs3305-team-4/api/pkg/services"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// ConversationResponseDTO represents a conversation as seen by one of its participants
+type ConversationResponseDTO struct {
+	ID            uuid.UUID  `json:"id"`
+	AccountID     uuid.UUID  `json:"account_id"`
+	LastMessageAt *time.Time `json:"last_message_at"`
+	UnreadCount   int64      `json:"unread_count"`
+}
+
+// ConversationStartRequestDTO represents a request to message an account
+type ConversationStartRequestDTO struct {
+	RecipientID uuid.UUID `json:"recipient_id" validate:"required"`
+	Body        string    `json:"body" validate:"required"`
+}
+
+// MessageRequestDTO represents a message sent in a conversation
+type MessageRequestDTO struct {
+	Body string `json:"body" validate:"required"`
+}
+
+// MessageResponseDTO represents a message sent in a conversation
+type MessageResponseDTO struct {
+	ID       uuid.UUID  `json:"id"`
+	SenderID uuid.UUID  `json:"sender_id"`
+	Body     string     `json:"body"`
+	SentAt   time.Time  `json:"sent_at"`
+	ReadAt   *time.Time `json:"read_at"`
+}
+
+// UnreadCountResponseDTO represents the number of unread messages of an account
+type UnreadCountResponseDTO struct {
+	Unread int64 `json:"unread"`
+}
+
+func dtoFromConversationSummary(c *services.ConversationSummary, accountID uuid.UUID) *ConversationResponseDTO {
+	return &ConversationResponseDTO{
+		ID:            c.ID,
+		AccountID:     c.OtherParticipantID(accountID),
+		LastMessageAt: c.LastMessageAt,
+		UnreadCount:   c.UnreadCount,
+	}
+}
+
+func dtoFromMessage(m *services.Message) *MessageResponseDTO {
+	return &MessageResponseDTO{
+		ID:       m.ID,
+		SenderID: m.SenderID,
+		Body:     m.Body,
+		SentAt:   m.CreatedAt,
+		ReadAt:   m.ReadAt,
+	}
+}
+
+func dtoFromMessages(messages []services.Message) []MessageResponseDTO {
+	dtoMessages := []MessageResponseDTO{}
+
+	for _, m := range messages {
+		dtoMessages = append(dtoMessages, *dtoFromMessage(&m))
+	}
+
+	return dtoMessages
+}
+
+func InjectConversationsRoutes(subrouter *mux.Router) {
+	// User needs an account to message anyone
+	subrouter.Use(authRequired)
+
+	// GET /
+	subrouter.HandleFunc("", handleConversationsGet).Methods("GET")
+
+	// POST /
+	subrouter.HandleFunc("", handleConversationsPost).Methods("POST")
+
+	// GET /unread
+	subrouter.HandleFunc("/unread", handleConversationsUnreadGet).Methods("GET")
+
+	conversationResource := subrouter.PathPrefix("/{uuid}").Subrouter()
+
+	// Only allow users access to conversations they are in
+	conversationResource.Use(authMiddleware(
+		func(w http.ResponseWriter, r *http.Request, ac *AuthContext) error {
+			id, err := getUUID(r, "uuid")
+			if err != nil {
+				return err
+			}
+
+			conversation, err := services.ReadConversationByID(id)
+			if err != nil {
+				return err
+			}
+
+			if !conversation.IsParticipant(ac.Account.ID) {
+				return services.ConversationErrorNotParticipant
+			}
+
+			return nil
+		}, true,
+	))
+
+	// GET /{uuid}/messages
+	conversationResource.HandleFunc("/messages", handleConversationsMessagesGet).Methods("GET")
+
+	// POST /{uuid}/messages
+	conversationResource.HandleFunc("/messages", handleConversationsMessagesPost).Methods("POST")
+
+	// POST /{uuid}/read
+	conversationResource.HandleFunc("/read", handleConversationsRead).Methods("POST")
+}
+
+func handleConversationsGet(w http.ResponseWriter, r *http.Request) {
+	authContext, err := ReadRequestAuthContext(r)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	conversations, err := services.ReadConversationsByAccountID(authContext.Account.ID)
+	if err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	dtoConversations := []ConversationResponseDTO{}
+	for _, c := range conversations {
+		dtoConversations = append(dtoConversations, *dtoFromConversationSummary(&c, authContext.Account.ID))
+	}
+
+	WriteBody(w, r, dtoConversations)
+}
+
+// handleConversationsPost sends the first message to an account, reusing the existing conversation if there is one
+func handleConversationsPost(w http.ResponseWriter, r *http.Request) {
+	authContext, err := ReadRequestAuthContext(r)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	request := &ConversationStartRequestDTO{}
+	if !ParseBody(w, r, request) {
+		return
+	}
+
+	conversation, err := services.StartConversation(authContext.Account, request.RecipientID, request.Body)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	summaries, err := services.ReadConversationsByAccountID(authContext.Account.ID)
+	if err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	for _, c := range summaries {
+		if c.ID == conversation.ID {
+			WriteBody(w, r, dtoFromConversationSummary(&c, authContext.Account.ID))
+			return
+		}
+	}
+}
+
+func handleConversationsUnreadGet(w http.ResponseWriter, r *http.Request) {
+	authContext, err := ReadRequestAuthContext(r)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	unread, err := services.UnreadMessageCount(authContext.Account.ID)
+	if err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	WriteBody(w, r, &UnreadCountResponseDTO{Unread: unread})
+}
+
+// handleConversationsMessagesGet returns the messages of a conversation, newest messages first
+func handleConversationsMessagesGet(w http.ResponseWriter, r *http.Request) {
+	id, err := getUUID(r, "uuid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	q := r.URL.Query()
+	pageSize, err := strconv.Atoi(q.Get("page_size"))
+	if err != nil || pageSize <= 0 {
+		pageSize = 50
+	}
+	page, err := strconv.Atoi(q.Get("page"))
+	if err != nil || page <= 0 {
+		page = 1
+	}
+
+	conversation, err := services.ReadConversationByID(id)
+	if err != nil {
+		restError(w, r, err, http.StatusNotFound)
+		return
+	}
+
+	messages, totalPages, err := conversation.ReadMessagesPaginated(pageSize, page)
+	if err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	WriteBody(w, r, ToPaginatedDTO(totalPages, dtoFromMessages(messages)))
+}
+
+func handleConversationsMessagesPost(w http.ResponseWriter, r *http.Request) {
+	authContext, err := ReadRequestAuthContext(r)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	id, err := getUUID(r, "uuid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	request := &MessageRequestDTO{}
+	if !ParseBody(w, r, request) {
+		return
+	}
+
+	conversation, err := services.ReadConversationByID(id)
+	if err != nil {
+		restError(w, r, err, http.StatusNotFound)
+		return
+	}
+
+	message, err := conversation.SendMessage(authContext.Account, request.Body)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	WriteBody(w, r, dtoFromMessage(message))
+}
+
+// handleConversationsRead marks every message sent to the caller in the conversation as read
+func handleConversationsRead(w http.ResponseWriter, r *http.Request) {
+	authContext, err := ReadRequestAuthContext(r)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	id, err := getUUID(r, "uuid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	conversation, err := services.ReadConversationByID(id)
+	if err != nil {
+		restError(w, r, err, http.StatusNotFound)
+		return
+	}
+
+	if err = conversation.MarkRead(authContext.Account); err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
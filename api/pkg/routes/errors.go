@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/cs3305-team-4/api/pkg/services"
@@ -25,6 +26,11 @@ type returnError struct {
 func restError(w http.ResponseWriter, r *http.Request, err error, code int) {
 	log.WithContext(r.Context()).WithError(err).Error("REST error")
 
+	var throttled *services.LoginThrottledError
+	if errors.As(err, &throttled) {
+		w.Header().Set("Retry-After", strconv.FormatInt(throttled.RetryAfterSeconds(), 10))
+	}
+
 	err, code = customErrors(err, code)
 	w.WriteHeader(code)
 	outErr := returnError{
@@ -54,6 +60,118 @@ func customErrors(in error, code int) (out error, codeOut int) {
 		codeOut = http.StatusNotFound
 	case errors.Is(in, services.AccountErrorEntryDoesNotExists):
 		codeOut = http.StatusNotFound
+	case errors.Is(in, services.AccountErrorEmailNotVerified):
+		codeOut = http.StatusForbidden
+	case errors.Is(in, services.AccountErrorEmailAlreadyVerified):
+		fallthrough
+	case errors.Is(in, services.AccountErrorInvalidTimeZone):
+		fallthrough
+	case errors.Is(in, services.EmailVerificationErrorInvalid):
+		fallthrough
+	case errors.Is(in, services.EmailVerificationErrorExpired):
+		fallthrough
+	case errors.Is(in, services.EmailVerificationErrorUsed):
+		fallthrough
+	case errors.Is(in, services.PasswordResetErrorInvalid):
+		codeOut = http.StatusBadRequest
+	case errors.Is(in, services.SessionErrorInvalid):
+		codeOut = http.StatusUnauthorized
+	case errors.As(in, new(*services.LoginThrottledError)):
+		codeOut = http.StatusTooManyRequests
+	case errors.Is(in, services.TwoFactorErrorAlreadyEnabled):
+		fallthrough
+	case errors.Is(in, services.TwoFactorErrorNotEnrolled):
+		codeOut = http.StatusBadRequest
+	case errors.Is(in, services.TwoFactorErrorInvalidCode):
+		fallthrough
+	case errors.Is(in, services.TwoFactorErrorRequired):
+		codeOut = http.StatusForbidden
+	case errors.Is(in, services.OIDCErrorUnknownProvider):
+		codeOut = http.StatusNotFound
+	case errors.Is(in, services.OIDCErrorInvalidState):
+		fallthrough
+	case errors.Is(in, services.OIDCErrorAccountTypeRequired):
+		codeOut = http.StatusBadRequest
+	case errors.Is(in, services.OIDCErrorInvalidIDToken):
+		fallthrough
+	case errors.Is(in, services.OIDCErrorEmailInUse):
+		codeOut = http.StatusForbidden
+	case errors.Is(in, services.AdminErrorSubjectRequestReviewed):
+		fallthrough
+	case errors.Is(in, services.AdminErrorSelfSuspend):
+		codeOut = http.StatusBadRequest
+	case errors.Is(in, services.AuditErrorImmutable):
+		codeOut = http.StatusForbidden
+	case errors.Is(in, services.LessonErrorTransitionNotAllowed):
+		codeOut = http.StatusConflict
+	case errors.Is(in, services.LessonErrorActorNotAllowed):
+		codeOut = http.StatusForbidden
+	case errors.Is(in, services.LessonErrorNotPaid):
+		fallthrough
+	case errors.Is(in, services.LessonErrorNoStartTime):
+		fallthrough
+	case errors.Is(in, services.LessonErrorNoShowWindow):
+		codeOut = http.StatusBadRequest
+	case errors.Is(in, services.SubjectTaughtErrorDoesNotExist):
+		codeOut = http.StatusNotFound
+	case errors.Is(in, services.SubjectTaughtErrorInvalidDuration):
+		fallthrough
+	case errors.Is(in, services.SubjectTaughtErrorDurationNotOffered):
+		codeOut = http.StatusBadRequest
+	case errors.Is(in, services.AvailabilityErrorTutorUnavailable):
+		fallthrough
+	case errors.Is(in, services.AvailabilityErrorOnVacation):
+		fallthrough
+	case errors.Is(in, services.GroupSessionErrorFull):
+		fallthrough
+	case errors.Is(in, services.GroupSessionErrorAlreadyEnrolled):
+		codeOut = http.StatusConflict
+	case errors.Is(in, services.AvailabilityErrorInvalidRange):
+		fallthrough
+	case errors.Is(in, services.AvailabilityErrorInvalidException):
+		fallthrough
+	case errors.Is(in, services.LessonSeriesErrorInvalidRecurrence):
+		fallthrough
+	case errors.Is(in, services.LessonSeriesErrorTooLong):
+		fallthrough
+	case errors.Is(in, services.LessonSeriesErrorNotInSeries):
+		fallthrough
+	case errors.Is(in, services.LessonSeriesErrorNoLessons):
+		fallthrough
+	case errors.Is(in, services.GroupSessionErrorInvalidCapacity):
+		fallthrough
+	case errors.Is(in, services.GroupSessionErrorInvalidDeadline):
+		fallthrough
+	case errors.Is(in, services.GroupSessionErrorNotEnrolling):
+		fallthrough
+	case errors.Is(in, services.GroupSessionErrorNotEnrolled):
+		fallthrough
+	case errors.Is(in, services.GroupSessionErrorNotCancellable):
+		codeOut = http.StatusBadRequest
+	case errors.Is(in, services.DisputeErrorNotDisputable):
+		fallthrough
+	case errors.Is(in, services.DisputeErrorPaidOut):
+		fallthrough
+	case errors.Is(in, services.DisputeErrorAlreadyOpen):
+		fallthrough
+	case errors.Is(in, services.DisputeErrorResolved):
+		codeOut = http.StatusConflict
+	case errors.Is(in, services.SignallingErrorNotParticipant):
+		fallthrough
+	case errors.Is(in, services.SignallingErrorNotScheduled):
+		fallthrough
+	case errors.Is(in, services.SignallingErrorNotSeated):
+		fallthrough
+	case errors.Is(in, services.SignallingErrorNotConfirmed):
+		fallthrough
+	case errors.Is(in, services.SignallingErrorClosed):
+		codeOut = http.StatusForbidden
+	case errors.Is(in, services.ConversationErrorNotParticipant):
+		codeOut = http.StatusForbidden
+	case errors.Is(in, services.ConversationErrorSelf):
+		fallthrough
+	case errors.Is(in, services.ConversationErrorEmptyMessage):
+		codeOut = http.StatusBadRequest
 	case errors.Is(in, gorm.ErrRecordNotFound):
 		codeOut = http.StatusNotFound
 		out = errors.New("No record matching provided ID found.")
@@ -0,0 +1,361 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/cs3305-team-4/api/pkg/services"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// GroupSessionRequestDTO represents a group session a tutor wants to run
+type GroupSessionRequestDTO struct {
+	SubjectTaughtID uuid.UUID `json:"subject_taught_id" validate:"required"`
+	Title           string    `json:"title" validate:"required,lte=100"`
+	Description     string    `json:"description" validate:"omitempty,lte=1000"`
+
+	// Time of the session
+	StartTime time.Time `json:"start_time" validate:"required"`
+
+	// Duration of the session in minutes, it must be one the tutor offers for the subject. Defaults to 60
+	Duration int `json:"duration"`
+
+	Capacity     int `json:"capacity" validate:"required,gte=2,lte=50"`
+	MinEnrolment int `json:"min_enrolment" validate:"required,gte=1"`
+
+	// EnrolmentDeadline defaults to the start of the session
+	EnrolmentDeadline time.Time `json:"enrolment_deadline"`
+
+	// SeatPrice defaults to the price of the subject for the duration
+	SeatPrice int64 `json:"seat_price" validate:"gte=0"`
+}
+
+// GroupSeatResponseDTO represents a student's seat in a group session
+type GroupSeatResponseDTO struct {
+	ID          uuid.UUID `json:"id"`
+	StudentID   uuid.UUID `json:"student_id"`
+	PriceAmount int64     `json:"price_amount"`
+	Paid        bool      `json:"paid"`
+	Refunded    bool      `json:"refunded"`
+}
+
+// GroupSessionResponseDTO represents an existing group session
+type GroupSessionResponseDTO struct {
+	ID              uuid.UUID `json:"id"`
+	TutorID         uuid.UUID `json:"tutor_id"`
+	SubjectTaughtID uuid.UUID `json:"subject_taught_id"`
+	SubjectID       uuid.UUID `json:"subject_id"`
+	SubjectName     string    `json:"subject_name"`
+	Title           string    `json:"title"`
+	Description     string    `json:"description"`
+
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+
+	// Duration of the session in minutes
+	Duration int `json:"duration"`
+
+	Capacity          int       `json:"capacity"`
+	MinEnrolment      int       `json:"min_enrolment"`
+	EnrolmentDeadline time.Time `json:"enrolment_deadline"`
+	SeatPrice         int64     `json:"seat_price"`
+	SeatsTaken        int       `json:"seats_taken"`
+
+	Stage       services.GroupSessionStage `json:"stage"`
+	StageDetail string                     `json:"stage_detail"`
+
+	// Seats are every seat for the tutor, and only their own seat for a student
+	Seats []GroupSeatResponseDTO `json:"seats,omitempty"`
+}
+
+func dtoFromGroupSeat(s *services.GroupSeat) *GroupSeatResponseDTO {
+	return &GroupSeatResponseDTO{
+		ID:          s.ID,
+		StudentID:   s.StudentID,
+		PriceAmount: s.PriceAmount,
+		Paid:        s.Paid,
+		Refunded:    s.Refunded,
+	}
+}
+
+// dtoFromGroupSession renders the session for the viewer, uuid.Nil if nobody is signed in
+func dtoFromGroupSession(g *services.GroupSession, viewer uuid.UUID) *GroupSessionResponseDTO {
+	seats := []GroupSeatResponseDTO{}
+	for i := range g.Seats {
+		if g.Seats[i].Withdrawn {
+			continue
+		}
+		if viewer == g.TutorID || viewer == g.Seats[i].StudentID {
+			seats = append(seats, *dtoFromGroupSeat(&g.Seats[i]))
+		}
+	}
+
+	return &GroupSessionResponseDTO{
+		ID:                g.ID,
+		TutorID:           g.TutorID,
+		SubjectTaughtID:   g.SubjectTaughtID,
+		SubjectID:         g.SubjectID,
+		SubjectName:       g.SubjectTaught.Subject.Name,
+		Title:             g.Title,
+		Description:       g.Description,
+		StartTime:         g.StartTime.UTC(),
+		EndTime:           g.EndTime.UTC(),
+		Duration:          int(g.Duration() / time.Minute),
+		Capacity:          g.Capacity,
+		MinEnrolment:      g.MinEnrolment,
+		EnrolmentDeadline: g.EnrolmentDeadline.UTC(),
+		SeatPrice:         g.SeatPrice,
+		SeatsTaken:        g.TakenSeats(),
+		Stage:             g.Stage,
+		StageDetail:       g.StageDetail,
+		Seats:             seats,
+	}
+}
+
+func dtoFromGroupSessions(sessions []services.GroupSession, viewer uuid.UUID) []GroupSessionResponseDTO {
+	dtoSessions := []GroupSessionResponseDTO{}
+
+	for i := range sessions {
+		dtoSessions = append(dtoSessions, *dtoFromGroupSession(&sessions[i], viewer))
+	}
+
+	return dtoSessions
+}
+
+func InjectGroupSessionsRoutes(subrouter *mux.Router) {
+	// User needs an account to do anything with group sessions, open sessions are listed under their subject
+	subrouter.Use(authRequired)
+
+	// POST /
+	subrouter.HandleFunc("", handleGroupSessionsPost).Methods("POST")
+
+	// GET /{uuid}
+	subrouter.HandleFunc("/{uuid}", handleGroupSessionsGet).Methods("GET")
+
+	// POST /{uuid}/enrol
+	subrouter.HandleFunc("/{uuid}/enrol", handleGroupSessionsEnrol).Methods("POST")
+
+	// POST /{uuid}/leave
+	subrouter.HandleFunc("/{uuid}/leave", handleGroupSessionsLeave).Methods("POST")
+
+	// POST /{uuid}/cancel
+	subrouter.HandleFunc("/{uuid}/cancel", handleGroupSessionsCancel).Methods("POST")
+
+	// GET /{uuid}/payment-intent-secret
+	subrouter.HandleFunc("/{uuid}/payment-intent-secret", handleGroupSessionsPaymentIntentSecretGet).Methods("GET")
+}
+
+// handleGroupSessionsPost schedules a group session for the subject of the tutor
+func handleGroupSessionsPost(w http.ResponseWriter, r *http.Request) {
+	sessionRequest := &GroupSessionRequestDTO{}
+	if !ParseBody(w, r, sessionRequest) {
+		return
+	}
+
+	authContext, err := ReadRequestAuthContext(r)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	subjectTaught, err := services.GetSubjectTaughtByID(sessionRequest.SubjectTaughtID, nil)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if authContext.Account.ID != subjectTaught.TutorProfile.AccountID {
+		restError(w, r, errors.New("only allowed to run group sessions of your own subjects"), http.StatusBadRequest)
+		return
+	}
+
+	session := &services.GroupSession{
+		Title:             sessionRequest.Title,
+		Description:       sessionRequest.Description,
+		StartTime:         sessionRequest.StartTime,
+		Capacity:          sessionRequest.Capacity,
+		MinEnrolment:      sessionRequest.MinEnrolment,
+		EnrolmentDeadline: sessionRequest.EnrolmentDeadline,
+		SeatPrice:         sessionRequest.SeatPrice,
+	}
+	err = services.CreateGroupSession(authContext.Account, subjectTaught, session, sessionRequest.Duration)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if session, err = services.ReadGroupSessionByID(session.ID); err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	WriteBody(w, r, dtoFromGroupSession(session, authContext.Account.ID))
+}
+
+func handleGroupSessionsGet(w http.ResponseWriter, r *http.Request) {
+	id, err := getUUID(r, "uuid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	authContext, err := ReadRequestAuthContext(r)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	session, err := services.ReadGroupSessionByID(id)
+	if err != nil {
+		restError(w, r, err, http.StatusNotFound)
+		return
+	}
+
+	WriteBody(w, r, dtoFromGroupSession(session, authContext.Account.ID))
+}
+
+// handleGroupSessionsEnrol gives the student a seat, which they pay for with the payment intent secret of the session
+func handleGroupSessionsEnrol(w http.ResponseWriter, r *http.Request) {
+	id, err := getUUID(r, "uuid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	authContext, err := ReadRequestAuthContext(r)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	session, err := services.ReadGroupSessionByID(id)
+	if err != nil {
+		restError(w, r, err, http.StatusNotFound)
+		return
+	}
+
+	seat, err := session.Enrol(authContext.Account)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	WriteBody(w, r, dtoFromGroupSeat(seat))
+}
+
+// handleGroupSessionsLeave gives up the student's seat, refunding it if it was paid for
+func handleGroupSessionsLeave(w http.ResponseWriter, r *http.Request) {
+	id, err := getUUID(r, "uuid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	authContext, err := ReadRequestAuthContext(r)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	session, err := services.ReadGroupSessionByID(id)
+	if err != nil {
+		restError(w, r, err, http.StatusNotFound)
+		return
+	}
+
+	if err = session.Leave(r.Context(), authContext.Account); err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+}
+
+// handleGroupSessionsCancel cancels the session for its tutor and refunds every paid seat
+func handleGroupSessionsCancel(w http.ResponseWriter, r *http.Request) {
+	cancelRequest := &LessonCancelRequestDTO{}
+	if !ParseBody(w, r, cancelRequest) {
+		return
+	}
+
+	id, err := getUUID(r, "uuid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	authContext, err := ReadRequestAuthContext(r)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	session, err := services.ReadGroupSessionByID(id)
+	if err != nil {
+		restError(w, r, err, http.StatusNotFound)
+		return
+	}
+
+	if err = session.Cancel(r.Context(), authContext.Account, cancelRequest.Reason); err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+}
+
+// handleGroupSessionsPaymentIntentSecretGet returns the payment intent secret of the caller's seat
+func handleGroupSessionsPaymentIntentSecretGet(w http.ResponseWriter, r *http.Request) {
+	id, err := getUUID(r, "uuid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	authContext, err := ReadRequestAuthContext(r)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	session, err := services.ReadGroupSessionByID(id)
+	if err != nil {
+		restError(w, r, err, http.StatusNotFound)
+		return
+	}
+
+	seat := session.SeatOf(authContext.Account.ID)
+	if seat == nil {
+		restError(w, r, services.GroupSessionErrorNotEnrolled, http.StatusBadRequest)
+		return
+	}
+
+	pid, err := seat.GetPaymentIntentClientSecret()
+	if err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	WriteBody(w, r, &LessonBillingPaymentIntentSecretDTO{
+		ID: pid,
+	})
+}
+
+// handleSubjectGroupSessionsGet lists the group sessions of a subject that students can still enrol in
+func handleSubjectGroupSessionsGet(w http.ResponseWriter, r *http.Request) {
+	id, err := getUUID(r, "sid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	sessions, err := services.ReadOpenGroupSessionsBySubjectID(id)
+	if err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(dtoFromGroupSessions(sessions, uuid.Nil)); err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,222 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/cs3305-team-4/api/pkg/services"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// LessonSeriesRequestDTO represents a repeating lesson requested by an account
+type LessonSeriesRequestDTO struct {
+	LessonRequestDTO
+
+	// Recurrence is a weekly RRULE, like FREQ=WEEKLY;INTERVAL=2;COUNT=10 or FREQ=WEEKLY;UNTIL=20211231
+	Recurrence string `json:"recurrence" validate:"required"`
+}
+
+// LessonSeriesResponseDTO represents an existing lesson series and its lessons
+type LessonSeriesResponseDTO struct {
+	ID              uuid.UUID `json:"id"`
+	Recurrence      string    `json:"recurrence"`
+	StartTime       time.Time `json:"start_time"`
+	Duration        int       `json:"duration"`
+	StudentID       uuid.UUID `json:"student_id"`
+	TutorID         uuid.UUID `json:"tutor_id"`
+	SubjectTaughtID uuid.UUID `json:"subject_taught_id"`
+	RequesterID     uuid.UUID `json:"requester_id"`
+	LessonDetail    string    `json:"lesson_detail"`
+
+	Lessons []LessonResponseDTO `json:"lessons"`
+}
+
+func dtoFromLessonSeries(s *services.LessonSeries, loc *time.Location) *LessonSeriesResponseDTO {
+	return &LessonSeriesResponseDTO{
+		ID:              s.ID,
+		Recurrence:      s.Recurrence,
+		StartTime:       s.StartTime.UTC(),
+		Duration:        s.Duration,
+		StudentID:       s.StudentID,
+		TutorID:         s.TutorID,
+		SubjectTaughtID: s.SubjectTaughtID,
+		RequesterID:     s.RequesterID,
+		LessonDetail:    s.LessonDetail,
+		Lessons:         dtoFromLessons(s.Lessons, loc),
+	}
+}
+
+func InjectLessonSeriesRoutes(subrouter *mux.Router) {
+	// User needs an account to do anything with lessons
+	subrouter.Use(authRequired)
+
+	// POST /
+	subrouter.HandleFunc("", handleLessonSeriesPost).Methods("POST")
+
+	seriesResource := subrouter.PathPrefix("/{uuid}").Subrouter()
+
+	// Only allow users access to series that concern them
+	seriesResource.Use(authMiddleware(
+		func(w http.ResponseWriter, r *http.Request, ac *AuthContext) error {
+			id, err := getUUID(r, "uuid")
+			if err != nil {
+				return err
+			}
+
+			series, err := services.ReadLessonSeriesByID(id)
+			if err != nil {
+				return err
+			}
+
+			if ac.Account.ID == series.StudentID || ac.Account.ID == series.TutorID {
+				return nil
+			}
+
+			return errors.New("can only operate on a lesson series that you are a participant in")
+		}, true,
+	))
+
+	// GET /{uuid}
+	seriesResource.Path("").HandlerFunc(handleLessonSeriesGet).Methods("GET")
+
+	// POST /{uuid}/accept
+	seriesResource.HandleFunc("/accept",
+		handleLessonSeriesAccept,
+	).Methods("POST")
+
+	// POST /{uuid}/deny
+	seriesResource.HandleFunc("/deny",
+		handleLessonSeriesDeny,
+	).Methods("POST")
+}
+
+// handleLessonSeriesPost requests every lesson of a series at once
+func handleLessonSeriesPost(w http.ResponseWriter, r *http.Request) {
+	seriesRequest := &LessonSeriesRequestDTO{}
+	if !ParseBody(w, r, seriesRequest) {
+		return
+	}
+
+	authContext, err := ReadRequestAuthContext(r)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	subjectTaught, err := services.GetSubjectTaughtByID(seriesRequest.SubjectTaughtID, nil)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if !(authContext.Account.ID == seriesRequest.StudentID || authContext.Account.ID == subjectTaught.TutorProfile.AccountID) {
+		restError(w, r, errors.New("only allowed operate on a lesson that has your account as a participant"), http.StatusBadRequest)
+		return
+	}
+
+	student, err := services.ReadAccountByID(seriesRequest.StudentID, nil)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	series, err := services.RequestLessonSeries(authContext.Account, student, subjectTaught, seriesRequest.StartTime, seriesRequest.Duration, seriesRequest.Recurrence, seriesRequest.LessonDetail)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if series, err = services.ReadLessonSeriesByID(series.ID); err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	WriteBody(w, r, dtoFromLessonSeries(series, services.ReadAccountLocation(authContext.Account.ID)))
+}
+
+func handleLessonSeriesGet(w http.ResponseWriter, r *http.Request) {
+	id, err := getUUID(r, "uuid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	authContext, err := ReadRequestAuthContext(r)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	series, err := services.ReadLessonSeriesByID(id)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(dtoFromLessonSeries(series, services.ReadAccountLocation(authContext.Account.ID))); err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+}
+
+// handleLessonSeriesAccept accepts every lesson of the series requested or rescheduled by the other participant at once
+func handleLessonSeriesAccept(w http.ResponseWriter, r *http.Request) {
+	authContext, err := ReadRequestAuthContext(r)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	id, err := getUUID(r, "uuid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	series, err := services.ReadLessonSeriesByID(id)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	err = series.Accept(r.Context(), authContext.Account)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+}
+
+// handleLessonSeriesDeny denies every lesson of the series requested or rescheduled by the other participant at once
+func handleLessonSeriesDeny(w http.ResponseWriter, r *http.Request) {
+	denyRequest := &LessonDenyRequestDTO{}
+	if !ParseBody(w, r, denyRequest) {
+		return
+	}
+
+	authContext, err := ReadRequestAuthContext(r)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	id, err := getUUID(r, "uuid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	series, err := services.ReadLessonSeriesByID(id)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	err = series.Deny(r.Context(), authContext.Account, denyRequest.Reason)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"io"
 	"mime"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -24,6 +25,16 @@ type LessonResponseDTO struct {
 	// Time of the lesson
 	StartTime time.Time `json:"start_time"`
 
+	EndTime time.Time `json:"end_time"`
+
+	// The times of the lesson in the time zone of the account asking for it
+	TimeZone       string    `json:"time_zone"`
+	LocalStartTime time.Time `json:"local_start_time"`
+	LocalEndTime   time.Time `json:"local_end_time"`
+
+	// Duration of the lesson in minutes
+	Duration int `json:"duration"`
+
 	// Requester of the lesson
 	RequesterID uuid.UUID `json:"requester_id"`
 
@@ -51,6 +62,30 @@ type LessonResponseDTO struct {
 	RequestStageChangerID uuid.UUID `json:"request_stage_changer_id"`
 
 	Resources []ResourceMetadataDTO `json:"resources"`
+
+	// History is only included when asked for with ?include=history
+	History []LessonStageTransitionDTO `json:"history,omitempty"`
+
+	// SeriesID is the lesson series the lesson is part of, if it repeats
+	SeriesID *uuid.UUID `json:"series_id,omitempty"`
+}
+
+// LessonStageTransitionDTO represents a lesson moving from one stage to another
+type LessonStageTransitionDTO struct {
+	FromStage services.LessonRequestStage `json:"from_stage"`
+	ToStage   services.LessonRequestStage `json:"to_stage"`
+
+	// ActorID is empty if the system changed the stage
+	ActorID *uuid.UUID `json:"actor_id"`
+	Reason  string     `json:"reason"`
+
+	// Only set if the lesson was moved to another time
+	OldStartTime *time.Time `json:"old_start_time,omitempty"`
+	OldEndTime   *time.Time `json:"old_end_time,omitempty"`
+	NewStartTime *time.Time `json:"new_start_time,omitempty"`
+	NewEndTime   *time.Time `json:"new_end_time,omitempty"`
+
+	ChangedAt time.Time `json:"changed_at"`
 }
 
 // ResourceMetadataDTO represents a data transfer object for a resources metadata
@@ -74,6 +109,9 @@ type LessonRequestDTO struct {
 
 	// LessonDetail contains info about what the lesson should be about
 	LessonDetail string `json:"lesson_detail"`
+
+	// Duration of the lesson in minutes, it must be one the tutor offers for the subject. Defaults to 60
+	Duration int `json:"duration"`
 }
 
 // Represents a request to deny a lesson
@@ -86,11 +124,120 @@ type LessonCancelRequestDTO struct {
 	Reason string `json:"reason"`
 }
 
+// Represents a report that the other participant didn't show up to a lesson
+type LessonNoShowRequestDTO struct {
+	Reason string `json:"reason"`
+}
+
+// LessonDisputeRequestDTO represents a participant disputing how a lesson ended
+type LessonDisputeRequestDTO struct {
+	Evidence string `json:"evidence" validate:"required"`
+}
+
+// DisputeResponseDTO represents a dispute about a lesson
+type DisputeResponseDTO struct {
+	ID           uuid.UUID              `json:"id"`
+	CreatedAt    time.Time              `json:"created_at"`
+	LessonID     uuid.UUID              `json:"lesson_id"`
+	RaisedByID   uuid.UUID              `json:"raised_by_id"`
+	Evidence     string                 `json:"evidence"`
+	Status       services.DisputeStatus `json:"status"`
+	ResolvedByID *uuid.UUID             `json:"resolved_by_id"`
+	ResolvedAt   *time.Time             `json:"resolved_at"`
+	Resolution   string                 `json:"resolution"`
+}
+
 type LessonRescheduleRequestDTO struct {
 	NewTime time.Time `json:"new_time"`
 	Reason  string    `json:"reason"`
 }
 
+// LessonActionDTO represents something the caller can currently do to a lesson
+type LessonActionDTO struct {
+	// Action is the path under the lesson to POST to
+	Action string                      `json:"action"`
+	Stage  services.LessonRequestStage `json:"stage"`
+}
+
+// lessonStageActions maps a stage to the action that moves a lesson to it
+var lessonStageActions = map[services.LessonRequestStage]string{
+	services.PaymentRequired: "payment-required",
+	services.Denied:          "deny",
+	services.Scheduled:       "schedule",
+	services.Rescheduled:     "reschedule",
+	services.Cancelled:       "cancel",
+	services.Completed:       "completed",
+	services.NoShowStudent:   "no-show",
+	services.NoShowTutor:     "no-show",
+}
+
+// LessonMessageResponseDTO represents a chat message sent in the classroom of a lesson
+type LessonMessageResponseDTO struct {
+	ID       uuid.UUID `json:"id"`
+	SenderID uuid.UUID `json:"sender_id"`
+	Body     string    `json:"body"`
+	SentAt   time.Time `json:"sent_at"`
+}
+
+func dtoFromLessonMessages(messages []services.LessonMessage) []LessonMessageResponseDTO {
+	dtoMessages := []LessonMessageResponseDTO{}
+
+	for _, m := range messages {
+		dtoMessages = append(dtoMessages, LessonMessageResponseDTO{
+			ID:       m.ID,
+			SenderID: m.SenderID,
+			Body:     m.Body,
+			SentAt:   m.CreatedAt,
+		})
+	}
+
+	return dtoMessages
+}
+
+func dtoFromDispute(d *services.Dispute) *DisputeResponseDTO {
+	return &DisputeResponseDTO{
+		ID:           d.ID,
+		CreatedAt:    d.CreatedAt,
+		LessonID:     d.LessonID,
+		RaisedByID:   d.RaisedByID,
+		Evidence:     d.Evidence,
+		Status:       d.Status,
+		ResolvedByID: d.ResolvedByID,
+		ResolvedAt:   d.ResolvedAt,
+		Resolution:   d.Resolution,
+	}
+}
+
+func dtoFromDisputes(disputes []services.Dispute) []DisputeResponseDTO {
+	dtoDisputes := []DisputeResponseDTO{}
+
+	for _, d := range disputes {
+		dtoDisputes = append(dtoDisputes, *dtoFromDispute(&d))
+	}
+
+	return dtoDisputes
+}
+
+func dtoFromLessonHistory(history []services.LessonStageTransition) []LessonStageTransitionDTO {
+	dtoHistory := []LessonStageTransitionDTO{}
+
+	for _, t := range history {
+		dtoHistory = append(dtoHistory, LessonStageTransitionDTO{
+			FromStage:    t.FromStage,
+			ToStage:      t.ToStage,
+			ActorID:      t.ActorID,
+			Reason:       t.Reason,
+			OldStartTime: t.OldStartTime,
+			OldEndTime:   t.OldEndTime,
+			NewStartTime: t.NewStartTime,
+			NewEndTime:   t.NewEndTime,
+			ChangedAt:    t.CreatedAt,
+		})
+	}
+
+	return dtoHistory
+}
+
 func dtoFromResourceMetadata(m *services.ResourceMetadata) *ResourceMetadataDTO {
 	return &ResourceMetadataDTO{
 		Name: m.Name,
@@ -98,16 +245,28 @@ func dtoFromResourceMetadata(m *services.ResourceMetadata) *ResourceMetadataDTO
 	}
 }
 
-func dtoFromLesson(l *services.Lesson) *LessonResponseDTO {
+// dtoFromLesson renders the lesson with its local times in loc
+func dtoFromLesson(l *services.Lesson, loc *time.Location) *LessonResponseDTO {
 	mds := []ResourceMetadataDTO{}
 
 	for _, md := range l.Resources {
 		mds = append(mds, *dtoFromResourceMetadata(&md))
 	}
 
+	var history []LessonStageTransitionDTO
+	if l.History != nil {
+		history = dtoFromLessonHistory(l.History)
+	}
+
 	return &LessonResponseDTO{
 		ID:                    l.ID,
-		StartTime:             l.StartTime,
+		StartTime:             l.StartTime.UTC(),
+		EndTime:               l.EndTime.UTC(),
+		TimeZone:              loc.String(),
+		LocalStartTime:        l.StartTime.In(loc),
+		LocalEndTime:          l.EndTime.In(loc),
+		Duration:              int(l.Duration() / time.Minute),
+		SeriesID:              l.SeriesID,
 		TutorID:               l.TutorID,
 		StudentID:             l.StudentID,
 		RequesterID:           l.RequesterID,
@@ -118,14 +277,15 @@ func dtoFromLesson(l *services.Lesson) *LessonResponseDTO {
 		RequestStageDetail:    l.RequestStageDetail,
 		RequestStageChangerID: l.RequestStageChangerID,
 		Resources:             mds,
+		History:               history,
 	}
 }
 
-func dtoFromLessons(lessons []services.Lesson) []LessonResponseDTO {
+func dtoFromLessons(lessons []services.Lesson, loc *time.Location) []LessonResponseDTO {
 	dtoLessons := []LessonResponseDTO{}
 
 	for _, l := range lessons {
-		dtoLessons = append(dtoLessons, *dtoFromLesson(&l))
+		dtoLessons = append(dtoLessons, *dtoFromLesson(&l, loc))
 	}
 
 	return dtoLessons
@@ -192,11 +352,51 @@ func InjectLessonsRoutes(subrouter *mux.Router) {
 		handleLessonsRescheduleRequest,
 	).Methods("POST")
 
+	// POST /{uuid}/cancel-following
+	lessonResource.HandleFunc("/cancel-following",
+		handleLessonsCancelFollowingRequest,
+	).Methods("POST")
+
+	// POST /{uuid}/reschedule-following
+	lessonResource.HandleFunc("/reschedule-following",
+		handleLessonsRescheduleFollowingRequest,
+	).Methods("POST")
+
 	// POST /{uuid}/completed
 	lessonResource.HandleFunc("/completed",
 		handleLessonsCompletedRequest,
 	).Methods("POST")
 
+	// POST /{uuid}/no-show
+	lessonResource.HandleFunc("/no-show",
+		handleLessonsNoShowRequest,
+	).Methods("POST")
+
+	// GET /{uuid}/disputes
+	lessonResource.HandleFunc("/disputes",
+		handleLessonsDisputesGet,
+	).Methods("GET")
+
+	// POST /{uuid}/disputes
+	lessonResource.HandleFunc("/disputes",
+		handleLessonsDisputesPost,
+	).Methods("POST")
+
+	// GET /{uuid}/actions
+	lessonResource.HandleFunc("/actions",
+		handleLessonsActionsGet,
+	).Methods("GET")
+
+	// GET /{uuid}/history
+	lessonResource.HandleFunc("/history",
+		handleLessonsHistoryGet,
+	).Methods("GET")
+
+	// GET /{uuid}/messages
+	lessonResource.HandleFunc("/messages",
+		handleLessonsMessagesGet,
+	).Methods("GET")
+
 	subrouter.HandleFunc("/resources",
 		handleLessonsResourcesPost).Methods("POST")
 
@@ -283,7 +483,7 @@ func handleLessonsMarkScheduled(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = lesson.MarkScheduled(authContext.Account)
+	err = lesson.MarkScheduled(r.Context(), authContext.Account)
 	if err != nil {
 		restError(w, r, err, http.StatusBadRequest)
 		return
@@ -303,13 +503,108 @@ func handleLessonsGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dtoLesson := dtoFromLesson(lesson)
+	if r.URL.Query().Get("include") == "history" {
+		lesson.History, err = services.ReadLessonHistory(lesson.ID)
+		if err != nil {
+			restError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	authContext, err := ReadRequestAuthContext(r)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	dtoLesson := dtoFromLesson(lesson, services.ReadAccountLocation(authContext.Account.ID))
 	if err = json.NewEncoder(w).Encode(dtoLesson); err != nil {
 		restError(w, r, err, http.StatusBadRequest)
 		return
 	}
 }
 
+// handleLessonsActionsGet returns the actions the caller can currently take on a lesson
+func handleLessonsActionsGet(w http.ResponseWriter, r *http.Request) {
+	authContext, err := ReadRequestAuthContext(r)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	id, err := getUUID(r, "uuid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	lesson, err := services.ReadLessonByID(id)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	actions := []LessonActionDTO{}
+	for _, stage := range lesson.AllowedTransitions(authContext.Account) {
+		actions = append(actions, LessonActionDTO{
+			Action: lessonStageActions[stage],
+			Stage:  stage,
+		})
+	}
+
+	if err = json.NewEncoder(w).Encode(actions); err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleLessonsHistoryGet returns every stage change of a lesson, oldest first
+func handleLessonsHistoryGet(w http.ResponseWriter, r *http.Request) {
+	id, err := getUUID(r, "uuid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	history, err := services.ReadLessonHistory(id)
+	if err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(dtoFromLessonHistory(history)); err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleLessonsMessagesGet returns the chat transcript of a lesson, newest messages first
+func handleLessonsMessagesGet(w http.ResponseWriter, r *http.Request) {
+	id, err := getUUID(r, "uuid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	q := r.URL.Query()
+	pageSize, err := strconv.Atoi(q.Get("page_size"))
+	if err != nil || pageSize <= 0 {
+		pageSize = 50
+	}
+	page, err := strconv.Atoi(q.Get("page"))
+	if err != nil || page <= 0 {
+		page = 1
+	}
+
+	messages, totalPages, err := services.ReadLessonMessagesPaginated(id, pageSize, page)
+	if err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	WriteBody(w, r, ToPaginatedDTO(totalPages, dtoFromLessonMessages(messages)))
+}
+
 func handleLessonsPost(w http.ResponseWriter, r *http.Request) {
 	lessonRequest := &LessonRequestDTO{}
 	if !ParseBody(w, r, lessonRequest) {
@@ -347,7 +642,7 @@ func handleLessonsPost(w http.ResponseWriter, r *http.Request) {
 
 	log.Info(subjectTaught.TutorID)
 
-	err = services.RequestLesson(authContext.Account, student, subjectTaught, lessonRequest.StartTime, lessonRequest.LessonDetail)
+	err = services.RequestLesson(authContext.Account, student, subjectTaught, lessonRequest.StartTime, lessonRequest.Duration, lessonRequest.LessonDetail)
 	if err != nil {
 		restError(w, r, err, http.StatusBadRequest)
 		return
@@ -373,7 +668,7 @@ func handleLessonsMarkPaymentRequired(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = lesson.MarkPaymentRequired(authContext.Account)
+	err = lesson.MarkPaymentRequired(r.Context(), authContext.Account)
 	if err != nil {
 		restError(w, r, err, http.StatusBadRequest)
 		return
@@ -404,7 +699,7 @@ func handleLessonsDenyRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = lesson.MarkDenied(authContext.Account, denyRequest.Reason)
+	err = lesson.MarkDenied(r.Context(), authContext.Account, denyRequest.Reason)
 	if err != nil {
 		restError(w, r, err, http.StatusBadRequest)
 		return
@@ -435,7 +730,7 @@ func handleLessonsCancelRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = lesson.MarkCancelled(authContext.Account, cancelRequest.Reason)
+	err = lesson.MarkCancelled(r.Context(), authContext.Account, cancelRequest.Reason)
 	if err != nil {
 		restError(w, r, err, http.StatusBadRequest)
 		return
@@ -463,13 +758,92 @@ func handleLessonsCompletedRequest(w http.ResponseWriter, r *http.Request) {
 		restError(w, r, err, http.StatusBadRequest)
 		return
 	}
-	err = lesson.MarkCompleted(account)
+	err = lesson.MarkCompleted(r.Context(), account)
 	if err != nil {
 		restError(w, r, err, http.StatusBadRequest)
 		return
 	}
 }
 
+func handleLessonsNoShowRequest(w http.ResponseWriter, r *http.Request) {
+	noShowRequest := &LessonNoShowRequestDTO{}
+	if !ParseBody(w, r, noShowRequest) {
+		return
+	}
+
+	authContext, err := ReadRequestAuthContext(r)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	id, err := getUUID(r, "uuid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	lesson, err := services.ReadLessonByID(id)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	err = lesson.MarkNoShow(r.Context(), authContext.Account, noShowRequest.Reason)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+}
+
+// handleLessonsDisputesGet returns every dispute raised about a lesson
+func handleLessonsDisputesGet(w http.ResponseWriter, r *http.Request) {
+	id, err := getUUID(r, "uuid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	disputes, err := services.ReadDisputesByLessonID(id)
+	if err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(dtoFromDisputes(disputes)); err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleLessonsDisputesPost disputes how a lesson ended, the tutor isn't paid for it until an admin resolves it
+func handleLessonsDisputesPost(w http.ResponseWriter, r *http.Request) {
+	disputeRequest := &LessonDisputeRequestDTO{}
+	if !ParseBody(w, r, disputeRequest) {
+		return
+	}
+
+	authContext, err := ReadRequestAuthContext(r)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	id, err := getUUID(r, "uuid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	dispute, err := services.RaiseDispute(id, authContext.Account, disputeRequest.Evidence)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	WriteBody(w, r, dtoFromDispute(dispute))
+}
+
 func handleLessonsRescheduleRequest(w http.ResponseWriter, r *http.Request) {
 	rescheduleRequest := &LessonRescheduleRequestDTO{}
 	if !ParseBody(w, r, rescheduleRequest) {
@@ -494,7 +868,71 @@ func handleLessonsRescheduleRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = lesson.MarkRescheduled(authContext.Account, rescheduleRequest.NewTime, rescheduleRequest.Reason)
+	err = lesson.MarkRescheduled(r.Context(), authContext.Account, rescheduleRequest.NewTime, rescheduleRequest.Reason)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+}
+
+// handleLessonsCancelFollowingRequest cancels the lesson and every later lesson of its series
+func handleLessonsCancelFollowingRequest(w http.ResponseWriter, r *http.Request) {
+	cancelRequest := &LessonCancelRequestDTO{}
+	if !ParseBody(w, r, cancelRequest) {
+		return
+	}
+
+	authContext, err := ReadRequestAuthContext(r)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	id, err := getUUID(r, "uuid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	lesson, err := services.ReadLessonByID(id)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	err = lesson.MarkCancelledFollowing(r.Context(), authContext.Account, cancelRequest.Reason)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+}
+
+// handleLessonsRescheduleFollowingRequest moves the lesson and every later lesson of its series to the new time
+func handleLessonsRescheduleFollowingRequest(w http.ResponseWriter, r *http.Request) {
+	rescheduleRequest := &LessonRescheduleRequestDTO{}
+	if !ParseBody(w, r, rescheduleRequest) {
+		return
+	}
+
+	authContext, err := ReadRequestAuthContext(r)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	id, err := getUUID(r, "uuid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	lesson, err := services.ReadLessonByID(id)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	err = lesson.MarkRescheduledFollowing(r.Context(), authContext.Account, rescheduleRequest.NewTime, rescheduleRequest.Reason)
 	if err != nil {
 		restError(w, r, err, http.StatusBadRequest)
 		return
@@ -3,7 +3,10 @@ package routes
 import (
 	"errors"
 	"net/http"
+	"regexp"
 
+	"github.com/cs3305-team-4/api/pkg/services"
+	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -22,12 +25,57 @@ func authAccount() func(next http.Handler) http.Handler {
 	}, true)
 }
 
+func authAdmin() func(next http.Handler) http.Handler {
+	return authMiddleware(func(w http.ResponseWriter, r *http.Request, ac *AuthContext) error {
+		if !ac.Account.IsAdmin() {
+			return errors.New("only admins can access this resource")
+		}
+
+		return nil
+	}, true)
+}
+
+// authTwoFactor only allows requests from sessions that were started with a two-factor code
+func authTwoFactor() func(next http.Handler) http.Handler {
+	return authMiddleware(func(w http.ResponseWriter, r *http.Request, ac *AuthContext) error {
+		if ac.Session == nil || !ac.Session.TwoFactorVerified {
+			return services.TwoFactorErrorRequired
+		}
+
+		enabled, err := ac.Account.TwoFactorEnabled()
+		if err != nil {
+			return err
+		}
+		if !enabled {
+			return services.TwoFactorErrorRequired
+		}
+
+		return nil
+	}, true)
+}
+
 func authSetCtx() func(next http.Handler) http.Handler {
 	return authMiddleware(func(w http.ResponseWriter, r *http.Request, ac *AuthContext) error {
 		return nil
 	}, false)
 }
 
+var requestIDValid = regexp.MustCompile("^[A-Za-z0-9._-]{1,64}$")
+
+// requestIDMiddleware tags every request with an ID, which is returned in the X-Request-ID header and recorded on audit events.
+// An ID sent by the client or a proxy is kept if it looks sane.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-ID")
+		if !requestIDValid.MatchString(requestID) {
+			requestID = uuid.New().String()
+		}
+
+		w.Header().Set("X-Request-ID", requestID)
+		next.ServeHTTP(w, r.WithContext(services.WithRequestID(r.Context(), requestID)))
+	})
+}
+
 func loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log.WithFields(log.Fields{
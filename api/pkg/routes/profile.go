@@ -25,6 +25,7 @@ type ProfileRequestDTO struct {
 	Country     string `json:"country" validate:"required"`
 	Subtitle    string `json:"subtitle" validate:"omitempty,lte=300"`
 	Description string `json:"description" validate:"omitempty,lte=1000"`
+	TimeZone    string `json:"time_zone" validate:"omitempty,timezone"`
 }
 
 // ProfileResponseDTO return DTO.
@@ -40,6 +41,7 @@ type ProfileResponseDTO struct {
 	Subtitle    string `json:"subtitle" validate:"omitempty,lte=300"`
 	Description string `json:"description" validate:"omitempty,lte=1000"`
 	Color       string `json:"color" validate:"required"`
+	TimeZone    string `json:"time_zone"`
 }
 
 // TutorResponseDTO return DTO.
@@ -48,6 +50,30 @@ type TutorResponseDTO struct {
 	Qualifications []QualificationsResponseDTO `json:"qualifications"`
 	WorkExperience []WorkExperienceResponseDTO `json:"work_experience"`
 	Availability   []bool                      `json:"availability" validate:""`
+
+	AvailabilityExceptions []AvailabilityExceptionResponseDTO `json:"availability_exceptions"`
+	Vacation               bool                               `json:"vacation"`
+}
+
+// AvailabilityExceptionRequestDTO create DTO, dates are YYYY-MM-DD and end_hour defaults to the end of the day.
+type AvailabilityExceptionRequestDTO struct {
+	StartDate string `json:"start_date" validate:"required,datetime=2006-01-02"`
+	EndDate   string `json:"end_date" validate:"required,datetime=2006-01-02"`
+	StartHour int    `json:"start_hour" validate:"gte=0,lte=23"`
+	EndHour   int    `json:"end_hour" validate:"omitempty,gte=1,lte=24"`
+	Open      bool   `json:"open"`
+	Note      string `json:"note" validate:"omitempty,lte=300"`
+}
+
+// AvailabilityExceptionResponseDTO return DTO.
+type AvailabilityExceptionResponseDTO struct {
+	ID        string `json:"id" validate:"required,uuid"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	StartHour int    `json:"start_hour"`
+	EndHour   int    `json:"end_hour"`
+	Open      bool   `json:"open"`
+	Note      string `json:"note"`
 }
 
 // QualificationsRequestDTO create DTO.
@@ -99,6 +125,7 @@ func dtoFromProfile(p *services.Profile, accountType services.AccountType) inter
 			Subtitle:    p.Subtitle,
 			Description: p.Description,
 			Color:       p.Color,
+			TimeZone:    p.Location().String(),
 		}
 	case services.Tutor:
 		qualifications := make([]QualificationsResponseDTO, 0)
@@ -121,6 +148,18 @@ func dtoFromProfile(p *services.Profile, accountType services.AccountType) inter
 				Verified:    val.Verified,
 			})
 		}
+		availabilityExceptions := make([]AvailabilityExceptionResponseDTO, 0)
+		for _, val := range p.AvailabilityExceptions {
+			availabilityExceptions = append(availabilityExceptions, AvailabilityExceptionResponseDTO{
+				ID:        val.ID.String(),
+				StartDate: val.StartDate.Format("2006-01-02"),
+				EndDate:   val.EndDate.Format("2006-01-02"),
+				StartHour: val.StartHour,
+				EndHour:   val.EndHour,
+				Open:      val.Open,
+				Note:      val.Note,
+			})
+		}
 		return &TutorResponseDTO{
 			ProfileResponseDTO: ProfileResponseDTO{
 				AccountID:   p.AccountID.String(),
@@ -134,10 +173,14 @@ func dtoFromProfile(p *services.Profile, accountType services.AccountType) inter
 				Subtitle:    p.Subtitle,
 				Description: p.Description,
 				Color:       p.Color,
+				TimeZone:    p.Location().String(),
 			},
 			Availability:   p.Availability.Get(),
 			Qualifications: qualifications,
 			WorkExperience: workExperience,
+
+			AvailabilityExceptions: availabilityExceptions,
+			Vacation:               p.Vacation,
 		}
 	}
 	return nil
@@ -204,6 +247,7 @@ func handleProfilePost(w http.ResponseWriter, r *http.Request) {
 		Country:     profile.Country,
 		Subtitle:    profile.Subtitle,
 		Description: profile.Description,
+		TimeZone:    profile.TimeZone,
 	}
 	if err := services.CreateProfile(serviceProfile); err != nil {
 		restError(w, r, err, http.StatusInternalServerError)
@@ -364,6 +408,35 @@ func handleProfileUpdateCity(w http.ResponseWriter, r *http.Request) {
 	WriteBody(w, r, outProfile)
 }
 
+// handleProfileUpdateTimeZone sets the IANA time zone of the profile, a tutor's availability is in this time zone
+func handleProfileUpdateTimeZone(w http.ResponseWriter, r *http.Request) {
+	t, err := getAccountType(r)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	id, err := getUUID(r, "uuid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	value := ParseUpdateString(w, r)
+	var profile *services.Profile
+	if profile, err = services.UpdateProfileTimeZone(id, value); err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	if ok, err := profile.IsAccountType(t); err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	} else if !ok {
+		restError(w, r, errors.New("account type does not match endpoint."), http.StatusBadRequest)
+		return
+	}
+	outProfile := dtoFromProfile(profile, t)
+	WriteBody(w, r, outProfile)
+}
+
 func handleProfileUpdateCountry(w http.ResponseWriter, r *http.Request) {
 	t, err := getAccountType(r)
 	if err != nil {
@@ -23,6 +23,7 @@ func GetHandler() http.Handler {
 	r := mux.NewRouter()
 
 	r.Use(
+		requestIDMiddleware,
 		loggingMiddleware,
 		jsonMiddleware,
 		authSetCtx(),
@@ -31,11 +32,16 @@ func GetHandler() http.Handler {
 	InjectAccountsRoutes(r.PathPrefix("/accounts").Subrouter())
 	InjectAuthRoutes(r.PathPrefix("/auth").Subrouter())
 	InjectLessonsRoutes(r.PathPrefix("/lessons").Subrouter())
+	InjectLessonSeriesRoutes(r.PathPrefix("/lesson-series").Subrouter())
+	InjectGroupSessionsRoutes(r.PathPrefix("/group-sessions").Subrouter())
 	InjectStudentsRoutes(r.PathPrefix("/students").Subrouter())
 	InjectSubjectsRoutes(r.PathPrefix("/subjects").Subrouter())
 	InjectTutorsRoutes(r.PathPrefix("/tutors").Subrouter())
 	InjectSignallingRoutes(r.PathPrefix("/signalling").Subrouter())
 	InjectReviewsRoutes(r.PathPrefix("/reviews").Subrouter())
+	InjectBillingRoutes(r.PathPrefix("/billing").Subrouter())
+	InjectConversationsRoutes(r.PathPrefix("/conversations").Subrouter())
+	InjectAdminRoutes(r.PathPrefix("/admin").Subrouter())
 
 	return cors.New(cors.Options{
 		AllowedOrigins: []string{"*"},
@@ -102,10 +108,13 @@ func ParseBody(w http.ResponseWriter, r *http.Request, i interface{}) bool {
 }
 
 // WriteBody writes to the http writer.
+// Structs are validated against their DTO's validation tags first, other values such as lists are written as is.
 func WriteBody(w http.ResponseWriter, r *http.Request, i interface{}) bool {
-	if err := validateStruct(i); err != nil {
-		restError(w, r, err, http.StatusInternalServerError)
-		return false
+	if reflect.Indirect(reflect.ValueOf(i)).Kind() == reflect.Struct {
+		if err := validateStruct(i); err != nil {
+			restError(w, r, err, http.StatusInternalServerError)
+			return false
+		}
 	}
 	if err := json.NewEncoder(w).Encode(i); err != nil {
 		restError(w, r, err, http.StatusInternalServerError)
@@ -3,40 +3,73 @@ package routes
 import (
 	"errors"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/cs3305-team-4/api/pkg/services"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 )
 
+// Browsers can't set headers on a websocket handshake, so the JWT can instead be sent as the
+// second subprotocol after this one, e.g. new WebSocket(url, ["access_token", jwt])
+const signallingTokenSubprotocol = "access_token"
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
+	Subprotocols:    []string{signallingTokenSubprotocol},
 	//TODO(james): Origin checking
 	CheckOrigin: func(r *http.Request) bool { return true },
 }
 
 func InjectSignallingRoutes(subrouter *mux.Router) {
-	//TODO(james): Authentication
-	// Connect to WebSocket
+	// Connect to WebSocket, the classroom ID is the ID of the lesson or group session
 	subrouter.HandleFunc("/ws/{classroomId}", joinClassroom)
 	// Turn Server Credentials
 	subrouter.HandleFunc("/credentials", credentials)
 }
 
 func joinClassroom(w http.ResponseWriter, r *http.Request) {
-	id, err := getClassroomId(r)
+	id, err := getUUID(r, "classroomId")
 	if err != nil {
 		restError(w, r, err, http.StatusBadRequest)
 		return
 	}
 
+	jwtStr, err := getSignallingToken(r)
+	if err != nil {
+		restError(w, r, err, http.StatusForbidden)
+		return
+	}
+
+	authContext, err := authContextFromJWT(jwtStr)
+	if err != nil {
+		restError(w, r, err, http.StatusForbidden)
+		return
+	}
+
+	// The classroom is for a lesson, or for a group session if there's no lesson with the ID
+	if lesson, lessonErr := services.ReadLessonByID(id); lessonErr == nil {
+		err = lesson.CanJoinClassroom(authContext.Account, time.Now())
+	} else if session, sessionErr := services.ReadGroupSessionByID(id); sessionErr == nil {
+		err = session.CanJoinClassroom(authContext.Account, time.Now())
+	} else {
+		restError(w, r, lessonErr, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		restError(w, r, err, http.StatusForbidden)
+		return
+	}
+
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		restError(w, r, err, http.StatusBadRequest)
+		// Upgrade has already replied to the client
+		return
 	}
 
-	services.SignallingAddToClassroom(ws, id)
+	services.SignallingAddToClassroom(ws, id, authContext.Account)
 }
 
 func credentials(w http.ResponseWriter, r *http.Request) {
@@ -50,10 +83,18 @@ func credentials(w http.ResponseWriter, r *http.Request) {
 	WriteBody(w, r, services.GenerateTURNCredentials(id))
 }
 
-func getClassroomId(r *http.Request) (string, error) {
-	vars := mux.Vars(r)
-	if val, ok := vars["classroomId"]; ok {
-		return val, nil
+// getSignallingToken reads the JWT from the token query parameter or the websocket subprotocols
+func getSignallingToken(r *http.Request) (string, error) {
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token, nil
 	}
-	return "", errors.New("No Classroom ID Specified")
+
+	protocols := websocket.Subprotocols(r)
+	for i, protocol := range protocols {
+		if protocol == signallingTokenSubprotocol && i+1 < len(protocols) {
+			return strings.TrimSpace(protocols[i+1]), nil
+		}
+	}
+
+	return "", errors.New("joining a classroom requires a jwt in the token query parameter or the websocket subprotocol")
 }
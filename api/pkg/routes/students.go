@@ -18,6 +18,7 @@ func InjectStudentsRoutes(subrouter *mux.Router) {
 	accountResource.HandleFunc("/profile/last_name", handleProfileUpdateLastName).Methods("POST")
 	accountResource.HandleFunc("/profile/city", handleProfileUpdateCity).Methods("POST")
 	accountResource.HandleFunc("/profile/country", handleProfileUpdateCountry).Methods("POST")
+	accountResource.HandleFunc("/profile/time_zone", handleProfileUpdateTimeZone).Methods("POST")
 	accountResource.HandleFunc("/profile/description", handleProfileUpdateDescription).Methods("POST")
 	accountResource.HandleFunc("/profile/subtitle", handleProfileUpdateSubtitle).Methods("POST")
 
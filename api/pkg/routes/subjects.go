@@ -16,6 +16,7 @@ func InjectSubjectsRoutes(subrouter *mux.Router) {
 	subrouter.HandleFunc("", handleRequestSubject).Methods("POST")
 	subrouter.HandleFunc("/tutors", handleSubjectTutorsGet).Methods("GET")
 	subrouter.HandleFunc("/tutors/{tid}", handleGetSubjectsForTutor).Methods("GET")
+	subrouter.HandleFunc("/{sid}/group-sessions", handleSubjectGroupSessionsGet).Methods("GET")
 }
 
 //Subject DTO represents an existing subject
@@ -37,6 +38,9 @@ type SubjectTaughtDTO struct {
 	Slug        string    `json:"slug" validate:"required"`
 	Description string    `json:"description"`
 	Price       int64     `json:"price" validate:"required"`
+
+	// Durations are the lesson lengths in minutes the tutor offers
+	Durations []int `json:"durations"`
 }
 
 // Represents a Tutor and their subjects
@@ -57,6 +61,14 @@ type TutorSubjectsResponseDTO struct {
 type SubjectTaughtRequestDTO struct {
 	Description string  `json:"description"`
 	Price       int64 `json:"price"`
+
+	// Durations are the lesson lengths in minutes the tutor offers, only 60 minute lessons if empty
+	Durations []int `json:"durations"`
+}
+
+// SubjectTaughtDurationsUpdateRequestDTO represents the lesson lengths a Tutor wishes to offer for a subject
+type SubjectTaughtDurationsUpdateRequestDTO struct {
+	Durations []int `json:"durations" validate:"required,min=1"`
 }
 
 // SubjectTaughtDescriptionUpdateRequestDTO represents a subject a Tutor wishes to update the description for
@@ -98,6 +110,7 @@ func SubjectTaughtToDTO(subjectTaught *services.SubjectTaught) *SubjectTaughtDTO
 		Slug:        subjectTaught.Subject.Slug,
 		Description: subjectTaught.Description,
 		Price:       subjectTaught.Price,
+		Durations:   subjectTaught.OfferedDurations(),
 	}
 }
 
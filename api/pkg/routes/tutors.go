@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"time"
 
 	"github.com/cs3305-team-4/api/pkg/services"
 	"github.com/gorilla/mux"
@@ -12,6 +13,7 @@ import (
 func InjectTutorsRoutes(subrouter *mux.Router) {
 	// Profile routes
 	subrouter.HandleFunc("/{uuid}/profile", handleProfileGet).Methods("GET")
+	subrouter.HandleFunc("/{uuid}/slots", handleTutorSlotsGet).Methods("GET")
 
 	accountResource := subrouter.PathPrefix("/{uuid}").Subrouter()
 	accountResource.Use(authAccount())
@@ -24,9 +26,13 @@ func InjectTutorsRoutes(subrouter *mux.Router) {
 	accountResource.HandleFunc("/profile/last-name", handleProfileUpdateLastName).Methods("POST")
 	accountResource.HandleFunc("/profile/city", handleProfileUpdateCity).Methods("POST")
 	accountResource.HandleFunc("/profile/country", handleProfileUpdateCountry).Methods("POST")
+	accountResource.HandleFunc("/profile/time-zone", handleProfileUpdateTimeZone).Methods("POST")
 	accountResource.HandleFunc("/profile/description", handleProfileUpdateDescription).Methods("POST")
 	accountResource.HandleFunc("/profile/subtitle", handleProfileUpdateSubtitle).Methods("POST")
 	accountResource.HandleFunc("/profile/availability", handleTutorProfileAvailabilityPost).Methods("POST")
+	accountResource.HandleFunc("/profile/availability/exceptions", handleTutorProfileAvailabilityExceptionsPost).Methods("POST")
+	accountResource.HandleFunc("/profile/availability/exceptions/{eid}", handleTutorProfileAvailabilityExceptionsDelete).Methods("DELETE")
+	accountResource.HandleFunc("/profile/vacation", handleTutorProfileVacationPost).Methods("POST")
 
 	accountResource.HandleFunc("/profile/qualifications", handleTutorProfileQualificationsPost).Methods("POST")
 	accountResource.HandleFunc("/profile/qualifications/{qid}", handleTutorProfileQualificationsDelete).Methods("DELETE")
@@ -38,6 +44,57 @@ func InjectTutorsRoutes(subrouter *mux.Router) {
 	accountResource.HandleFunc("/subjects/{sid}", handleTutorTeachSubject).Methods("POST")
 	accountResource.HandleFunc("/subjects/{stid}/cost", handleTutorSubjectUpdateCost).Methods("POST")
 	accountResource.HandleFunc("/subjects/{stid}/description", handleTutorSubjectUpdateDescription).Methods("POST")
+	accountResource.HandleFunc("/subjects/{stid}/durations", handleTutorSubjectUpdateDurations).Methods("POST")
+}
+
+// TimeSlotDTO represents a span of time a tutor can be booked for
+type TimeSlotDTO struct {
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+}
+
+// handleTutorSlotsGet returns the free times of a tutor between the from and to query parameters (RFC 3339).
+// The range defaults to the next 7 days.
+func handleTutorSlotsGet(w http.ResponseWriter, r *http.Request) {
+	id, err := getUUID(r, "uuid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	q := r.URL.Query()
+
+	from := time.Now()
+	if v := q.Get("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			restError(w, r, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	to := from.Add(7 * 24 * time.Hour)
+	if v := q.Get("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			restError(w, r, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	slots, err := services.ReadTutorFreeSlots(id, from, to)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	outSlots := []TimeSlotDTO{}
+	for _, slot := range slots {
+		outSlots = append(outSlots, TimeSlotDTO{
+			StartTime: slot.StartTime,
+			EndTime:   slot.EndTime,
+		})
+	}
+
+	WriteBody(w, r, outSlots)
 }
 
 func handleTutorProfileQualificationsPost(w http.ResponseWriter, r *http.Request) {
@@ -203,6 +260,112 @@ func handleTutorProfileAvailabilityPost(w http.ResponseWriter, r *http.Request)
 	WriteBody(w, r, profileDto)
 }
 
+func handleTutorProfileAvailabilityExceptionsPost(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUUID(r, "uuid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	dto := &AvailabilityExceptionRequestDTO{}
+	if !ParseBody(w, r, dto) {
+		return
+	}
+	exception := &services.AvailabilityException{
+		StartHour: dto.StartHour,
+		EndHour:   dto.EndHour,
+		Open:      dto.Open,
+		Note:      dto.Note,
+	}
+	if exception.StartDate, err = time.Parse("2006-01-02", dto.StartDate); err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	if exception.EndDate, err = time.Parse("2006-01-02", dto.EndDate); err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	profile, err := exception.SetOnProfileByAccountID(userID)
+	if err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if ok, err := profile.IsAccountType(services.Tutor); err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	} else if !ok {
+		restError(w, r, errors.New("Account type does not match endpoint."), http.StatusBadRequest)
+		return
+	}
+	profileDto := dtoFromProfile(profile, services.Tutor)
+	WriteBody(w, r, profileDto)
+}
+
+func handleTutorProfileAvailabilityExceptionsDelete(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUUID(r, "uuid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	exceptionID, err := getUUID(r, "eid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	profile, err := services.ReadProfileByAccountID(userID, nil)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	if err = profile.RemoveAvailabilityExceptionByID(exceptionID); err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	if ok, err := profile.IsAccountType(services.Tutor); err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	} else if !ok {
+		restError(w, r, errors.New("Account type does not match endpoint."), http.StatusBadRequest)
+		return
+	}
+	profileDto := dtoFromProfile(profile, services.Tutor)
+	WriteBody(w, r, profileDto)
+}
+
+// UpdateVacationDTO DTO.
+type UpdateVacationDTO struct {
+	Value bool `json:"value"`
+}
+
+// handleTutorProfileVacationPost turns vacation mode on or off, a tutor on vacation isn't listed and can't be
+// sent new lesson requests
+func handleTutorProfileVacationPost(w http.ResponseWriter, r *http.Request) {
+	id, err := getUUID(r, "uuid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	update := &UpdateVacationDTO{}
+	if !ParseBody(w, r, update) {
+		return
+	}
+
+	var profile *services.Profile
+	if profile, err = services.UpdateProfileField(id, "vacation", update.Value); err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	if ok, err := profile.IsAccountType(services.Tutor); err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	} else if !ok {
+		restError(w, r, errors.New("Account type does not match endpoint."), http.StatusBadRequest)
+		return
+	}
+	profileDto := dtoFromProfile(profile, services.Tutor)
+	WriteBody(w, r, profileDto)
+}
+
 func handleTutorSubjectsGet(w http.ResponseWriter, r *http.Request) {
 	tid, err := getUUID(r, "uuid")
 	if err != nil {
@@ -272,7 +435,7 @@ func handleTutorTeachSubject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = services.TeachSubject(subject, tutor, subjectRequest.Description, subjectRequest.Price, nil)
+	err = services.TeachSubject(subject, tutor, subjectRequest.Description, subjectRequest.Price, subjectRequest.Durations, nil)
 	if err != nil {
 		restError(w, r, err, http.StatusBadRequest)
 		return
@@ -346,3 +509,45 @@ func handleTutorSubjectUpdateDescription(w http.ResponseWriter, r *http.Request)
 	services.UpdateDescription(stID, subjectTaughtUpdateRequest.Description, nil)
 
 }
+
+// handleTutorSubjectUpdateDurations sets the lesson lengths a tutor offers for a subject
+func handleTutorSubjectUpdateDurations(w http.ResponseWriter, r *http.Request) {
+	stID, err := getUUID(r, "stid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	id, err := getUUID(r, "uuid")
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	authContext, err := ReadRequestAuthContext(r)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if !(authContext.Account.ID == id) {
+		restError(w, r, errors.New("only allowed to update your own subjects"), http.StatusBadRequest)
+		return
+	}
+
+	subjectTaughtUpdateRequest := &SubjectTaughtDurationsUpdateRequestDTO{}
+	if !ParseBody(w, r, subjectTaughtUpdateRequest) {
+		return
+	}
+
+	subjectTaught, err := services.UpdateDurations(stID, id, subjectTaughtUpdateRequest.Durations)
+	if err != nil {
+		restError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(subjectTaught.OfferedDurations()); err != nil {
+		restError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"database/sql/driver"
 	"errors"
 	"fmt"
@@ -25,6 +26,9 @@ const (
 	AccountErrorAccountDoesNotExist  AccountError = "This account does not exist."
 	AccountErrorProfileDoesNotExists AccountError = "A profile does not exist for this account."
 	AccountErrorEntryDoesNotExists   AccountError = "This Entry does not exist."
+	AccountErrorEmailNotVerified     AccountError = "You must verify your email address first."
+	AccountErrorEmailAlreadyVerified AccountError = "This email address has already been verified."
+	AccountErrorInvalidTimeZone      AccountError = "This is not a valid time zone."
 )
 
 // AccountType is the type of account.
@@ -33,6 +37,9 @@ type AccountType string
 const (
 	Tutor   AccountType = "tutor"
 	Student AccountType = "student"
+
+	// Admin accounts moderate the site, they can't be signed up for and have to be created in the database
+	Admin AccountType = "admin"
 )
 
 // ToAccountType will cast to AccounType if it exists.
@@ -69,6 +76,10 @@ func (a *Account) IsTutor() bool {
 	return a.Type == Tutor
 }
 
+func (a *Account) IsAdmin() bool {
+	return a.Type == Admin
+}
+
 // CreateAccount will create an account entry in the DB.
 func CreateAccount(a *Account) error {
 	conn, err := database.Open()
@@ -163,23 +174,37 @@ type PasswordHash struct {
 }
 
 // SetOnAccountID will delete the previous password hash and set it to the new one.
-func (p PasswordHash) SetOnAccountByID(id uuid.UUID) (*Account, error) {
+func (p PasswordHash) SetOnAccountByID(ctx context.Context, id uuid.UUID) (*Account, error) {
 	conn, err := database.Open()
 	if err != nil {
 		return nil, err
 	}
 	var account *Account
-	return account, conn.Transaction(func(tx *gorm.DB) error {
-		account, err = ReadAccountByID(id, tx, "PasswordHash")
-		if err != nil {
-			return err
-		}
-		if err = tx.Delete(&account.PasswordHash).Error; err != nil {
-			return err
-		}
-		account.PasswordHash = p
-		return tx.Save(account).Error
+	err = conn.Transaction(func(tx *gorm.DB) error {
+		account, err = p.setOnAccountByID(ctx, tx, id)
+		return err
 	})
+	return account, err
+}
+
+func (p PasswordHash) setOnAccountByID(ctx context.Context, tx *gorm.DB, id uuid.UUID) (*Account, error) {
+	account, err := ReadAccountByID(id, tx, "PasswordHash")
+	if err != nil {
+		return nil, err
+	}
+	if err = tx.Delete(&account.PasswordHash).Error; err != nil {
+		return nil, err
+	}
+	account.PasswordHash = p
+	if err = tx.Save(account).Error; err != nil {
+		return nil, err
+	}
+	// The hashes are left out of the audit event, it only records that the password changed
+	if err = recordAuditEvent(ctx, tx, AuditAccountPassword, nil, AuditTargetAccount, id, nil, nil); err != nil {
+		return nil, err
+	}
+	// Log out everywhere so a leaked password or token can't be used anymore
+	return account, revokeAccountSessions(tx, id)
 }
 
 func (p *PasswordHash) ValidMatch(match string) bool {
@@ -294,6 +319,15 @@ type Profile struct {
 
 	// Contains the next 14x24 hrs of availbility modulus to 1 week
 	Availability *Availability `gorm:"type:int[]"`
+
+	// TimeZone is the IANA time zone the profile's Availability is in, UTC if empty
+	TimeZone string
+
+	// AvailabilityExceptions are days that are booked differently to the weekly Availability, like holidays
+	AvailabilityExceptions []AvailabilityException `gorm:"foreignKey:ProfileID"`
+
+	// Vacation hides a tutor from the tutor listings and stops new lessons being requested with them
+	Vacation bool `gorm:"not null;default:false"`
 }
 
 // FilterVerifiedFields will filter qualifications and work experience for verified in-place.
@@ -477,7 +511,7 @@ func ReadProfileBySlug(slug string, conn *gorm.DB) (*Profile, error) {
 // ReadProfileByAccountID queries the DB by account ID.
 // conn is optional.
 func ReadProfileByAccountID(id uuid.UUID, conn *gorm.DB) (*Profile, error) {
-	return readProfileByAccountID(id, conn, "Qualifications", "WorkExperience")
+	return readProfileByAccountID(id, conn, "Qualifications", "WorkExperience", "AvailabilityExceptions")
 }
 
 func readProfileByAccountID(id uuid.UUID, conn *gorm.DB, preloads ...string) (*Profile, error) {
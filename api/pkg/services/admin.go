@@ -0,0 +1,222 @@
+package services
+
+import (
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/cs3305-team-4/api/pkg/database"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// AdminError types.
+type AdminError string
+
+func (e AdminError) Error() string {
+	return string(e)
+}
+
+const (
+	AdminErrorSubjectRequestReviewed AdminError = "This subject request has already been reviewed."
+	AdminErrorSelfSuspend            AdminError = "You can not suspend your own account."
+)
+
+// ReadAccountsPaginated returns accounts whose email contains query, optionally only those of a type
+func ReadAccountsPaginated(query string, accountType AccountType, pageSize int, page int) ([]Account, int, error) {
+	db, err := database.Open()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	filter := func(db *gorm.DB) *gorm.DB {
+		db = db.Scopes(Search(SearchQuery{"email", query}))
+		if accountType != "" {
+			db = db.Where(&Account{Type: accountType})
+		}
+		return db
+	}
+
+	var total int64
+	if err = db.Model(&Account{}).Scopes(filter).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var accounts []Account
+	err = db.Scopes(filter, Paginate(pageSize, page)).Order("created_at desc").Find(&accounts).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return accounts, int(math.Ceil(float64(total) / float64(pageSize))), nil
+}
+
+// ReadUnverifiedQualifications returns every qualification waiting to be verified
+func ReadUnverifiedQualifications() ([]Qualification, error) {
+	db, err := database.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	qualifications := []Qualification{}
+	return qualifications, db.Where("verified = ?", false).Order("created_at").Find(&qualifications).Error
+}
+
+// ReadUnverifiedWorkExperience returns every work experience entry waiting to be verified
+func ReadUnverifiedWorkExperience() ([]WorkExperience, error) {
+	db, err := database.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	workExperience := []WorkExperience{}
+	return workExperience, db.Where("verified = ?", false).Order("created_at").Find(&workExperience).Error
+}
+
+// VerifyQualification marks a qualification as checked by an admin
+func VerifyQualification(id uuid.UUID) error {
+	return verifyProfileEntry(&Qualification{}, id)
+}
+
+// RejectQualification removes a qualification that could not be verified from its profile
+func RejectQualification(id uuid.UUID) error {
+	return rejectProfileEntry(&Qualification{}, id)
+}
+
+// VerifyWorkExperience marks a work experience entry as checked by an admin
+func VerifyWorkExperience(id uuid.UUID) error {
+	return verifyProfileEntry(&WorkExperience{}, id)
+}
+
+// RejectWorkExperience removes a work experience entry that could not be verified from its profile
+func RejectWorkExperience(id uuid.UUID) error {
+	return rejectProfileEntry(&WorkExperience{}, id)
+}
+
+func verifyProfileEntry(model interface{}, id uuid.UUID) error {
+	db, err := database.Open()
+	if err != nil {
+		return err
+	}
+
+	res := db.Model(model).Where("id = ?", id).Update("verified", true)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return AccountErrorEntryDoesNotExists
+	}
+	return nil
+}
+
+func rejectProfileEntry(model interface{}, id uuid.UUID) error {
+	db, err := database.Open()
+	if err != nil {
+		return err
+	}
+
+	res := db.Where("id = ?", id).Delete(model)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return AccountErrorEntryDoesNotExists
+	}
+	return nil
+}
+
+// ReadSubjectRequestsByStatus returns subject requests with a status, oldest first
+func ReadSubjectRequestsByStatus(status SubjectRequestStatus) ([]SubjectRequest, error) {
+	db, err := database.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	requests := []SubjectRequest{}
+	return requests, db.Where(&SubjectRequest{Status: status}).Order("created_at").Find(&requests).Error
+}
+
+var slugInvalid = regexp.MustCompile("[^a-z0-9]+")
+
+func subjectSlug(name string) string {
+	return strings.Trim(slugInvalid.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// ApproveSubjectRequest creates the requested subject so tutors can teach it
+func ApproveSubjectRequest(id uuid.UUID) (*Subject, error) {
+	db, err := database.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	var subject *Subject
+	err = db.Transaction(func(tx *gorm.DB) error {
+		request, err := lockPendingSubjectRequest(tx, id)
+		if err != nil {
+			return err
+		}
+
+		slug := subjectSlug(request.Name)
+		if err = CreateSubject(request.Name, "", slug, tx); err != nil {
+			return err
+		}
+
+		subject, err = GetSubjectBySlug(slug, tx)
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(request).Update("status", SubjectRequestApproved).Error
+	})
+	return subject, err
+}
+
+// DenySubjectRequest turns down a subject request, the reason is kept on the request
+func DenySubjectRequest(id uuid.UUID, reason string) error {
+	db, err := database.Open()
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		request, err := lockPendingSubjectRequest(tx, id)
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(request).Updates(map[string]interface{}{
+			"status": SubjectRequestDenied,
+			"reason": reason,
+		}).Error
+	})
+}
+
+func lockPendingSubjectRequest(tx *gorm.DB, id uuid.UUID) (*SubjectRequest, error) {
+	request := &SubjectRequest{}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(request, id).Error; err != nil {
+		return nil, err
+	}
+
+	if request.Status != SubjectRequestPending {
+		return nil, AdminErrorSubjectRequestReviewed
+	}
+	return request, nil
+}
+
+// DeleteReviewByID removes a review regardless of who wrote it
+func DeleteReviewByID(id uuid.UUID) error {
+	db, err := database.Open()
+	if err != nil {
+		return err
+	}
+
+	res := db.Where("id = ?", id).Delete(&Review{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
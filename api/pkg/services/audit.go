@@ -0,0 +1,194 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/cs3305-team-4/api/pkg/database"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// AuditError types.
+type AuditError string
+
+func (e AuditError) Error() string {
+	return string(e)
+}
+
+const (
+	AuditErrorImmutable AuditError = "Audit events can not be changed or removed."
+)
+
+// AuditAction is the kind of action an audit event records
+type AuditAction string
+
+const (
+	AuditLessonScheduled       AuditAction = "lesson.scheduled"
+	AuditLessonCancelled       AuditAction = "lesson.cancelled"
+	AuditLessonRescheduled     AuditAction = "lesson.rescheduled"
+	AuditLessonRefunded        AuditAction = "lesson.refunded"
+	AuditLessonDisputeResolved AuditAction = "lesson.dispute_resolved"
+	AuditGroupSessionCancelled AuditAction = "group_session.cancelled"
+	AuditGroupSeatRefunded     AuditAction = "group_session.seat_refunded"
+	AuditAccountPayout         AuditAction = "account.payout"
+	AuditAccountPassword       AuditAction = "account.password_changed"
+	AuditAccountEmail          AuditAction = "account.email_changed"
+	AuditAccountSuspended      AuditAction = "account.suspended"
+)
+
+// AuditTargetType is the kind of thing an audit event was about
+type AuditTargetType string
+
+const (
+	AuditTargetLesson       AuditTargetType = "lesson"
+	AuditTargetAccount      AuditTargetType = "account"
+	AuditTargetGroupSession AuditTargetType = "group_session"
+)
+
+// AuditEvent records a security or money relevant action. Events are append-only, they can't be updated or deleted.
+type AuditEvent struct {
+	database.Model
+	Action AuditAction `gorm:"index;not null;"`
+
+	// ActorID is the account that performed the action, it is empty for actions taken by the system
+	ActorID *uuid.UUID `gorm:"type:uuid;index"`
+
+	TargetType AuditTargetType `gorm:"index;not null;"`
+	TargetID   uuid.UUID       `gorm:"type:uuid;index"`
+
+	// Before and After are JSON snapshots of what the action changed
+	Before *string `gorm:"type:jsonb"`
+	After  *string `gorm:"type:jsonb"`
+
+	// RequestID is the ID of the API request the action was taken in
+	RequestID string `gorm:"index"`
+}
+
+func (e *AuditEvent) BeforeUpdate(tx *gorm.DB) error {
+	return AuditErrorImmutable
+}
+
+func (e *AuditEvent) BeforeDelete(tx *gorm.DB) error {
+	return AuditErrorImmutable
+}
+
+type auditContextKey string
+
+const (
+	auditRequestIDKey auditContextKey = "services.audit.request_id"
+	auditActorKey     auditContextKey = "services.audit.actor"
+)
+
+// WithRequestID returns a context that records the request ID on audit events
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, auditRequestIDKey, requestID)
+}
+
+// WithActor returns a context that records the account as the actor of audit events
+func WithActor(ctx context.Context, accountID uuid.UUID) context.Context {
+	return context.WithValue(ctx, auditActorKey, accountID)
+}
+
+func auditJSON(v interface{}) (*string, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	out, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	s := string(out)
+	return &s, nil
+}
+
+// recordAuditEvent writes an audit event in tx so it is only kept if the action it records is.
+// The actor is taken from ctx unless it is given.
+func recordAuditEvent(ctx context.Context, tx *gorm.DB, action AuditAction, actorID *uuid.UUID, targetType AuditTargetType, targetID uuid.UUID, before interface{}, after interface{}) error {
+	event := &AuditEvent{
+		Action:     action,
+		ActorID:    actorID,
+		TargetType: targetType,
+		TargetID:   targetID,
+	}
+
+	if ctx != nil {
+		if requestID, ok := ctx.Value(auditRequestIDKey).(string); ok {
+			event.RequestID = requestID
+		}
+		if actor, ok := ctx.Value(auditActorKey).(uuid.UUID); ok && event.ActorID == nil {
+			event.ActorID = &actor
+		}
+	}
+
+	var err error
+	if event.Before, err = auditJSON(before); err != nil {
+		return err
+	}
+	if event.After, err = auditJSON(after); err != nil {
+		return err
+	}
+
+	return tx.Create(event).Error
+}
+
+// AuditEventFilter narrows down the audit events returned by ReadAuditEventsPaginated, empty fields are ignored
+type AuditEventFilter struct {
+	Action     AuditAction
+	ActorID    *uuid.UUID
+	TargetType AuditTargetType
+	TargetID   *uuid.UUID
+	RequestID  string
+	From       *time.Time
+	To         *time.Time
+}
+
+// ReadAuditEventsPaginated returns the audit events matching the filter, newest first
+func ReadAuditEventsPaginated(filter *AuditEventFilter, pageSize int, page int) ([]AuditEvent, int, error) {
+	db, err := database.Open()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	scope := func(db *gorm.DB) *gorm.DB {
+		if filter.Action != "" {
+			db = db.Where("action = ?", filter.Action)
+		}
+		if filter.ActorID != nil {
+			db = db.Where("actor_id = ?", *filter.ActorID)
+		}
+		if filter.TargetType != "" {
+			db = db.Where("target_type = ?", filter.TargetType)
+		}
+		if filter.TargetID != nil {
+			db = db.Where("target_id = ?", *filter.TargetID)
+		}
+		if filter.RequestID != "" {
+			db = db.Where("request_id = ?", filter.RequestID)
+		}
+		if filter.From != nil {
+			db = db.Where("created_at >= ?", *filter.From)
+		}
+		if filter.To != nil {
+			db = db.Where("created_at < ?", *filter.To)
+		}
+		return db
+	}
+
+	var total int64
+	if err = db.Model(&AuditEvent{}).Scopes(scope).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	events := []AuditEvent{}
+	err = db.Scopes(scope, Paginate(pageSize, page)).Order("created_at desc").Find(&events).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return events, int(math.Ceil(float64(total) / float64(pageSize))), nil
+}
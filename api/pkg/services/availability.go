@@ -0,0 +1,215 @@
+package services
+
+import (
+	"sort"
+	"time"
+
+	"github.com/cs3305-team-4/api/pkg/database"
+	"github.com/google/uuid"
+)
+
+// AvailabilityError types.
+type AvailabilityError string
+
+func (e AvailabilityError) Error() string {
+	return string(e)
+}
+
+const (
+	AvailabilityErrorTutorUnavailable AvailabilityError = "The tutor isn't available at that time."
+	AvailabilityErrorInvalidRange     AvailabilityError = "The end of the range must be after its start and at most 31 days later."
+	AvailabilityErrorInvalidException AvailabilityError = "An exception must end on or after the day it starts and cover hours between 0 and 24."
+	AvailabilityErrorOnVacation       AvailabilityError = "The tutor is on vacation and isn't taking new lessons."
+)
+
+// maxSlotRange is the longest range free slots can be read for
+const maxSlotRange = 31 * 24 * time.Hour
+
+// availabilityIndex returns the hour of the week t is in, in the time zone of loc. Weeks start on Monday
+func availabilityIndex(t time.Time, loc *time.Location) int {
+	t = t.In(loc)
+	day := (int(t.Weekday()) + 6) % 7
+	return day*24 + t.Hour()
+}
+
+// startOfLocalHour returns the start of the hour t is in, in the time zone of loc
+func startOfLocalHour(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return t.Add(-(time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())))
+}
+
+// at returns true if the hour t is in is available, in the time zone of loc.
+// A tutor that never set their availability can be booked at any time.
+func (a *Availability) at(t time.Time, loc *time.Location) bool {
+	if a == nil || len(*a) == 0 {
+		return true
+	}
+
+	hours := *a
+	i := availabilityIndex(t, loc)
+	return i < len(hours) && hours[i]
+}
+
+// tutorSchedule is when a tutor is available, their exceptions merged over their weekly availability
+type tutorSchedule struct {
+	availability *Availability
+	exceptions   []AvailabilityException
+	loc          *time.Location
+}
+
+// scheduleOf returns the schedule of the profile, its availability exceptions must be loaded
+func scheduleOf(profile *Profile) *tutorSchedule {
+	return &tutorSchedule{
+		availability: profile.Availability,
+		exceptions:   profile.AvailabilityExceptions,
+		loc:          profile.Location(),
+	}
+}
+
+// availableAt returns true if the tutor is available for the hour t is in.
+// Blocking exceptions win over open ones, which win over the weekly availability.
+func (s *tutorSchedule) availableAt(t time.Time) bool {
+	available := s.availability.at(t, s.loc)
+	for i := range s.exceptions {
+		if !s.exceptions[i].covers(t, s.loc) {
+			continue
+		}
+		if !s.exceptions[i].Open {
+			return false
+		}
+		available = true
+	}
+	return available
+}
+
+// covers returns true if every hour between start and end is available.
+//
+// Hours are stepped through in absolute time, so the hour skipped when clocks go forward is never checked and the
+// hour repeated when they go back is checked twice, each against the weekly pattern.
+func (s *tutorSchedule) covers(start time.Time, end time.Time) bool {
+	for t := startOfLocalHour(start, s.loc); t.Before(end); t = t.Add(time.Hour) {
+		if !s.availableAt(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkTutorAvailable returns AvailabilityErrorTutorUnavailable unless the tutor is available for the whole time
+func checkTutorAvailable(tutorID uuid.UUID, start time.Time, end time.Time) error {
+	profile, err := readProfileByAccountID(tutorID, nil, "AvailabilityExceptions")
+	if err != nil {
+		return err
+	}
+
+	if !scheduleOf(profile).covers(start, end) {
+		return AvailabilityErrorTutorUnavailable
+	}
+	return nil
+}
+
+// TimeSlot is a span of time
+type TimeSlot struct {
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+// subtractSlot removes the time between start and end from the slots
+func subtractSlot(slots []TimeSlot, start time.Time, end time.Time) []TimeSlot {
+	out := []TimeSlot{}
+	for _, slot := range slots {
+		if !end.After(slot.StartTime) || !start.Before(slot.EndTime) {
+			out = append(out, slot)
+			continue
+		}
+
+		if start.After(slot.StartTime) {
+			out = append(out, TimeSlot{StartTime: slot.StartTime, EndTime: start})
+		}
+		if end.Before(slot.EndTime) {
+			out = append(out, TimeSlot{StartTime: end, EndTime: slot.EndTime})
+		}
+	}
+	return out
+}
+
+// ReadTutorFreeSlots returns the times between from and to that the tutor is available and has no lessons.
+// Slots in the past aren't returned, and a tutor on vacation has none.
+func ReadTutorFreeSlots(tutorID uuid.UUID, from time.Time, to time.Time) ([]TimeSlot, error) {
+	if !to.After(from) || to.Sub(from) > maxSlotRange {
+		return nil, AvailabilityErrorInvalidRange
+	}
+
+	from, to = from.UTC(), to.UTC()
+	if now := clock.Now().UTC(); from.Before(now) {
+		from = now
+	}
+
+	profile, err := readProfileByAccountID(tutorID, nil, "AvailabilityExceptions")
+	if err != nil {
+		return nil, err
+	}
+
+	slots := []TimeSlot{}
+	if profile.Vacation {
+		return slots, nil
+	}
+
+	// Expand the schedule over the range in the tutor's time zone, joining hours that follow each other
+	schedule := scheduleOf(profile)
+	for t := startOfLocalHour(from, schedule.loc).UTC(); t.Before(to); t = t.Add(time.Hour) {
+		if !schedule.availableAt(t) {
+			continue
+		}
+
+		start, end := t, t.Add(time.Hour)
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+
+		if n := len(slots); n > 0 && slots[n-1].EndTime.Equal(start) {
+			slots[n-1].EndTime = end
+		} else {
+			slots = append(slots, TimeSlot{StartTime: start, EndTime: end})
+		}
+	}
+
+	db, err := database.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	var lessons []Lesson
+	err = db.Where(
+		"tutor_id = ? AND end_time > ? AND start_time < ? AND request_stage IN ?",
+		tutorID, from, to, activeLessonStages,
+	).Find(&lessons).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, lesson := range lessons {
+		slots = subtractSlot(slots, lesson.StartTime, lesson.EndTime)
+	}
+
+	var sessions []GroupSession
+	err = db.Where(
+		"tutor_id = ? AND end_time > ? AND start_time < ? AND stage IN ?",
+		tutorID, from, to, activeGroupSessionStages,
+	).Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, session := range sessions {
+		slots = subtractSlot(slots, session.StartTime, session.EndTime)
+	}
+
+	sort.Slice(slots, func(i, j int) bool {
+		return slots[i].StartTime.Before(slots[j].StartTime)
+	})
+	return slots, nil
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"time"
+
+	"github.com/cs3305-team-4/api/pkg/database"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// AvailabilityException changes when a tutor is available between StartHour and EndHour of every day from StartDate
+// to EndDate, in the tutor's time zone. It is merged over the weekly Availability of the tutor.
+type AvailabilityException struct {
+	database.Model
+	ProfileID uuid.UUID `gorm:"type:uuid;index"`
+
+	// StartDate and EndDate are the first and last days of the exception, their times are ignored
+	StartDate time.Time `gorm:"type:date"`
+	EndDate   time.Time `gorm:"type:date"`
+
+	// StartHour and EndHour are the hours of each day the exception covers, 0 to 24 is the whole day
+	StartHour int
+	EndHour   int
+
+	// Open makes the tutor available for the hours of the exception, otherwise they are blocked
+	Open bool
+	Note string
+}
+
+// dateOf returns the date of t as midnight UTC, which is how dates are stored
+func dateOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// covers returns true if the hour t is in is part of the exception, in the time zone of loc
+func (e *AvailabilityException) covers(t time.Time, loc *time.Location) bool {
+	t = t.In(loc)
+	day := dateOf(t)
+	if day.Before(dateOf(e.StartDate)) || day.After(dateOf(e.EndDate)) {
+		return false
+	}
+	return t.Hour() >= e.StartHour && t.Hour() < e.EndHour
+}
+
+// SetOnProfileByAccountID will set the availability exception on the profile matching the
+// provided account ID.
+func (e *AvailabilityException) SetOnProfileByAccountID(id uuid.UUID) (*Profile, error) {
+	if e.EndHour == 0 {
+		e.EndHour = 24
+	}
+	e.StartDate, e.EndDate = dateOf(e.StartDate), dateOf(e.EndDate)
+
+	if e.EndDate.Before(e.StartDate) || e.StartHour < 0 || e.EndHour > 24 || e.StartHour >= e.EndHour {
+		return nil, AvailabilityErrorInvalidException
+	}
+
+	var err error
+	conn, err := database.Open()
+	if err != nil {
+		return nil, err
+	}
+	var profile *Profile
+	return profile, conn.Transaction(func(tx *gorm.DB) error {
+		profile, err = ReadProfileByAccountID(id, tx)
+		if err != nil {
+			return err
+		}
+		profile.AvailabilityExceptions = append(profile.AvailabilityExceptions, *e)
+		return tx.Save(profile).Error
+	})
+}
+
+// RemoveAvailabilityExceptionByID removes the availability exception inplace and in the DB.
+func (p *Profile) RemoveAvailabilityExceptionByID(exceptionID uuid.UUID) error {
+	conn, err := database.Open()
+	if err != nil {
+		return err
+	}
+	return conn.Transaction(func(tx *gorm.DB) error {
+		for i, val := range p.AvailabilityExceptions {
+			if val.ID == exceptionID {
+				p.AvailabilityExceptions = append(p.AvailabilityExceptions[:i], p.AvailabilityExceptions[i+1:]...)
+				return tx.Delete(&val).Error
+			}
+		}
+		return AccountErrorEntryDoesNotExists
+	})
+}
+
+// notOnVacation leaves out the profiles of tutors that are on vacation
+func notOnVacation(db *gorm.DB) *gorm.DB {
+	return db.Where("profiles.vacation = ?", false)
+}
+
+// checkTutorNotOnVacation returns AvailabilityErrorOnVacation if the tutor is on vacation
+func checkTutorNotOnVacation(tutorID uuid.UUID) error {
+	profile, err := readProfileByAccountID(tutorID, nil)
+	if err != nil {
+		return err
+	}
+
+	if profile.Vacation {
+		return AvailabilityErrorOnVacation
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"time"
@@ -8,19 +9,7 @@ import (
 	"github.com/cs3305-team-4/api/pkg/database"
 	"github.com/google/uuid"
 	"github.com/spf13/viper"
-	stripe "github.com/stripe/stripe-go/v72"
 	"gorm.io/gorm"
-
-	stripeAccount "github.com/stripe/stripe-go/v72/account"
-	stripeAccountLink "github.com/stripe/stripe-go/v72/accountlink"
-	stripeCheckoutSession "github.com/stripe/stripe-go/v72/checkout/session"
-	stripeCustomer "github.com/stripe/stripe-go/v72/customer"
-	stripeLoginLink "github.com/stripe/stripe-go/v72/loginlink"
-	stripePaymentIntent "github.com/stripe/stripe-go/v72/paymentintent"
-	stripePaymentMethod "github.com/stripe/stripe-go/v72/paymentmethod"
-	stripePayout "github.com/stripe/stripe-go/v72/payout"
-	stripeRefund "github.com/stripe/stripe-go/v72/refund"
-	stripeTransfer "github.com/stripe/stripe-go/v72/transfer"
 )
 
 // SetupBilling sets up billing for an account
@@ -31,62 +20,26 @@ func (ac *Account) SetupBilling() error {
 	}
 
 	if ac.Type == Tutor {
-		billAcc, err := stripeAccount.New(&stripe.AccountParams{
-			Individual: &stripe.PersonParams{
-				Email: stripe.String(ac.Email),
-			},
-			Email: stripe.String(ac.Email),
-			Type:  stripe.String("express"),
-			BusinessProfile: &stripe.AccountBusinessProfileParams{
-				MCC:                stripe.String("8299"),
-				ProductDescription: stripe.String("Tutor for AstraTutor"),
-				SupportEmail:       stripe.String(ac.Email),
-				Name:               stripe.String("AstaTutor - " + ac.Profile.FirstName + " " + ac.Profile.LastName),
-			},
-			Capabilities: &stripe.AccountCapabilitiesParams{
-				Transfers: &stripe.AccountCapabilitiesTransfersParams{
-					Requested: stripe.Bool(true),
-				},
-				CardPayments: &stripe.AccountCapabilitiesCardPaymentsParams{
-					Requested: stripe.Bool(true),
-				},
-				SEPADebitPayments: &stripe.AccountCapabilitiesSEPADebitPaymentsParams{
-					Requested: stripe.Bool(true),
-				},
-			},
-			BusinessType: stripe.String("individual"),
-			Settings: &stripe.AccountSettingsParams{
-				Payouts: &stripe.AccountSettingsPayoutsParams{
-					Schedule: &stripe.PayoutScheduleParams{
-						Interval: stripe.String("manual"),
-					},
-					StatementDescriptor: stripe.String("AstraTutor"),
-				},
-			},
-		})
-
+		id, err := payments.CreateConnectAccount(ac.Email, ac.Profile.FirstName+" "+ac.Profile.LastName)
 		if err != nil {
 			return err
 		}
 
-		ac.StripeID = billAcc.ID
+		ac.StripeID = id
 	} else if ac.Type == Student {
-		cusmAcc, err := stripeCustomer.New(&stripe.CustomerParams{
-			Name:  stripe.String(ac.Profile.FirstName + " " + ac.Profile.LastName),
-			Email: stripe.String(ac.Email),
-		})
+		id, err := payments.CreateCustomer(ac.Profile.FirstName+" "+ac.Profile.LastName, ac.Email)
 		if err != nil {
 			return err
 		}
 
-		ac.StripeID = cusmAcc.ID
+		ac.StripeID = id
 	}
 
 	return nil
 }
 
 func (ac *Account) IsTutorBillingOnboarded() (bool, error) {
-	billAcc, err := stripeAccount.GetByID(ac.StripeID, nil)
+	billAcc, err := payments.GetConnectAccount(ac.StripeID)
 	if err != nil {
 		return false, err
 	}
@@ -95,111 +48,101 @@ func (ac *Account) IsTutorBillingOnboarded() (bool, error) {
 }
 
 func (ac *Account) IsTutorBillingRequirementsMet() (bool, error) {
-	billAcc, err := stripeAccount.GetByID(ac.StripeID, nil)
+	billAcc, err := payments.GetConnectAccount(ac.StripeID)
 	if err != nil {
 		return false, err
 	}
 
-	return len(billAcc.Requirements.CurrentlyDue) == 0 && len(billAcc.Requirements.EventuallyDue) == 0, nil
+	return billAcc.RequirementsDue == 0, nil
 }
 
 func (ac *Account) GetTutorBillingOnboardURL() (string, error) {
-	link, err := stripeAccountLink.New(&stripe.AccountLinkParams{
-		Account:    stripe.String(ac.StripeID),
-		RefreshURL: stripe.String(viper.GetString("billing.stripe.account_link.refresh_url")),
-		ReturnURL:  stripe.String(viper.GetString("billing.stripe.account_link.return_url")),
-		Type:       stripe.String("account_onboarding"),
-	})
-	if err != nil {
-		return "", err
+	if !ac.EmailVerified {
+		return "", AccountErrorEmailNotVerified
 	}
 
-	return link.URL, nil
+	return payments.CreateOnboardingURL(
+		ac.StripeID,
+		viper.GetString("billing.stripe.account_link.refresh_url"),
+		viper.GetString("billing.stripe.account_link.return_url"),
+	)
 }
 
 // GetTutorBillingPanelURL returns a link that a user can use to access their billing account on Stripe
 func (ac *Account) GetTutorBillingPanelURL() (string, error) {
-	params := &stripe.LoginLinkParams{
-		Account: stripe.String(ac.StripeID),
-	}
-
-	ll, err := stripeLoginLink.New(params)
-	if err != nil {
-		return "", err
-	}
-
-	return ll.URL, nil
+	return payments.CreateLoginURL(ac.StripeID)
 }
 
 func (l *Lesson) SetupPaymentIntent() error {
-	subjectTaught := l.SubjectTaught
 	student := l.Student
+	l.setPrice()
 
-	intent, err := stripePaymentIntent.New(&stripe.PaymentIntentParams{
-		Amount:   stripe.Int64(subjectTaught.Price),
-		Currency: stripe.String(string(stripe.CurrencyEUR)),
-		PaymentMethodTypes: stripe.StringSlice([]string{
-			"card",
-		}),
-		SetupFutureUsage: stripe.String(string(stripe.PaymentIntentSetupFutureUsageOffSession)),
-		Customer:         &student.StripeID,
-	})
+	intent, err := payments.CreatePaymentIntent(student.StripeID, l.PriceAmount)
 	if err != nil {
 		return err
 	}
 
 	l.PaymentIntentID = intent.ID
-	l.PayoutAmount = ((subjectTaught.Price) / 100) * (100 - viper.GetInt64("billing.profit_margin"))
-	l.PriceAmount = (subjectTaught.Price)
 	return nil
 }
 
-func (acc *Account) CreateCardSetupSession(successPath string, cancelPath string) (string, error) {
-	types := []*string{stripe.String("card")}
-	checkout, err := stripeCheckoutSession.New(&stripe.CheckoutSessionParams{
-		SuccessURL:         stripe.String(viper.GetString("ui.base_url") + successPath),
-		CancelURL:          stripe.String(viper.GetString("ui.base_url") + cancelPath),
-		PaymentMethodTypes: types,
-		Customer:           &acc.StripeID,
-		Mode:               stripe.String(string(stripe.CheckoutSessionModeSetup)),
-	})
-	if err != nil {
-		return "", err
-	}
+// setPrice sets the price of the lesson and what the tutor is paid for it from the subject it is for
+func (l *Lesson) setPrice() {
+	// The price of the subject is for a lesson of the default length, longer lessons cost more
+	price := l.SubjectTaught.PriceFor(int(l.Duration() / time.Minute))
 
-	return checkout.ID, err
+	l.PayoutAmount = ((price) / 100) * (100 - viper.GetInt64("billing.profit_margin"))
+	l.PriceAmount = (price)
 }
 
-func (acc *Account) GetCards() ([]stripe.PaymentMethod, error) {
-	res := stripePaymentMethod.List(&stripe.PaymentMethodListParams{
-		Customer: stripe.String(acc.StripeID),
-		Type:     stripe.String("card"),
-	})
-
-	ret := []stripe.PaymentMethod{}
-	for res.Next() {
-		ret = append(ret, *res.PaymentMethod())
+// ensureSeriesPaymentIntent gives a lesson of a series its payment intent the first time the student pays for it.
+// It runs outside of any transaction so accepting a series doesn't call Stripe once for every lesson.
+func (l *Lesson) ensureSeriesPaymentIntent() error {
+	if l.PaymentIntentID != "" {
+		return nil
 	}
 
-	return ret, nil
-}
-
-func (acc *Account) DeleteCard(id string) error {
-	pm, err := stripePaymentMethod.Get(id, nil)
+	db, err := database.Open()
 	if err != nil {
 		return err
 	}
 
-	if pm.Customer.ID != acc.StripeID {
-		return errors.New("this payment method is not associated with the specified account")
-	}
-
-	_, err = stripePaymentMethod.Detach(id, nil)
+	priced := &Lesson{}
+	err = db.Preload("Student").Preload("SubjectTaught").First(priced, l.ID).Error
 	if err != nil {
 		return err
 	}
+	if priced.PaymentIntentID == "" {
+		if err = priced.SetupPaymentIntent(); err != nil {
+			return err
+		}
+
+		// Only the first intent made for the lesson is kept, if two were made at once the other is never paid
+		err = db.Model(&Lesson{}).
+			Where("id = ? AND payment_intent_id = ?", l.ID, "").
+			Update("payment_intent_id", priced.PaymentIntentID).Error
+		if err != nil {
+			return err
+		}
+	}
 
-	return nil
+	return db.Select("payment_intent_id").First(l, l.ID).Error
+}
+
+func (acc *Account) CreateCardSetupSession(successPath string, cancelPath string) (string, error) {
+	return payments.CreateCardSetupSession(
+		acc.StripeID,
+		viper.GetString("ui.base_url")+successPath,
+		viper.GetString("ui.base_url")+cancelPath,
+	)
+}
+
+func (acc *Account) GetCards() ([]Card, error) {
+	return payments.ListCards(acc.StripeID)
+}
+
+func (acc *Account) DeleteCard(id string) error {
+	return payments.DetachCard(acc.StripeID, id)
 }
 
 type PayeePayment struct {
@@ -268,6 +211,29 @@ func (acc *Account) GetPayeesPayments() ([]PayeePayment, error) {
 		})
 	}
 
+	var seats []GroupSeat
+	err = db.Preload("GroupSession").Where(&GroupSeat{
+		StudentID: acc.ID,
+		Paid:      true,
+	}).Find(&seats).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, seat := range seats {
+		remarks := ""
+		if seat.Refunded {
+			remarks = "Refunded"
+		}
+
+		payees = append(payees, PayeePayment{
+			Description: seat.GroupSession.StartTime.Format("Group lesson on 2006-01-02"),
+			Date:        *seat.DatePaid,
+			Amount:      seat.PriceAmount,
+			Remarks:     remarks,
+		})
+	}
+
 	return payees, nil
 }
 
@@ -292,7 +258,20 @@ func (acc *Account) GetPayoutInfo() (*PayoutInfo, error) {
 	}, nil
 }
 
-func (acc *Account) Payout() error {
+// settledLessonStages are the stages of lessons the tutor is owed the payment for
+var settledLessonStages = []LessonRequestStage{Completed, NoShowStudent}
+
+// settled returns true if the lesson is in one of the settledLessonStages
+func (l *Lesson) settled() bool {
+	for _, stage := range settledLessonStages {
+		if l.RequestStage == stage {
+			return true
+		}
+	}
+	return false
+}
+
+func (acc *Account) Payout(ctx context.Context) error {
 	if acc.Type != Tutor {
 		return errors.New("only tutors can receive payouts")
 	}
@@ -303,13 +282,16 @@ func (acc *Account) Payout() error {
 	}
 
 	err = db.Transaction(func(tx *gorm.DB) error {
-		// Find every lesson the tutor has that has been paid for
+		// Find every settled lesson the tutor has that has been paid for
 		var unpaidOutlessons []Lesson
 		err := tx.Where(&Lesson{
-			TutorID: acc.ID,
-			Paid:    true,
-			PaidOut: false,
-		}, "TutorID", "Paid", "PaidOut").Find(&unpaidOutlessons).Error
+			TutorID:  acc.ID,
+			Paid:     true,
+			PaidOut:  false,
+			Refunded: false,
+		}, "TutorID", "Paid", "PaidOut", "Refunded").
+			Where("request_stage IN ?", settledLessonStages).
+			Scopes(notDisputed).Find(&unpaidOutlessons).Error
 		if err != nil {
 			tx.Rollback()
 			return err
@@ -321,7 +303,7 @@ func (acc *Account) Payout() error {
 
 		for _, lesson := range unpaidOutlessons {
 			if !viper.GetBool("billing.allow_instant_payouts") {
-				duration := time.Now().Sub(lesson.StartTime)
+				duration := clock.Now().Sub(lesson.StartTime)
 				numDays := int(duration.Hours()) / 24
 
 				if numDays > 14 {
@@ -334,7 +316,26 @@ func (acc *Account) Payout() error {
 			}
 		}
 
-		now := time.Now()
+		// Seats of completed group sessions are paid out by the same rules as lessons
+		seats, err := readPaidGroupSeatsByTutorID(tx, acc.ID)
+		if err != nil {
+			return err
+		}
+
+		paidSeatIds := []uuid.UUID{}
+		for _, seat := range seats {
+			if seat.PaidOut {
+				continue
+			}
+
+			numDays := int(clock.Now().Sub(seat.GroupSession.StartTime).Hours()) / 24
+			if viper.GetBool("billing.allow_instant_payouts") || numDays > 14 {
+				amount += seat.PayoutAmount
+				paidSeatIds = append(paidSeatIds, seat.ID)
+			}
+		}
+
+		now := clock.Now()
 
 		err = tx.Model(Lesson{}).Where("id IN ?", paidLessonIds).Updates(Lesson{PaidOut: true, DatePaidOut: &now}).Error
 		if err != nil {
@@ -342,25 +343,27 @@ func (acc *Account) Payout() error {
 			return err
 		}
 
-		transferParams := &stripe.TransferParams{
-			Amount:      stripe.Int64(amount),
-			Currency:    stripe.String(string(stripe.CurrencyEUR)),
-			Destination: stripe.String(acc.StripeID),
+		err = tx.Model(GroupSeat{}).Where("id IN ?", paidSeatIds).Updates(GroupSeat{PaidOut: true, DatePaidOut: &now}).Error
+		if err != nil {
+			return err
 		}
 
-		_, err = stripeTransfer.New(transferParams)
+		err = recordAuditEvent(ctx, tx, AuditAccountPayout, &acc.ID, AuditTargetAccount, acc.ID, nil, map[string]interface{}{
+			"amount":         amount,
+			"lesson_ids":     paidLessonIds,
+			"group_seat_ids": paidSeatIds,
+		})
 		if err != nil {
-			tx.Rollback()
 			return err
 		}
 
-		params := &stripe.PayoutParams{
-			Amount:   stripe.Int64(amount),
-			Currency: stripe.String(string(stripe.CurrencyEUR)),
+		err = payments.CreateTransfer(acc.StripeID, amount)
+		if err != nil {
+			tx.Rollback()
+			return err
 		}
-		params.SetStripeAccount(acc.StripeID)
 
-		_, err = stripePayout.New(params)
+		err = payments.CreatePayout(acc.StripeID, amount)
 		if err != nil {
 			tx.Rollback()
 			return err
@@ -386,20 +389,47 @@ func (acc *Account) GetPayersPayments() ([]PayerPayment, error) {
 		return nil, err
 	}
 
-	// Find every lesson the tutor has that has been paid for
+	// Find every lesson the tutor has that has been paid for, refunded lessons were never the tutor's to be paid
 	var lessons []Lesson
 	err = db.Where(&Lesson{
 		TutorID: acc.ID,
 		Paid:    true,
-	}).Find(&lessons).Error
+	}).Where("refunded = ?", false).Find(&lessons).Error
 	if err != nil {
 		return nil, err
 	}
 
+	// Lessons with an open dispute can't be paid out until it is resolved
+	var held []uuid.UUID
+	err = db.Model(&Dispute{}).
+		Where("status = ? AND lesson_id IN (?)", DisputeOpen, db.Model(&Lesson{}).Select("id").Where(&Lesson{TutorID: acc.ID})).
+		Pluck("lesson_id", &held).Error
+	if err != nil {
+		return nil, err
+	}
+
+	heldLessons := map[uuid.UUID]bool{}
+	for _, id := range held {
+		heldLessons[id] = true
+	}
+
 	var payers []PayerPayment
 	payers = []PayerPayment{}
 
 	for _, lesson := range lessons {
+		if heldLessons[lesson.ID] && !lesson.PaidOut {
+			payers = append(payers, PayerPayment{
+				Description:        lesson.StartTime.Format("Lesson on 2006-01-02"),
+				Date:               *lesson.DatePaid,
+				Amount:             lesson.PayoutAmount,
+				Remarks:            "Held while the lesson is disputed",
+				AvailableForPayout: false,
+				PaidOut:            false,
+			})
+
+			continue
+		}
+
 		if lesson.PaidOut {
 			payers = append(payers, PayerPayment{
 				Description:        lesson.StartTime.Format("Lesson on 2006-01-02"),
@@ -413,8 +443,21 @@ func (acc *Account) GetPayersPayments() ([]PayerPayment, error) {
 			continue
 		}
 
+		if !lesson.settled() {
+			payers = append(payers, PayerPayment{
+				Description:        lesson.StartTime.Format("Lesson on 2006-01-02"),
+				Date:               *lesson.DatePaid,
+				Amount:             lesson.PayoutAmount,
+				Remarks:            "Available for payout once the lesson is completed",
+				AvailableForPayout: false,
+				PaidOut:            false,
+			})
+
+			continue
+		}
+
 		if !viper.GetBool("billing.allow_instant_payouts") {
-			duration := time.Now().Sub(lesson.StartTime)
+			duration := clock.Now().Sub(lesson.StartTime)
 			numDays := int(duration.Hours()) / 24
 
 			if numDays >= 14 {
@@ -448,75 +491,128 @@ func (acc *Account) GetPayersPayments() ([]PayerPayment, error) {
 		}
 	}
 
+	seats, err := readPaidGroupSeatsByTutorID(db, acc.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, seat := range seats {
+		payer := PayerPayment{
+			Description: seat.GroupSession.StartTime.Format("Group lesson on 2006-01-02"),
+			Date:        *seat.DatePaid,
+			Amount:      seat.PayoutAmount,
+			PaidOut:     seat.PaidOut,
+		}
+
+		if !seat.PaidOut {
+			numDays := int(clock.Now().Sub(seat.GroupSession.StartTime).Hours()) / 24
+			if viper.GetBool("billing.allow_instant_payouts") || numDays > 14 {
+				payer.AvailableForPayout = true
+			} else {
+				payer.Remarks = fmt.Sprintf("Available for payout in %d days", (15 - numDays))
+			}
+		}
+
+		payers = append(payers, payer)
+	}
+
 	return payers, nil
 }
 
-func (l *Lesson) Refund() error {
-	if l.Refunded == true {
-		return nil
+func (l *Lesson) Refund(ctx context.Context) error {
+	db, err := database.Open()
+	if err != nil {
+		return err
 	}
 
-	_, err := stripeRefund.New(&stripe.RefundParams{
-		PaymentIntent: stripe.String(l.PaymentIntentID),
+	return db.Transaction(func(tx *gorm.DB) error {
+		return l.refund(ctx, tx)
 	})
-	if err != nil {
-		return err
+}
+
+// refund gives the student their money back, the lesson is marked refunded in tx
+func (l *Lesson) refund(ctx context.Context, tx *gorm.DB) error {
+	if l.Refunded == true {
+		return nil
 	}
-	db, err := database.Open()
+
+	err := payments.RefundPaymentIntent(l.PaymentIntentID)
 	if err != nil {
 		return err
 	}
 
-	err = db.Transaction(func(tx *gorm.DB) error {
-		return tx.Model(&l).Updates(&Lesson{
-			Refunded: true,
-		}).Error
-	})
-
+	err = tx.Model(&Lesson{Model: l.Model}).Updates(&Lesson{
+		Refunded: true,
+	}).Error
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return recordAuditEvent(ctx, tx, AuditLessonRefunded, nil, AuditTargetLesson, l.ID,
+		map[string]interface{}{"refunded": false},
+		map[string]interface{}{"refunded": true, "payment_intent_id": l.PaymentIntentID, "amount": l.PriceAmount},
+	)
 }
 
 // RereshPaidStatus double checks with Stripe if the lesson has been paid for yet, and if it has, updates the lesson
 func (l *Lesson) RefreshPaidStatus() error {
-	if l.Paid == true {
+	if l.Paid == true || l.PaymentIntentID == "" {
 		return nil
 	}
 
-	intent, err := stripePaymentIntent.Get(l.PaymentIntentID, nil)
+	intent, err := payments.GetPaymentIntent(l.PaymentIntentID)
 	if err != nil {
 		return err
 	}
 
-	if intent.Status == stripe.PaymentIntentStatusSucceeded {
-		db, err := database.Open()
-		if err != nil {
-			return err
-		}
+	update := l.paymentUpdate(intent.Status, clock.Now())
+	if update == nil {
+		return nil
+	}
 
-		err = db.Transaction(func(tx *gorm.DB) error {
-			now := time.Now()
+	db, err := database.Open()
+	if err != nil {
+		return err
+	}
 
-			return tx.Model(l).Updates(&Lesson{
-				Paid:     true,
-				DatePaid: &now,
-			}).Error
-		})
-		if err != nil {
-			return err
+	if err = db.Model(l).Updates(update).Error; err != nil {
+		return err
+	}
+
+	if update.Paid {
+		l.Paid, l.DatePaid = true, update.DatePaid
+	}
+	if update.Refunded {
+		l.Refunded = true
+	}
+	return nil
+}
+
+// paymentUpdate returns the changes to make to the lesson now that its payment intent has the status, or nil if
+// there are none
+func (l *Lesson) paymentUpdate(status PaymentIntentStatus, now time.Time) *Lesson {
+	switch status {
+	case PaymentIntentSucceeded:
+		if !l.Paid {
+			return &Lesson{Paid: true, DatePaid: &now}
 		}
 
-		l.Paid = true
+	case PaymentIntentRefunded:
+		// A refunded intent doesn't pay for the lesson, it only records that the money went back
+		if !l.Refunded {
+			return &Lesson{Refunded: true}
+		}
 	}
 
 	return nil
 }
 
 func (l *Lesson) GetPaymentIntentClientSecret() (string, error) {
-	intent, err := stripePaymentIntent.Get(l.PaymentIntentID, nil)
+	if err := l.ensureSeriesPaymentIntent(); err != nil {
+		return "", err
+	}
+
+	intent, err := payments.GetPaymentIntent(l.PaymentIntentID)
 	if err != nil {
 		return "", err
 	}
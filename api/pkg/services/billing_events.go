@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/cs3305-team-4/api/pkg/database"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// BillingEvent records an event sent by the payment provider that has been processed, so redelivered events are ignored
+type BillingEvent struct {
+	database.Model
+	EventID string `gorm:"unique;not null;"`
+	Type    string
+}
+
+// HandleBillingEvent runs handle inside a transaction unless an event with the same ID has already been processed
+func HandleBillingEvent(eventID string, eventType string, handle func(tx *gorm.DB) error) error {
+	db, err := database.Open()
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var processed BillingEvent
+		err := tx.Where(&BillingEvent{EventID: eventID}).First(&processed).Error
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		if err = handle(tx); err != nil {
+			return err
+		}
+
+		return tx.Create(&BillingEvent{
+			EventID: eventID,
+			Type:    eventType,
+		}).Error
+	})
+}
+
+// readLessonByPaymentIntentID finds the lesson a payment intent was created for, returns nil if there is none
+func readLessonByPaymentIntentID(tx *gorm.DB, paymentIntentID string) (*Lesson, error) {
+	var lesson Lesson
+	res := tx.Where(&Lesson{PaymentIntentID: paymentIntentID}).Limit(1).Find(&lesson)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	return &lesson, nil
+}
+
+// LessonPaymentSucceeded marks the lesson paid for by the payment intent as paid, and schedules it if it was waiting on payment.
+// Lessons that were cancelled, expired or denied before the payment arrived are refunded.
+// Payment intents of group session seats mark the seat paid instead.
+func LessonPaymentSucceeded(ctx context.Context, tx *gorm.DB, paymentIntentID string) error {
+	lesson, err := readLessonByPaymentIntentID(tx, paymentIntentID)
+	if err != nil {
+		return err
+	}
+	if lesson == nil {
+		return groupSeatPaymentSucceeded(ctx, tx, paymentIntentID)
+	}
+
+	if lesson.Paid {
+		return nil
+	}
+
+	now := time.Now()
+	err = tx.Model(lesson).Updates(&Lesson{
+		Paid:     true,
+		DatePaid: &now,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	switch lesson.RequestStage {
+	case PaymentRequired:
+		// The student paid, so they are the one scheduling the lesson
+		student := &Account{Model: database.Model{ID: lesson.StudentID}}
+		return transitionLesson(ctx, tx, lesson.ID, student, Scheduled, &LessonChange{Reason: "payment received"})
+
+	case Cancelled, Expired, Denied:
+		// The lesson ended while the student was paying, so they get their money back
+		return lesson.refund(ctx, tx)
+	}
+
+	return nil
+}
+
+// LessonPaymentFailed leaves the lesson waiting on payment so the student can retry with another card
+func LessonPaymentFailed(tx *gorm.DB, paymentIntentID string) error {
+	lesson, err := readLessonByPaymentIntentID(tx, paymentIntentID)
+	if err != nil || lesson == nil {
+		return err
+	}
+
+	log.Warnf("payment failed for lesson %s", lesson.ID)
+	return nil
+}
+
+// LessonPaymentRefunded marks the lesson or group session seat paid for by the payment intent as refunded
+func LessonPaymentRefunded(tx *gorm.DB, paymentIntentID string) error {
+	lesson, err := readLessonByPaymentIntentID(tx, paymentIntentID)
+	if err != nil {
+		return err
+	}
+	if lesson == nil {
+		return groupSeatPaymentRefunded(tx, paymentIntentID)
+	}
+
+	if lesson.Refunded {
+		return nil
+	}
+
+	return tx.Model(lesson).Updates(&Lesson{
+		Refunded: true,
+	}).Error
+}
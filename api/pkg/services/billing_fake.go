@@ -0,0 +1,261 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// FakePaymentProvider is an in-memory payment provider for tests and local development.
+// Payment intents stay pending until they are marked as paid or failed with SimulatePaymentSucceeded and SimulatePaymentFailed.
+type FakePaymentProvider struct {
+	mu sync.Mutex
+
+	customers map[string]string
+	accounts  map[string]*ConnectAccount
+	cards     map[string][]Card
+	intents   map[string]*PaymentIntent
+
+	// balances contains the amount transferred to each connect account that has not been paid out yet
+	balances map[string]int64
+
+	// Transfers and Payouts record every amount moved, in the order they happened
+	Transfers []FakeMovement
+	Payouts   []FakeMovement
+}
+
+// FakeMovement is a transfer or payout recorded by the fake payment provider
+type FakeMovement struct {
+	AccountID string
+	Amount    int64
+}
+
+// NewFakePaymentProvider creates an empty fake payment provider
+func NewFakePaymentProvider() *FakePaymentProvider {
+	return &FakePaymentProvider{
+		customers: map[string]string{},
+		accounts:  map[string]*ConnectAccount{},
+		cards:     map[string][]Card{},
+		intents:   map[string]*PaymentIntent{},
+		balances:  map[string]int64{},
+	}
+}
+
+func fakeID(prefix string) string {
+	return fmt.Sprintf("%s_fake_%s", prefix, uuid.New().String())
+}
+
+func (f *FakePaymentProvider) CreateCustomer(name string, email string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	id := fakeID("cus")
+	f.customers[id] = email
+	return id, nil
+}
+
+func (f *FakePaymentProvider) CreateConnectAccount(email string, displayName string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Fake accounts are onboarded straight away so they can be paid out to
+	id := fakeID("acct")
+	f.accounts[id] = &ConnectAccount{
+		ID:             id,
+		ChargesEnabled: true,
+	}
+	return id, nil
+}
+
+func (f *FakePaymentProvider) GetConnectAccount(id string) (*ConnectAccount, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	acc, ok := f.accounts[id]
+	if !ok {
+		return nil, fmt.Errorf("no such connect account %s", id)
+	}
+
+	ret := *acc
+	return &ret, nil
+}
+
+func (f *FakePaymentProvider) CreateOnboardingURL(accountID string, refreshURL string, returnURL string) (string, error) {
+	if _, err := f.GetConnectAccount(accountID); err != nil {
+		return "", err
+	}
+
+	return returnURL, nil
+}
+
+func (f *FakePaymentProvider) CreateLoginURL(accountID string) (string, error) {
+	if _, err := f.GetConnectAccount(accountID); err != nil {
+		return "", err
+	}
+
+	return "https://localhost/fake-billing-panel/" + accountID, nil
+}
+
+func (f *FakePaymentProvider) CreateCardSetupSession(customerID string, successURL string, cancelURL string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.customers[customerID]; !ok {
+		return "", fmt.Errorf("no such customer %s", customerID)
+	}
+
+	// Setting up a session straight away saves a test card on the customer
+	f.cards[customerID] = append(f.cards[customerID], Card{
+		ID:       fakeID("pm"),
+		Brand:    "visa",
+		Last4:    "4242",
+		ExpMonth: 12,
+		ExpYear:  2099,
+	})
+
+	return fakeID("cs"), nil
+}
+
+func (f *FakePaymentProvider) ListCards(customerID string) ([]Card, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ret := []Card{}
+	ret = append(ret, f.cards[customerID]...)
+	return ret, nil
+}
+
+func (f *FakePaymentProvider) DetachCard(customerID string, cardID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, card := range f.cards[customerID] {
+		if card.ID == cardID {
+			f.cards[customerID] = append(f.cards[customerID][:i], f.cards[customerID][i+1:]...)
+			return nil
+		}
+	}
+
+	return errors.New("this payment method is not associated with the specified account")
+}
+
+func (f *FakePaymentProvider) CreatePaymentIntent(customerID string, amount int64) (*PaymentIntent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.customers[customerID]; !ok {
+		return nil, fmt.Errorf("no such customer %s", customerID)
+	}
+
+	id := fakeID("pi")
+	intent := &PaymentIntent{
+		ID:           id,
+		ClientSecret: id + "_secret",
+		Amount:       amount,
+		Status:       PaymentIntentPending,
+	}
+	f.intents[id] = intent
+
+	ret := *intent
+	return &ret, nil
+}
+
+func (f *FakePaymentProvider) GetPaymentIntent(id string) (*PaymentIntent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	intent, ok := f.intents[id]
+	if !ok {
+		return nil, fmt.Errorf("no such payment intent %s", id)
+	}
+
+	ret := *intent
+	return &ret, nil
+}
+
+func (f *FakePaymentProvider) RefundPaymentIntent(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	intent, ok := f.intents[id]
+	if !ok {
+		return fmt.Errorf("no such payment intent %s", id)
+	}
+
+	if intent.Status != PaymentIntentSucceeded {
+		return fmt.Errorf("payment intent %s is %s and cannot be refunded", id, intent.Status)
+	}
+
+	intent.Status = PaymentIntentRefunded
+	return nil
+}
+
+func (f *FakePaymentProvider) CreateTransfer(destinationID string, amount int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.accounts[destinationID]; !ok {
+		return fmt.Errorf("no such connect account %s", destinationID)
+	}
+
+	f.balances[destinationID] += amount
+	f.Transfers = append(f.Transfers, FakeMovement{AccountID: destinationID, Amount: amount})
+	return nil
+}
+
+func (f *FakePaymentProvider) CreatePayout(accountID string, amount int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.balances[accountID] < amount {
+		return fmt.Errorf("insufficient funds in connect account %s", accountID)
+	}
+
+	f.balances[accountID] -= amount
+	f.Payouts = append(f.Payouts, FakeMovement{AccountID: accountID, Amount: amount})
+	return nil
+}
+
+// SimulatePaymentSucceeded marks a pending payment intent as paid
+func (f *FakePaymentProvider) SimulatePaymentSucceeded(id string) error {
+	return f.simulateStatus(id, PaymentIntentSucceeded)
+}
+
+// SimulatePaymentFailed marks a pending payment intent as failed
+func (f *FakePaymentProvider) SimulatePaymentFailed(id string) error {
+	return f.simulateStatus(id, PaymentIntentFailed)
+}
+
+func (f *FakePaymentProvider) simulateStatus(id string, status PaymentIntentStatus) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	intent, ok := f.intents[id]
+	if !ok {
+		return fmt.Errorf("no such payment intent %s", id)
+	}
+
+	if intent.Status != PaymentIntentPending && intent.Status != PaymentIntentFailed {
+		return fmt.Errorf("payment intent %s is already %s", id, intent.Status)
+	}
+
+	intent.Status = status
+	return nil
+}
+
+// SetRequirementsDue sets how many onboarding requirements are outstanding on a fake connect account
+func (f *FakePaymentProvider) SetRequirementsDue(accountID string, due int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	acc, ok := f.accounts[accountID]
+	if !ok {
+		return fmt.Errorf("no such connect account %s", accountID)
+	}
+
+	acc.RequirementsDue = due
+	acc.ChargesEnabled = due == 0
+	return nil
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+// PaymentIntentStatus is the provider independent status of a payment intent
+type PaymentIntentStatus string
+
+const (
+	// The payment intent is waiting on the customer to pay
+	PaymentIntentPending PaymentIntentStatus = "pending"
+
+	// The payment intent has been paid
+	PaymentIntentSucceeded PaymentIntentStatus = "succeeded"
+
+	// The last attempt to pay the payment intent failed
+	PaymentIntentFailed PaymentIntentStatus = "failed"
+
+	// The payment intent was paid and has since been refunded
+	PaymentIntentRefunded PaymentIntentStatus = "refunded"
+)
+
+// PaymentIntent is a request for a customer to pay an amount
+type PaymentIntent struct {
+	ID           string
+	ClientSecret string
+	Amount       int64
+	Status       PaymentIntentStatus
+}
+
+// ConnectAccount is an account money can be transferred and paid out to
+type ConnectAccount struct {
+	ID string
+
+	// ChargesEnabled is true once the account has been onboarded
+	ChargesEnabled bool
+
+	// RequirementsDue is the number of requirements currently or eventually due on the account
+	RequirementsDue int
+}
+
+// Card is a card a customer has saved to pay with
+type Card struct {
+	ID       string
+	Brand    string
+	Last4    string
+	ExpMonth uint64
+	ExpYear  uint64
+
+	// Name is the name of the cardholder
+	Name string
+}
+
+// PaymentProvider is implemented by anything that can handle billing for accounts and lessons
+type PaymentProvider interface {
+	// CreateCustomer creates a customer that can pay for lessons and returns its ID
+	CreateCustomer(name string, email string) (string, error)
+
+	// CreateConnectAccount creates an account that can be paid out to and returns its ID
+	CreateConnectAccount(email string, displayName string) (string, error)
+
+	GetConnectAccount(id string) (*ConnectAccount, error)
+
+	// CreateOnboardingURL returns a link the owner of the connect account can use to onboard
+	CreateOnboardingURL(accountID string, refreshURL string, returnURL string) (string, error)
+
+	// CreateLoginURL returns a link the owner of the connect account can use to access their billing panel
+	CreateLoginURL(accountID string) (string, error)
+
+	// CreateCardSetupSession returns the ID of a session a customer can use to save a card
+	CreateCardSetupSession(customerID string, successURL string, cancelURL string) (string, error)
+
+	// ListCards returns the cards the customer has saved
+	ListCards(customerID string) ([]Card, error)
+
+	// DetachCard removes a card from a customer, it errors if the card does not belong to the customer
+	DetachCard(customerID string, cardID string) error
+
+	CreatePaymentIntent(customerID string, amount int64) (*PaymentIntent, error)
+
+	GetPaymentIntent(id string) (*PaymentIntent, error)
+
+	// RefundPaymentIntent refunds the full amount of a paid payment intent
+	RefundPaymentIntent(id string) error
+
+	// CreateTransfer moves an amount from the platform to a connect account
+	CreateTransfer(destinationID string, amount int64) error
+
+	// CreatePayout pays out an amount from a connect account to its bank account
+	CreatePayout(accountID string, amount int64) error
+}
+
+var payments PaymentProvider
+
+// Payments returns the payment provider in use
+func Payments() PaymentProvider {
+	return payments
+}
+
+// SetPayments replaces the payment provider in use, this is intended for tests and local development
+func SetPayments(p PaymentProvider) {
+	payments = p
+}
+
+// NewPaymentProvider creates the payment provider selected by billing.provider in the config
+func NewPaymentProvider() (PaymentProvider, error) {
+	switch viper.GetString("billing.provider") {
+	case "", "stripe":
+		return NewStripePaymentProvider(viper.GetString("billing.stripe.secret_key")), nil
+	case "fake":
+		return NewFakePaymentProvider(), nil
+	default:
+		return nil, fmt.Errorf("unknown billing provider %s", viper.GetString("billing.provider"))
+	}
+}
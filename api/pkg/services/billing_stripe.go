@@ -0,0 +1,269 @@
+package services
+
+import (
+	"errors"
+
+	stripe "github.com/stripe/stripe-go/v72"
+
+	stripeAccount "github.com/stripe/stripe-go/v72/account"
+	stripeAccountLink "github.com/stripe/stripe-go/v72/accountlink"
+	stripeCheckoutSession "github.com/stripe/stripe-go/v72/checkout/session"
+	stripeCustomer "github.com/stripe/stripe-go/v72/customer"
+	stripeLoginLink "github.com/stripe/stripe-go/v72/loginlink"
+	stripePaymentIntent "github.com/stripe/stripe-go/v72/paymentintent"
+	stripePaymentMethod "github.com/stripe/stripe-go/v72/paymentmethod"
+	stripePayout "github.com/stripe/stripe-go/v72/payout"
+	stripeRefund "github.com/stripe/stripe-go/v72/refund"
+	stripeTransfer "github.com/stripe/stripe-go/v72/transfer"
+)
+
+// StripePaymentProvider handles billing through Stripe, tutors are Stripe Connect express accounts and students are Stripe customers
+type StripePaymentProvider struct{}
+
+// NewStripePaymentProvider creates a Stripe payment provider using the specified secret key
+func NewStripePaymentProvider(secretKey string) *StripePaymentProvider {
+	stripe.Key = secretKey
+	return &StripePaymentProvider{}
+}
+
+func (s *StripePaymentProvider) CreateCustomer(name string, email string) (string, error) {
+	cusmAcc, err := stripeCustomer.New(&stripe.CustomerParams{
+		Name:  stripe.String(name),
+		Email: stripe.String(email),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return cusmAcc.ID, nil
+}
+
+func (s *StripePaymentProvider) CreateConnectAccount(email string, displayName string) (string, error) {
+	billAcc, err := stripeAccount.New(&stripe.AccountParams{
+		Individual: &stripe.PersonParams{
+			Email: stripe.String(email),
+		},
+		Email: stripe.String(email),
+		Type:  stripe.String("express"),
+		BusinessProfile: &stripe.AccountBusinessProfileParams{
+			MCC:                stripe.String("8299"),
+			ProductDescription: stripe.String("Tutor for AstraTutor"),
+			SupportEmail:       stripe.String(email),
+			Name:               stripe.String("AstaTutor - " + displayName),
+		},
+		Capabilities: &stripe.AccountCapabilitiesParams{
+			Transfers: &stripe.AccountCapabilitiesTransfersParams{
+				Requested: stripe.Bool(true),
+			},
+			CardPayments: &stripe.AccountCapabilitiesCardPaymentsParams{
+				Requested: stripe.Bool(true),
+			},
+			SEPADebitPayments: &stripe.AccountCapabilitiesSEPADebitPaymentsParams{
+				Requested: stripe.Bool(true),
+			},
+		},
+		BusinessType: stripe.String("individual"),
+		Settings: &stripe.AccountSettingsParams{
+			Payouts: &stripe.AccountSettingsPayoutsParams{
+				Schedule: &stripe.PayoutScheduleParams{
+					Interval: stripe.String("manual"),
+				},
+				StatementDescriptor: stripe.String("AstraTutor"),
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return billAcc.ID, nil
+}
+
+func (s *StripePaymentProvider) GetConnectAccount(id string) (*ConnectAccount, error) {
+	billAcc, err := stripeAccount.GetByID(id, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	due := 0
+	if billAcc.Requirements != nil {
+		due = len(billAcc.Requirements.CurrentlyDue) + len(billAcc.Requirements.EventuallyDue)
+	}
+
+	return &ConnectAccount{
+		ID:              billAcc.ID,
+		ChargesEnabled:  billAcc.ChargesEnabled,
+		RequirementsDue: due,
+	}, nil
+}
+
+func (s *StripePaymentProvider) CreateOnboardingURL(accountID string, refreshURL string, returnURL string) (string, error) {
+	link, err := stripeAccountLink.New(&stripe.AccountLinkParams{
+		Account:    stripe.String(accountID),
+		RefreshURL: stripe.String(refreshURL),
+		ReturnURL:  stripe.String(returnURL),
+		Type:       stripe.String("account_onboarding"),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return link.URL, nil
+}
+
+func (s *StripePaymentProvider) CreateLoginURL(accountID string) (string, error) {
+	ll, err := stripeLoginLink.New(&stripe.LoginLinkParams{
+		Account: stripe.String(accountID),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return ll.URL, nil
+}
+
+func (s *StripePaymentProvider) CreateCardSetupSession(customerID string, successURL string, cancelURL string) (string, error) {
+	types := []*string{stripe.String("card")}
+	checkout, err := stripeCheckoutSession.New(&stripe.CheckoutSessionParams{
+		SuccessURL:         stripe.String(successURL),
+		CancelURL:          stripe.String(cancelURL),
+		PaymentMethodTypes: types,
+		Customer:           stripe.String(customerID),
+		Mode:               stripe.String(string(stripe.CheckoutSessionModeSetup)),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return checkout.ID, nil
+}
+
+func (s *StripePaymentProvider) ListCards(customerID string) ([]Card, error) {
+	res := stripePaymentMethod.List(&stripe.PaymentMethodListParams{
+		Customer: stripe.String(customerID),
+		Type:     stripe.String("card"),
+	})
+
+	ret := []Card{}
+	for res.Next() {
+		pm := res.PaymentMethod()
+		if pm.Card == nil {
+			continue
+		}
+
+		card := Card{
+			ID:       pm.ID,
+			Brand:    string(pm.Card.Brand),
+			Last4:    pm.Card.Last4,
+			ExpMonth: pm.Card.ExpMonth,
+			ExpYear:  pm.Card.ExpYear,
+		}
+		if pm.BillingDetails != nil {
+			card.Name = pm.BillingDetails.Name
+		}
+		ret = append(ret, card)
+	}
+
+	return ret, res.Err()
+}
+
+func (s *StripePaymentProvider) DetachCard(customerID string, cardID string) error {
+	pm, err := stripePaymentMethod.Get(cardID, nil)
+	if err != nil {
+		return err
+	}
+
+	if pm.Customer == nil || pm.Customer.ID != customerID {
+		return errors.New("this payment method is not associated with the specified account")
+	}
+
+	_, err = stripePaymentMethod.Detach(cardID, nil)
+	return err
+}
+
+func (s *StripePaymentProvider) CreatePaymentIntent(customerID string, amount int64) (*PaymentIntent, error) {
+	intent, err := stripePaymentIntent.New(&stripe.PaymentIntentParams{
+		Amount:   stripe.Int64(amount),
+		Currency: stripe.String(string(stripe.CurrencyEUR)),
+		PaymentMethodTypes: stripe.StringSlice([]string{
+			"card",
+		}),
+		SetupFutureUsage: stripe.String(string(stripe.PaymentIntentSetupFutureUsageOffSession)),
+		Customer:         stripe.String(customerID),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return paymentIntentFromStripe(intent), nil
+}
+
+func (s *StripePaymentProvider) GetPaymentIntent(id string) (*PaymentIntent, error) {
+	intent, err := stripePaymentIntent.Get(id, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return paymentIntentFromStripe(intent), nil
+}
+
+func (s *StripePaymentProvider) RefundPaymentIntent(id string) error {
+	params := &stripe.RefundParams{
+		PaymentIntent: stripe.String(id),
+	}
+	// A refund that is retried after its transaction rolled back must not refund the intent twice
+	params.SetIdempotencyKey("refund-" + id)
+
+	_, err := stripeRefund.New(params)
+	return err
+}
+
+func (s *StripePaymentProvider) CreateTransfer(destinationID string, amount int64) error {
+	_, err := stripeTransfer.New(&stripe.TransferParams{
+		Amount:      stripe.Int64(amount),
+		Currency:    stripe.String(string(stripe.CurrencyEUR)),
+		Destination: stripe.String(destinationID),
+	})
+	return err
+}
+
+func (s *StripePaymentProvider) CreatePayout(accountID string, amount int64) error {
+	params := &stripe.PayoutParams{
+		Amount:   stripe.Int64(amount),
+		Currency: stripe.String(string(stripe.CurrencyEUR)),
+	}
+	params.SetStripeAccount(accountID)
+
+	_, err := stripePayout.New(params)
+	return err
+}
+
+// paymentIntentFromStripe maps a Stripe payment intent onto the provider independent type
+func paymentIntentFromStripe(intent *stripe.PaymentIntent) *PaymentIntent {
+	status := PaymentIntentPending
+
+	switch intent.Status {
+	case stripe.PaymentIntentStatusSucceeded:
+		status = PaymentIntentSucceeded
+		if intent.Charges != nil {
+			for _, charge := range intent.Charges.Data {
+				if charge.Refunded {
+					status = PaymentIntentRefunded
+				}
+			}
+		}
+	case stripe.PaymentIntentStatusCanceled:
+		status = PaymentIntentFailed
+	case stripe.PaymentIntentStatusRequiresPaymentMethod:
+		if intent.LastPaymentError != nil {
+			status = PaymentIntentFailed
+		}
+	}
+
+	return &PaymentIntent{
+		ID:           intent.ID,
+		ClientSecret: intent.ClientSecret,
+		Amount:       intent.Amount,
+		Status:       status,
+	}
+}
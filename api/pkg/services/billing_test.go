@@ -0,0 +1,132 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func useFakePayments(t *testing.T) *FakePaymentProvider {
+	fake := NewFakePaymentProvider()
+	previous := payments
+	SetPayments(fake)
+	t.Cleanup(func() { SetPayments(previous) })
+	return fake
+}
+
+func TestLessonPaymentLifecycle(t *testing.T) {
+	fake := useFakePayments(t)
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	customer, err := payments.CreateCustomer("Student", "student@grinds.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	intent, err := payments.CreatePaymentIntent(customer, 2500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lesson := &Lesson{PaymentIntentID: intent.ID, PriceAmount: 2500}
+
+	// Each step changes the payment intent, then the lesson is updated the way RefreshPaidStatus would
+	steps := []struct {
+		name     string
+		change   func() error
+		status   PaymentIntentStatus
+		paid     bool
+		refunded bool
+	}{
+		{"created", func() error { return nil }, PaymentIntentPending, false, false},
+		{"payment failed", func() error { return fake.SimulatePaymentFailed(intent.ID) }, PaymentIntentFailed, false, false},
+		{"paid after failing", func() error { return fake.SimulatePaymentSucceeded(intent.ID) }, PaymentIntentSucceeded, true, false},
+		{"refunded", func() error { return payments.RefundPaymentIntent(intent.ID) }, PaymentIntentRefunded, true, true},
+	}
+
+	for _, step := range steps {
+		if err := step.change(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+
+		got, err := payments.GetPaymentIntent(intent.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != step.status {
+			t.Fatalf("%s: intent is %s, want %s", step.name, got.Status, step.status)
+		}
+
+		if update := lesson.paymentUpdate(got.Status, now); update != nil {
+			if update.Paid {
+				if update.DatePaid == nil || !update.DatePaid.Equal(now) {
+					t.Errorf("%s: lesson paid at %v, want %s", step.name, update.DatePaid, now)
+				}
+				lesson.Paid, lesson.DatePaid = true, update.DatePaid
+			}
+			lesson.Refunded = lesson.Refunded || update.Refunded
+		}
+
+		if lesson.Paid != step.paid || lesson.Refunded != step.refunded {
+			t.Errorf("%s: lesson paid %v refunded %v, want paid %v refunded %v",
+				step.name, lesson.Paid, lesson.Refunded, step.paid, step.refunded)
+		}
+	}
+
+	if err := payments.RefundPaymentIntent(intent.ID); err == nil {
+		t.Error("a payment was refunded twice")
+	}
+	if update := lesson.paymentUpdate(PaymentIntentRefunded, now); update != nil {
+		t.Errorf("a refunded lesson was updated again: %+v", update)
+	}
+}
+
+func TestLessonPaymentRefundBeforePaid(t *testing.T) {
+	fake := useFakePayments(t)
+
+	customer, err := payments.CreateCustomer("Student", "student@grinds.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	intent, err := payments.CreatePaymentIntent(customer, 2500)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := payments.RefundPaymentIntent(intent.ID); err == nil {
+		t.Error("a pending payment was refunded")
+	}
+	if err := fake.SimulatePaymentFailed(intent.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := payments.RefundPaymentIntent(intent.ID); err == nil {
+		t.Error("a failed payment was refunded")
+	}
+}
+
+func TestFakePaymentProviderCards(t *testing.T) {
+	useFakePayments(t)
+
+	customer, err := payments.CreateCustomer("Student", "student@grinds.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = payments.CreateCardSetupSession(customer, "/success", "/cancel"); err != nil {
+		t.Fatal(err)
+	}
+
+	cards, err := payments.ListCards(customer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 1 || cards[0].Last4 != "4242" || cards[0].Brand != "visa" {
+		t.Fatalf("cards = %+v, want the saved test card", cards)
+	}
+
+	if err = payments.DetachCard("cus_someone_else", cards[0].ID); err == nil {
+		t.Error("a card was detached from a customer it doesn't belong to")
+	}
+	if err = payments.DetachCard(customer, cards[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if cards, _ = payments.ListCards(customer); len(cards) != 0 {
+		t.Errorf("cards = %+v after detaching the only card", cards)
+	}
+}
@@ -0,0 +1,21 @@
+package services
+
+import "time"
+
+// Clock tells the current time, it can be replaced so behaviour that depends on the time can be tested
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+var clock Clock = systemClock{}
+
+// SetClock changes the clock used by the services
+func SetClock(c Clock) {
+	clock = c
+}
@@ -0,0 +1,256 @@
+package services
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/cs3305-team-4/api/pkg/database"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ConversationError types.
+type ConversationError string
+
+func (e ConversationError) Error() string {
+	return string(e)
+}
+
+const (
+	ConversationErrorSelf           ConversationError = "You can not start a conversation with yourself."
+	ConversationErrorNotParticipant ConversationError = "You are not a participant in this conversation."
+	ConversationErrorEmptyMessage   ConversationError = "Messages can not be empty."
+)
+
+// Conversation is a thread of messages between two accounts.
+// AccountOneID is always the lower of the two account IDs so there is only one conversation per pair of accounts.
+type Conversation struct {
+	database.Model
+	AccountOneID uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_conversation_accounts"`
+	AccountOne   Account   `gorm:"foreignKey:AccountOneID"`
+	AccountTwoID uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_conversation_accounts"`
+	AccountTwo   Account   `gorm:"foreignKey:AccountTwoID"`
+
+	// LastMessageAt is used to order conversations, most recent first
+	LastMessageAt *time.Time
+}
+
+// Message is a single message sent in a conversation
+type Message struct {
+	database.Model
+	ConversationID uuid.UUID `gorm:"type:uuid;index"`
+	SenderID       uuid.UUID `gorm:"type:uuid"`
+	Body           string    `gorm:"not null;"`
+
+	// ReadAt is when the recipient read the message, nil if unread
+	ReadAt *time.Time
+}
+
+// ConversationSummary is a conversation along with the number of messages unread by an account
+type ConversationSummary struct {
+	Conversation
+	UnreadCount int64
+}
+
+// IsParticipant returns true if the account is one of the two accounts in the conversation
+func (c *Conversation) IsParticipant(id uuid.UUID) bool {
+	return c.AccountOneID == id || c.AccountTwoID == id
+}
+
+// OtherParticipantID returns the ID of the account in the conversation that isn't the specified account
+func (c *Conversation) OtherParticipantID(id uuid.UUID) uuid.UUID {
+	if c.AccountOneID == id {
+		return c.AccountTwoID
+	}
+	return c.AccountOneID
+}
+
+// StartConversation sends the first message from the sender to the recipient, creating the conversation between them
+// if it doesn't exist. The conversation is only created along with its message.
+// Suspended accounts can't message anyone, tutors included, as they are refused at authentication.
+func StartConversation(sender *Account, recipientID uuid.UUID, body string) (*Conversation, error) {
+	if sender.ID == recipientID {
+		return nil, ConversationErrorSelf
+	}
+
+	if strings.TrimSpace(body) == "" {
+		return nil, ConversationErrorEmptyMessage
+	}
+
+	db, err := database.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	one, two := sender.ID, recipientID
+	if strings.Compare(one.String(), two.String()) > 0 {
+		one, two = two, one
+	}
+
+	conversation := &Conversation{}
+	return conversation, db.Transaction(func(tx *gorm.DB) error {
+		if _, err := ReadAccountByID(recipientID, tx); err != nil {
+			return err
+		}
+
+		err := tx.Where(&Conversation{
+			AccountOneID: one,
+			AccountTwoID: two,
+		}).FirstOrCreate(conversation).Error
+		if err != nil {
+			return err
+		}
+
+		_, err = conversation.createMessage(tx, sender, body)
+		return err
+	})
+}
+
+// ReadConversationByID returns a conversation by its id
+func ReadConversationByID(id uuid.UUID) (*Conversation, error) {
+	db, err := database.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	conversation := &Conversation{}
+	return conversation, db.First(conversation, id).Error
+}
+
+// ReadConversationsByAccountID returns every conversation the account is in, most recently active first
+func ReadConversationsByAccountID(id uuid.UUID) ([]ConversationSummary, error) {
+	db, err := database.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	var conversations []Conversation
+	err = db.Where("account_one_id = ? OR account_two_id = ?", id, id).
+		Order("last_message_at desc nulls last").
+		Find(&conversations).Error
+	if err != nil {
+		return nil, err
+	}
+
+	type unreadRow struct {
+		ConversationID uuid.UUID
+		Count          int64
+	}
+	var rows []unreadRow
+	err = db.Model(&Message{}).
+		Select("messages.conversation_id, count(*) as count").
+		Joins("JOIN conversations ON conversations.id = messages.conversation_id").
+		Where("(conversations.account_one_id = ? OR conversations.account_two_id = ?) AND messages.sender_id <> ? AND messages.read_at IS NULL", id, id, id).
+		Group("messages.conversation_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	unread := map[uuid.UUID]int64{}
+	for _, row := range rows {
+		unread[row.ConversationID] = row.Count
+	}
+
+	summaries := []ConversationSummary{}
+	for _, c := range conversations {
+		summaries = append(summaries, ConversationSummary{
+			Conversation: c,
+			UnreadCount:  unread[c.ID],
+		})
+	}
+
+	return summaries, nil
+}
+
+// UnreadMessageCount returns the number of messages sent to the account that it hasn't read
+func UnreadMessageCount(id uuid.UUID) (int64, error) {
+	db, err := database.Open()
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	return count, db.Model(&Message{}).
+		Joins("JOIN conversations ON conversations.id = messages.conversation_id").
+		Where("(conversations.account_one_id = ? OR conversations.account_two_id = ?) AND messages.sender_id <> ? AND messages.read_at IS NULL", id, id, id).
+		Count(&count).Error
+}
+
+// SendMessage sends a message from one of the participants of the conversation to the other
+func (c *Conversation) SendMessage(sender *Account, body string) (*Message, error) {
+	if !c.IsParticipant(sender.ID) {
+		return nil, ConversationErrorNotParticipant
+	}
+
+	if strings.TrimSpace(body) == "" {
+		return nil, ConversationErrorEmptyMessage
+	}
+
+	db, err := database.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	var message *Message
+	return message, db.Transaction(func(tx *gorm.DB) error {
+		message, err = c.createMessage(tx, sender, body)
+		return err
+	})
+}
+
+// createMessage stores a message from the sender in the conversation and moves the conversation to the top
+func (c *Conversation) createMessage(tx *gorm.DB, sender *Account, body string) (*Message, error) {
+	message := &Message{
+		ConversationID: c.ID,
+		SenderID:       sender.ID,
+		Body:           body,
+	}
+	if err := tx.Create(message).Error; err != nil {
+		return nil, err
+	}
+
+	return message, tx.Model(c).Update("last_message_at", message.CreatedAt).Error
+}
+
+// ReadMessagesPaginated returns the messages of the conversation newest first, along with the total number of pages
+func (c *Conversation) ReadMessagesPaginated(pageSize int, page int) ([]Message, int, error) {
+	db, err := database.Open()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int64
+	err = db.Model(&Message{}).Where(&Message{ConversationID: c.ID}).Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var messages []Message
+	err = db.Where(&Message{ConversationID: c.ID}).
+		Order("created_at desc").
+		Scopes(Paginate(pageSize, page)).
+		Find(&messages).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return messages, int(math.Ceil(float64(total) / float64(pageSize))), nil
+}
+
+// MarkRead marks every message sent to the reader in the conversation as read
+func (c *Conversation) MarkRead(reader *Account) error {
+	if !c.IsParticipant(reader.ID) {
+		return ConversationErrorNotParticipant
+	}
+
+	db, err := database.Open()
+	if err != nil {
+		return err
+	}
+
+	return db.Model(&Message{}).
+		Where("conversation_id = ? AND sender_id <> ? AND read_at IS NULL", c.ID, reader.ID).
+		Update("read_at", time.Now()).Error
+}
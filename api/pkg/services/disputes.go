@@ -0,0 +1,197 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/cs3305-team-4/api/pkg/database"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DisputeError types.
+type DisputeError string
+
+func (e DisputeError) Error() string {
+	return string(e)
+}
+
+const (
+	DisputeErrorNotDisputable DisputeError = "Only completed lessons and no-shows that haven't been refunded can be disputed."
+	DisputeErrorPaidOut       DisputeError = "The tutor has already been paid for this lesson."
+	DisputeErrorAlreadyOpen   DisputeError = "This lesson already has an open dispute."
+	DisputeErrorResolved      DisputeError = "This dispute has already been resolved."
+)
+
+type DisputeStatus string
+
+const (
+	// The dispute is waiting on an admin, the tutor can't be paid for the lesson until it is resolved
+	DisputeOpen DisputeStatus = "open"
+
+	// The dispute was resolved by refunding the student
+	DisputeRefunded DisputeStatus = "refunded"
+
+	// The dispute was resolved by releasing the payment to the tutor
+	DisputeReleased DisputeStatus = "released"
+)
+
+// Dispute is raised by a participant that disagrees with how a lesson ended
+type Dispute struct {
+	database.Model
+	LessonID uuid.UUID `gorm:"type:uuid;index"`
+
+	RaisedByID uuid.UUID `gorm:"type:uuid"`
+	Evidence   string
+
+	Status DisputeStatus `gorm:"index;not null;"`
+
+	ResolvedByID *uuid.UUID `gorm:"type:uuid"`
+	ResolvedAt   *time.Time
+	Resolution   string
+}
+
+// notDisputed leaves out lessons with an open dispute, their payout is held until the dispute is resolved
+func notDisputed(db *gorm.DB) *gorm.DB {
+	return db.Where(
+		"NOT EXISTS (SELECT 1 FROM disputes WHERE disputes.lesson_id = lessons.id AND disputes.status = ? AND disputes.deleted_at IS NULL)",
+		DisputeOpen,
+	)
+}
+
+// RaiseDispute opens a dispute about how the lesson ended, which holds the payout of the tutor for it
+func RaiseDispute(lessonID uuid.UUID, raiser *Account, evidence string) (*Dispute, error) {
+	db, err := database.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	dispute := &Dispute{
+		LessonID:   lessonID,
+		RaisedByID: raiser.ID,
+		Evidence:   evidence,
+		Status:     DisputeOpen,
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// lock the lesson so it can't be paid out while the dispute is being raised
+		lesson := &Lesson{}
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(lesson, lessonID).Error
+		if err != nil {
+			return err
+		}
+
+		if !PartyParticipant.includes(lesson, raiser) {
+			return LessonErrorActorNotAllowed
+		}
+
+		switch lesson.RequestStage {
+		case Completed, NoShowStudent, NoShowTutor:
+		default:
+			return DisputeErrorNotDisputable
+		}
+
+		if lesson.Refunded {
+			return DisputeErrorNotDisputable
+		}
+
+		if lesson.PaidOut {
+			return DisputeErrorPaidOut
+		}
+
+		var open int64
+		err = tx.Model(&Dispute{}).Where(&Dispute{LessonID: lessonID, Status: DisputeOpen}).Count(&open).Error
+		if err != nil {
+			return err
+		}
+		if open > 0 {
+			return DisputeErrorAlreadyOpen
+		}
+
+		return tx.Create(dispute).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return dispute, nil
+}
+
+// ReadDisputesByLessonID returns every dispute raised about the lesson, oldest first
+func ReadDisputesByLessonID(lessonID uuid.UUID) ([]Dispute, error) {
+	db, err := database.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	disputes := []Dispute{}
+	return disputes, db.Where(&Dispute{LessonID: lessonID}).Order("created_at").Find(&disputes).Error
+}
+
+// ReadDisputesByStatus returns every dispute with the status, oldest first
+func ReadDisputesByStatus(status DisputeStatus) ([]Dispute, error) {
+	db, err := database.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	disputes := []Dispute{}
+	return disputes, db.Where(&Dispute{Status: status}).Order("created_at").Find(&disputes).Error
+}
+
+// ResolveDispute closes an open dispute, either refunding the student or releasing the payment to the tutor
+func ResolveDispute(ctx context.Context, id uuid.UUID, admin *Account, refund bool, resolution string) error {
+	db, err := database.Open()
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		dispute := &Dispute{}
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(dispute, id).Error
+		if err != nil {
+			return err
+		}
+
+		if dispute.Status != DisputeOpen {
+			return DisputeErrorResolved
+		}
+
+		lesson := &Lesson{}
+		if err = tx.First(lesson, dispute.LessonID).Error; err != nil {
+			return err
+		}
+
+		status := DisputeReleased
+		if refund {
+			status = DisputeRefunded
+
+			if lesson.Paid {
+				if err = lesson.refund(ctx, tx); err != nil {
+					return err
+				}
+			}
+		} else if lesson.RequestStage == NoShowTutor {
+			// The tutor did show up, so they are paid for the lesson instead of the student being refunded
+			if err = transitionLesson(ctx, tx, lesson.ID, nil, Completed, &LessonChange{Reason: resolution}); err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		err = tx.Model(dispute).Updates(&Dispute{
+			Status:       status,
+			ResolvedByID: &admin.ID,
+			ResolvedAt:   &now,
+			Resolution:   resolution,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		return recordAuditEvent(ctx, tx, AuditLessonDisputeResolved, &admin.ID, AuditTargetLesson, lesson.ID,
+			map[string]interface{}{"dispute_id": dispute.ID, "status": DisputeOpen},
+			map[string]interface{}{"dispute_id": dispute.ID, "status": status, "resolution": resolution},
+		)
+	})
+}
@@ -0,0 +1,213 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cs3305-team-4/api/pkg/database"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// EmailVerificationError types.
+type EmailVerificationError string
+
+func (e EmailVerificationError) Error() string {
+	return string(e)
+}
+
+const (
+	EmailVerificationErrorInvalid EmailVerificationError = "This verification link is invalid."
+	EmailVerificationErrorExpired EmailVerificationError = "This verification link has expired."
+	EmailVerificationErrorUsed    EmailVerificationError = "This verification link has already been used."
+)
+
+// EmailVerification is issued when an account needs to prove it owns its email address.
+// The token sent to the account is the ID of the verification signed with auth.email_verification.secret.
+type EmailVerification struct {
+	database.Model
+	AccountID uuid.UUID `gorm:"type:uuid;index"`
+	Email     string    `gorm:"not null;"`
+	ExpiresAt time.Time
+
+	// UsedAt is set once the verification has been used or replaced by a newer one
+	UsedAt *time.Time
+}
+
+func (v *EmailVerification) signature() string {
+	mac := hmac.New(sha256.New, []byte(viper.GetString("auth.email_verification.secret")))
+	fmt.Fprintf(mac, "%s|%s|%s|%d", v.ID, v.AccountID, v.Email, v.ExpiresAt.Unix())
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Token returns the signed token that is sent to the account
+func (v *EmailVerification) Token() string {
+	return v.ID.String() + "." + v.signature()
+}
+
+// issueEmailVerification replaces any outstanding verifications of the account with a new one
+func issueEmailVerification(tx *gorm.DB, account *Account) (*EmailVerification, error) {
+	now := time.Now()
+
+	err := tx.Model(&EmailVerification{}).
+		Where("account_id = ? AND used_at IS NULL", account.ID).
+		Update("used_at", now).Error
+	if err != nil {
+		return nil, err
+	}
+
+	verification := &EmailVerification{
+		AccountID: account.ID,
+		Email:     account.Email,
+		ExpiresAt: now.Add(time.Duration(viper.GetInt64("auth.email_verification.ttl")) * time.Second),
+	}
+	return verification, tx.Create(verification).Error
+}
+
+func sendEmailVerification(verification *EmailVerification) error {
+	link := fmt.Sprintf("%s/verify-email?token=%s", viper.GetString("ui.base_url"), url.QueryEscape(verification.Token()))
+
+	return mailer.Send(&Mail{
+		To:      verification.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf(
+			"Please verify your email address by opening the following link:\n\n%s\n\nThis link expires at %s.",
+			link,
+			verification.ExpiresAt.UTC().Format(time.RFC1123),
+		),
+	})
+}
+
+// SendEmailVerification emails the account a new verification link, any previously sent links stop working
+func (a *Account) SendEmailVerification() error {
+	if a.EmailVerified {
+		return AccountErrorEmailAlreadyVerified
+	}
+
+	db, err := database.Open()
+	if err != nil {
+		return err
+	}
+
+	verification := &EmailVerification{}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		verification, err = issueEmailVerification(tx, a)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return sendEmailVerification(verification)
+}
+
+// UpdateAccountEmail changes the email of an account, marks it as unverified and emails a verification link to the new address
+func UpdateAccountEmail(ctx context.Context, id uuid.UUID, email string) (*Account, error) {
+	db, err := database.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	account := &Account{}
+	verification := &EmailVerification{}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		account, err = ReadAccountByID(id, tx)
+		if err != nil {
+			return err
+		}
+
+		before := map[string]interface{}{"email": account.Email, "email_verified": account.EmailVerified}
+		err = tx.Model(account).Updates(map[string]interface{}{
+			"email":          email,
+			"email_verified": false,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		err = recordAuditEvent(ctx, tx, AuditAccountEmail, nil, AuditTargetAccount, id, before, map[string]interface{}{
+			"email":          email,
+			"email_verified": false,
+		})
+		if err != nil {
+			return err
+		}
+
+		verification, err = issueEmailVerification(tx, account)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The email has been changed either way, the account can ask for the link to be resent
+	if err = sendEmailVerification(verification); err != nil {
+		log.WithError(err).Errorf("could not send email verification to account %s", account.ID)
+	}
+
+	return account, nil
+}
+
+// VerifyEmail marks the account a verification token was issued to as verified, each token can only be used once
+func VerifyEmail(token string) (*Account, error) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return nil, EmailVerificationErrorInvalid
+	}
+
+	id, err := uuid.Parse(parts[0])
+	if err != nil {
+		return nil, EmailVerificationErrorInvalid
+	}
+
+	db, err := database.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	account := &Account{}
+	return account, db.Transaction(func(tx *gorm.DB) error {
+		verification := &EmailVerification{}
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(verification, id).Error
+		if err != nil {
+			return EmailVerificationErrorInvalid
+		}
+
+		if !hmac.Equal([]byte(verification.signature()), []byte(parts[1])) {
+			return EmailVerificationErrorInvalid
+		}
+
+		if verification.UsedAt != nil {
+			return EmailVerificationErrorUsed
+		}
+
+		now := time.Now()
+		if now.After(verification.ExpiresAt) {
+			return EmailVerificationErrorExpired
+		}
+
+		if err = tx.First(account, verification.AccountID).Error; err != nil {
+			return err
+		}
+
+		// The account changed its email since the link was sent
+		if account.Email != verification.Email {
+			return EmailVerificationErrorInvalid
+		}
+
+		if err = tx.Model(verification).Update("used_at", now).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(account).Update("email_verified", true).Error
+	})
+}
@@ -0,0 +1,718 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cs3305-team-4/api/pkg/database"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GroupSessionError types.
+type GroupSessionError string
+
+func (e GroupSessionError) Error() string {
+	return string(e)
+}
+
+const (
+	GroupSessionErrorInvalidCapacity GroupSessionError = "A group session needs room for at least 2 students and a minimum enrolment between 1 and its capacity."
+	GroupSessionErrorInvalidDeadline GroupSessionError = "The enrolment deadline must be in the future and no later than the start of the session."
+	GroupSessionErrorNotEnrolling    GroupSessionError = "This group session isn't taking enrolments."
+	GroupSessionErrorFull            GroupSessionError = "This group session is full."
+	GroupSessionErrorAlreadyEnrolled GroupSessionError = "You are already enrolled in this group session."
+	GroupSessionErrorNotEnrolled     GroupSessionError = "You are not enrolled in this group session."
+	GroupSessionErrorNotCancellable  GroupSessionError = "Only group sessions that haven't started can be cancelled."
+)
+
+type GroupSessionStage string
+
+const (
+	// The session is taking enrolments but not enough students have paid for it to go ahead yet
+	GroupSessionOpen GroupSessionStage = "open"
+
+	// Enough students have paid for the session to go ahead, it keeps taking enrolments until it is full or starts
+	GroupSessionConfirmed GroupSessionStage = "confirmed"
+
+	// The session was cancelled by the tutor, or didn't reach its minimum enrolment by the deadline
+	GroupSessionCancelled GroupSessionStage = "cancelled"
+
+	// The session has ended
+	GroupSessionCompleted GroupSessionStage = "completed"
+)
+
+// activeGroupSessionStages are the stages of group sessions that still take up their time
+var activeGroupSessionStages = []GroupSessionStage{GroupSessionOpen, GroupSessionConfirmed}
+
+// GroupSession is a lesson a tutor runs for several students at once, each paying for their own seat
+type GroupSession struct {
+	database.Model
+
+	TutorID uuid.UUID `gorm:"type:uuid;index"`
+
+	SubjectTaught   SubjectTaught `gorm:"foreignKey:SubjectTaughtID"`
+	SubjectTaughtID uuid.UUID
+
+	// SubjectID is kept on the session so open sessions can be listed by subject
+	SubjectID uuid.UUID `gorm:"type:uuid;index"`
+
+	Title       string
+	Description string
+
+	// Time of the session
+	StartTime time.Time
+	EndTime   time.Time
+
+	// Capacity is the most students that can enrol
+	Capacity int
+
+	// MinEnrolment is how many students must have paid by EnrolmentDeadline, or the session is cancelled and refunded
+	MinEnrolment      int
+	EnrolmentDeadline time.Time
+
+	// SeatPrice is what each student pays
+	SeatPrice int64
+
+	Stage GroupSessionStage `gorm:"index"`
+
+	// StageDetail contains a string related to the current stage, like why it was cancelled
+	StageDetail string
+
+	Seats []GroupSeat `gorm:"foreignKey:GroupSessionID"`
+}
+
+// GroupSeat is a student's place in a group session, each seat is paid for and refunded on its own
+type GroupSeat struct {
+	database.Model
+
+	GroupSession   GroupSession `gorm:"foreignKey:GroupSessionID"`
+	GroupSessionID uuid.UUID    `gorm:"type:uuid;index"`
+
+	StudentID uuid.UUID `gorm:"type:uuid;index"`
+
+	PaymentIntentID string `gorm:"index"`
+
+	// PriceAmount is what the student pays for the seat, PayoutAmount is what the tutor earns from it
+	PriceAmount  int64
+	PayoutAmount int64
+
+	Paid     bool
+	DatePaid *time.Time
+	Refunded bool
+
+	PaidOut     bool
+	DatePaidOut *time.Time
+
+	// Withdrawn is true if the student gave up their seat, it no longer counts towards the capacity
+	Withdrawn bool
+}
+
+// Duration returns how long the session is
+func (g *GroupSession) Duration() time.Duration {
+	return g.EndTime.Sub(g.StartTime).Round(time.Minute)
+}
+
+// TakenSeats returns how many seats are held by students that haven't left, Seats must be loaded
+func (g *GroupSession) TakenSeats() int {
+	taken := 0
+	for _, seat := range g.Seats {
+		if !seat.Withdrawn {
+			taken++
+		}
+	}
+	return taken
+}
+
+// PaidSeats returns how many seats are paid for by students that haven't left, Seats must be loaded
+func (g *GroupSession) PaidSeats() int {
+	paid := 0
+	for _, seat := range g.Seats {
+		if seat.Paid && !seat.Refunded && !seat.Withdrawn {
+			paid++
+		}
+	}
+	return paid
+}
+
+// SeatOf returns the seat the student holds in the session, or nil if they have none. Seats must be loaded
+func (g *GroupSession) SeatOf(studentID uuid.UUID) *GroupSeat {
+	for i := range g.Seats {
+		if g.Seats[i].StudentID == studentID && !g.Seats[i].Withdrawn {
+			return &g.Seats[i]
+		}
+	}
+	return nil
+}
+
+// groupSessionAtTime returns true if the account runs or has a seat in an active group session at that time
+func groupSessionAtTime(db *gorm.DB, acc *Account, startTime time.Time, endTime time.Time) (bool, error) {
+	var count int64
+	err := db.Model(&GroupSession{}).
+		Where("end_time > ? AND start_time < ? AND stage IN ?", startTime, endTime, activeGroupSessionStages).
+		Where(db.Where("tutor_id = ?", acc.ID).
+			Or("id IN (?)", db.Model(&GroupSeat{}).Select("group_session_id").Where("student_id = ? AND withdrawn = ?", acc.ID, false)),
+		).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// CreateGroupSession schedules a group session of the subject, it is open for enrolments straight away.
+// The seat price defaults to the price of the subject for the duration.
+func CreateGroupSession(tutor *Account, subjectTaught *SubjectTaught, session *GroupSession, duration int) error {
+	if !tutor.EmailVerified {
+		return AccountErrorEmailNotVerified
+	}
+
+	if tutor.ID != subjectTaught.TutorID {
+		return LessonErrorActorNotAllowed
+	}
+
+	if !session.StartTime.After(clock.Now()) {
+		return fmt.Errorf("can't create a group session in the past")
+	}
+
+	if duration == 0 {
+		duration = DefaultLessonDuration
+	}
+
+	if !subjectTaught.Offers(duration) {
+		return SubjectTaughtErrorDurationNotOffered
+	}
+
+	if session.Capacity < 2 || session.MinEnrolment < 1 || session.MinEnrolment > session.Capacity {
+		return GroupSessionErrorInvalidCapacity
+	}
+
+	if session.EnrolmentDeadline.IsZero() {
+		session.EnrolmentDeadline = session.StartTime
+	}
+	if !session.EnrolmentDeadline.After(clock.Now()) || session.EnrolmentDeadline.After(session.StartTime) {
+		return GroupSessionErrorInvalidDeadline
+	}
+
+	if session.SeatPrice == 0 {
+		session.SeatPrice = subjectTaught.PriceFor(duration)
+	}
+
+	if err := checkTutorNotOnVacation(tutor.ID); err != nil {
+		return err
+	}
+
+	session.TutorID = tutor.ID
+	session.SubjectTaughtID = subjectTaught.ID
+	session.SubjectID = subjectTaught.SubjectID
+	session.StartTime = session.StartTime.UTC()
+	session.EndTime = session.StartTime.Add(time.Minute * time.Duration(duration))
+	session.EnrolmentDeadline = session.EnrolmentDeadline.UTC()
+	session.Stage = GroupSessionOpen
+
+	db, err := database.Open()
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`set transaction isolation level repeatable read`).Error; err != nil {
+			return err
+		}
+
+		if err := checkTutorAvailable(tutor.ID, session.StartTime, session.EndTime); err != nil {
+			return err
+		}
+
+		lat, err := lessonAtTime(tx, tutor, session.StartTime, session.EndTime)
+		if err != nil {
+			return err
+		}
+		if !lat {
+			lat, err = groupSessionAtTime(tx, tutor, session.StartTime, session.EndTime)
+			if err != nil {
+				return err
+			}
+		}
+		if lat {
+			return AvailabilityErrorTutorUnavailable
+		}
+
+		return tx.Omit("SubjectTaught", "Seats").Create(session).Error
+	})
+}
+
+// ReadGroupSessionByID returns the group session with its seats
+func ReadGroupSessionByID(id uuid.UUID) (*GroupSession, error) {
+	db, err := database.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	session := &GroupSession{}
+	return session, db.Preload("Seats").Preload("SubjectTaught.Subject").First(session, id).Error
+}
+
+// ReadOpenGroupSessionsBySubjectID returns the group sessions of the subject that students can still enrol in,
+// soonest first
+func ReadOpenGroupSessionsBySubjectID(subjectID uuid.UUID) ([]GroupSession, error) {
+	db, err := database.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []GroupSession
+	err = db.Preload("Seats").Preload("SubjectTaught.Subject").
+		Where("subject_id = ? AND stage IN ? AND start_time > ?", subjectID, activeGroupSessionStages, clock.Now()).
+		Order("start_time").
+		Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	open := []GroupSession{}
+	for _, session := range sessions {
+		if session.enrolling(clock.Now()) && session.TakenSeats() < session.Capacity {
+			open = append(open, session)
+		}
+	}
+	return open, nil
+}
+
+// enrolling returns true if students can enrol in the session at now, sessions that haven't reached their minimum
+// enrolment stop taking enrolments at the deadline
+func (g *GroupSession) enrolling(now time.Time) bool {
+	switch g.Stage {
+	case GroupSessionOpen:
+		return now.Before(g.EnrolmentDeadline)
+	case GroupSessionConfirmed:
+		return now.Before(g.StartTime)
+	}
+	return false
+}
+
+// Enrol gives the student a seat in the group session, which they then pay for with the seat's payment intent.
+// The seat is held for group_sessions.seat_hold seconds, if it isn't paid for by then it is released.
+func (g *GroupSession) Enrol(student *Account) (*GroupSeat, error) {
+	if !student.EmailVerified {
+		return nil, AccountErrorEmailNotVerified
+	}
+
+	if student.Type != Student {
+		return nil, LessonErrorActorNotAllowed
+	}
+
+	db, err := database.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	var seat *GroupSeat
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// lock the session so two students can't take its last seat
+		session := &GroupSession{}
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(session, g.ID).Error
+		if err != nil {
+			return err
+		}
+		if err = tx.Where(&GroupSeat{GroupSessionID: g.ID}).Find(&session.Seats).Error; err != nil {
+			return err
+		}
+
+		if !session.enrolling(clock.Now()) {
+			return GroupSessionErrorNotEnrolling
+		}
+
+		if session.SeatOf(student.ID) != nil {
+			return GroupSessionErrorAlreadyEnrolled
+		}
+
+		if session.TakenSeats() >= session.Capacity {
+			return GroupSessionErrorFull
+		}
+
+		lat, err := lessonAtTime(tx, student, session.StartTime, session.EndTime)
+		if err != nil {
+			return err
+		}
+		if !lat {
+			lat, err = groupSessionAtTime(tx, student, session.StartTime, session.EndTime)
+			if err != nil {
+				return err
+			}
+		}
+		if lat {
+			return fmt.Errorf("cannot enrol: the student has a lesson at that time")
+		}
+
+		seat = &GroupSeat{
+			GroupSessionID: session.ID,
+			StudentID:      student.ID,
+			PriceAmount:    session.SeatPrice,
+			PayoutAmount:   ((session.SeatPrice) / 100) * (100 - viper.GetInt64("billing.profit_margin")),
+		}
+		return tx.Omit("GroupSession").Create(seat).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The intent is made once the seat is taken, so the session isn't locked while Stripe is called
+	intent, err := payments.CreatePaymentIntent(student.StripeID, seat.PriceAmount)
+	if err != nil {
+		if err := db.Model(seat).Update("withdrawn", true).Error; err != nil {
+			log.WithError(err).WithField("seat", seat.ID).Error("Could not release group seat")
+		}
+		return nil, err
+	}
+
+	seat.PaymentIntentID = intent.ID
+	if err = db.Model(seat).Update("payment_intent_id", intent.ID).Error; err != nil {
+		return nil, err
+	}
+
+	return seat, nil
+}
+
+// Leave gives up the student's seat in the group session, they are refunded if they already paid for it. A confirmed
+// session left with fewer paid seats than its minimum enrolment is opened again, and is cancelled if it doesn't reach
+// the minimum by its enrolment deadline.
+func (g *GroupSession) Leave(ctx context.Context, student *Account) error {
+	db, err := database.Open()
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		session := &GroupSession{}
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(session, g.ID).Error
+		if err != nil {
+			return err
+		}
+		if err = tx.Where(&GroupSeat{GroupSessionID: g.ID}).Find(&session.Seats).Error; err != nil {
+			return err
+		}
+
+		seat := session.SeatOf(student.ID)
+		if seat == nil {
+			return GroupSessionErrorNotEnrolled
+		}
+
+		if !session.enrolling(clock.Now()) {
+			return GroupSessionErrorNotEnrolling
+		}
+
+		if err = tx.Model(seat).Update("withdrawn", true).Error; err != nil {
+			return err
+		}
+		seat.Withdrawn = true
+
+		// A confirmed session that drops below its minimum enrolment has to reach it again by the deadline
+		if session.Stage == GroupSessionConfirmed && session.PaidSeats() < session.MinEnrolment {
+			if err = tx.Model(session).Update("stage", GroupSessionOpen).Error; err != nil {
+				return err
+			}
+		}
+
+		if seat.Paid {
+			return seat.refund(ctx, tx)
+		}
+		return nil
+	})
+}
+
+// cancelGroupSession cancels the session, a nil actor is the system. Its paid seats are refunded by
+// refundGroupSeats once the cancellation is committed.
+func cancelGroupSession(ctx context.Context, tx *gorm.DB, session *GroupSession, actorID *uuid.UUID, reason string) error {
+	before := map[string]interface{}{"stage": session.Stage}
+
+	err := tx.Model(session).Updates(&GroupSession{
+		Stage:       GroupSessionCancelled,
+		StageDetail: reason,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	var paid int64
+	err = tx.Model(&GroupSeat{}).
+		Where("group_session_id = ? AND paid = ? AND refunded = ?", session.ID, true, false).
+		Count(&paid).Error
+	if err != nil {
+		return err
+	}
+
+	return recordAuditEvent(ctx, tx, AuditGroupSessionCancelled, actorID, AuditTargetGroupSession, session.ID,
+		before,
+		map[string]interface{}{"stage": GroupSessionCancelled, "reason": reason, "refunded_seats": paid},
+	)
+}
+
+// Cancel cancels the group session on behalf of its tutor and refunds every paid seat
+func (g *GroupSession) Cancel(ctx context.Context, tutor *Account, reason string) error {
+	if tutor.ID != g.TutorID {
+		return LessonErrorActorNotAllowed
+	}
+
+	db, err := database.Open()
+	if err != nil {
+		return err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		session := &GroupSession{}
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(session, g.ID).Error
+		if err != nil {
+			return err
+		}
+
+		if (session.Stage != GroupSessionOpen && session.Stage != GroupSessionConfirmed) || !clock.Now().Before(session.StartTime) {
+			return GroupSessionErrorNotCancellable
+		}
+
+		return cancelGroupSession(ctx, tx, session, &tutor.ID, reason)
+	})
+	if err != nil {
+		return err
+	}
+
+	return refundGroupSeats(ctx, db, &g.ID)
+}
+
+// refundDue reports whether the seat was paid for but the student gave it up or the session was cancelled, and
+// the payment still has to be given back
+func (s *GroupSeat) refundDue(session *GroupSession) bool {
+	return s.Paid && !s.Refunded && (s.Withdrawn || session.Stage == GroupSessionCancelled)
+}
+
+// refundGroupSeats refunds the seats of the session that are due a refund, or of every session if sessionID is nil.
+// Refunds call the payment provider, so each runs in its own transaction after whatever made the seat due one is
+// committed. A refund that fails is logged and retried by RefundGroupSeats.
+func refundGroupSeats(ctx context.Context, db *gorm.DB, sessionID *uuid.UUID) error {
+	query := db.Model(&GroupSeat{}).
+		Joins("JOIN group_sessions ON group_sessions.id = group_seats.group_session_id").
+		Where("group_seats.paid = ? AND group_seats.refunded = ?", true, false).
+		Where("(group_seats.withdrawn = ? OR group_sessions.stage = ?)", true, GroupSessionCancelled)
+	if sessionID != nil {
+		query = query.Where("group_seats.group_session_id = ?", *sessionID)
+	}
+
+	var ids []uuid.UUID
+	if err := query.Pluck("group_seats.id", &ids).Error; err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			// lock the seat so it can't be refunded twice at once
+			seat := &GroupSeat{}
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("GroupSession").First(seat, id).Error
+			if err != nil || !seat.refundDue(&seat.GroupSession) {
+				return err
+			}
+
+			return seat.refund(ctx, tx)
+		})
+		if err != nil {
+			log.WithError(err).WithField("seat", id).Error("Could not refund group seat")
+		}
+	}
+
+	return nil
+}
+
+// refund gives the student their money back for the seat, the seat is marked refunded in tx
+func (s *GroupSeat) refund(ctx context.Context, tx *gorm.DB) error {
+	if s.Refunded {
+		return nil
+	}
+
+	if err := payments.RefundPaymentIntent(s.PaymentIntentID); err != nil {
+		return err
+	}
+
+	if err := tx.Model(&GroupSeat{Model: s.Model}).Update("refunded", true).Error; err != nil {
+		return err
+	}
+
+	return recordAuditEvent(ctx, tx, AuditGroupSeatRefunded, nil, AuditTargetGroupSession, s.GroupSessionID,
+		map[string]interface{}{"seat_id": s.ID, "refunded": false},
+		map[string]interface{}{"seat_id": s.ID, "refunded": true, "payment_intent_id": s.PaymentIntentID, "amount": s.PriceAmount},
+	)
+}
+
+// GetPaymentIntentClientSecret returns the secret the student uses to pay for the seat
+func (s *GroupSeat) GetPaymentIntentClientSecret() (string, error) {
+	intent, err := payments.GetPaymentIntent(s.PaymentIntentID)
+	if err != nil {
+		return "", err
+	}
+
+	return intent.ClientSecret, err
+}
+
+// readGroupSeatByPaymentIntentID finds and locks the seat a payment intent was created for, returns nil if there is none
+func readGroupSeatByPaymentIntentID(tx *gorm.DB, paymentIntentID string) (*GroupSeat, error) {
+	var seat GroupSeat
+	res := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where(&GroupSeat{PaymentIntentID: paymentIntentID}).Limit(1).Find(&seat)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	return &seat, nil
+}
+
+// groupSeatPaymentSucceeded marks the seat paid for by the payment intent as paid, and confirms its session once
+// enough seats have been paid for
+func groupSeatPaymentSucceeded(ctx context.Context, tx *gorm.DB, paymentIntentID string) error {
+	seat, err := readGroupSeatByPaymentIntentID(tx, paymentIntentID)
+	if err != nil || seat == nil || seat.Paid {
+		return err
+	}
+
+	now := clock.Now()
+	err = tx.Model(seat).Updates(&GroupSeat{
+		Paid:     true,
+		DatePaid: &now,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	session := &GroupSession{}
+	err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(session, seat.GroupSessionID).Error
+	if err != nil {
+		return err
+	}
+
+	if seat.Withdrawn || session.Stage == GroupSessionCancelled || session.Stage == GroupSessionCompleted {
+		// The seat was released or the session ended while the student was paying, so they get their money back
+		return seat.refund(ctx, tx)
+	}
+
+	if session.Stage != GroupSessionOpen {
+		return nil
+	}
+
+	var paid int64
+	err = tx.Model(&GroupSeat{}).
+		Where("group_session_id = ? AND paid = ? AND refunded = ? AND withdrawn = ?", session.ID, true, false, false).
+		Count(&paid).Error
+	if err != nil {
+		return err
+	}
+
+	if int(paid) < session.MinEnrolment {
+		return nil
+	}
+
+	return tx.Model(session).Update("stage", GroupSessionConfirmed).Error
+}
+
+// groupSeatPaymentRefunded marks the seat paid for by the payment intent as refunded
+func groupSeatPaymentRefunded(tx *gorm.DB, paymentIntentID string) error {
+	seat, err := readGroupSeatByPaymentIntentID(tx, paymentIntentID)
+	if err != nil || seat == nil || seat.Refunded {
+		return err
+	}
+
+	return tx.Model(seat).Update("refunded", true).Error
+}
+
+// readPaidGroupSeatsByTutorID returns the paid, unrefunded seats of the completed group sessions of the tutor
+func readPaidGroupSeatsByTutorID(tx *gorm.DB, tutorID uuid.UUID) ([]GroupSeat, error) {
+	var seats []GroupSeat
+	return seats, tx.Preload("GroupSession").
+		Joins("JOIN group_sessions ON group_sessions.id = group_seats.group_session_id").
+		Where("group_sessions.tutor_id = ? AND group_sessions.stage = ?", tutorID, GroupSessionCompleted).
+		Where("group_seats.paid = ? AND group_seats.refunded = ?", true, false).
+		Find(&seats).Error
+}
+
+// CancelUnderEnrolledGroupSessions cancels and refunds the open group sessions that didn't have enough paid seats by
+// their enrolment deadline
+func CancelUnderEnrolledGroupSessions(ctx context.Context, now time.Time) error {
+	db, err := database.Open()
+	if err != nil {
+		return err
+	}
+
+	var ids []uuid.UUID
+	err = db.Model(&GroupSession{}).
+		Where("stage = ? AND enrolment_deadline <= ?", GroupSessionOpen, now).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		err = db.Transaction(func(tx *gorm.DB) error {
+			session := &GroupSession{}
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(session, id).Error
+			if err != nil || session.Stage != GroupSessionOpen {
+				return err
+			}
+
+			return cancelGroupSession(ctx, tx, session, nil, "the minimum enrolment wasn't reached by the enrolment deadline")
+		})
+		if err != nil {
+			log.WithError(err).WithField("group_session", id).Error("Could not cancel group session")
+			continue
+		}
+
+		if err = refundGroupSeats(ctx, db, &id); err != nil {
+			log.WithError(err).WithField("group_session", id).Error("Could not refund group session")
+		}
+	}
+
+	return nil
+}
+
+// RefundGroupSeats refunds paid seats that were given up or whose session was cancelled but weren't refunded then,
+// such as when the payment provider couldn't be reached
+func RefundGroupSeats(ctx context.Context, now time.Time) error {
+	db, err := database.Open()
+	if err != nil {
+		return err
+	}
+
+	return refundGroupSeats(ctx, db, nil)
+}
+
+// ReleaseUnpaidGroupSeats gives up the seats that weren't paid for within group_sessions.seat_hold seconds of being
+// taken, so they can be taken by other students
+func ReleaseUnpaidGroupSeats(ctx context.Context, now time.Time) error {
+	db, err := database.Open()
+	if err != nil {
+		return err
+	}
+
+	takenBefore := now.Add(-time.Duration(viper.GetInt64("group_sessions.seat_hold")) * time.Second)
+
+	return db.Model(&GroupSeat{}).
+		Where("paid = ? AND withdrawn = ? AND created_at <= ?", false, false, takenBefore).
+		Update("withdrawn", true).Error
+}
+
+// CompleteFinishedGroupSessions completes confirmed group sessions once lessons.auto_complete_after has passed since
+// they ended
+func CompleteFinishedGroupSessions(ctx context.Context, now time.Time) error {
+	db, err := database.Open()
+	if err != nil {
+		return err
+	}
+
+	endedBefore := now.Add(-time.Duration(viper.GetInt64("lessons.auto_complete_after")) * time.Second)
+
+	return db.Model(&GroupSession{}).
+		Where("stage = ? AND end_time <= ?", GroupSessionConfirmed, endedBefore).
+		Update("stage", GroupSessionCompleted).Error
+}
@@ -0,0 +1,42 @@
+package services
+
+import "testing"
+
+func TestGroupSeatRefundDue(t *testing.T) {
+	cases := []struct {
+		seat  GroupSeat
+		stage GroupSessionStage
+		due   bool
+	}{
+		{GroupSeat{Paid: true}, GroupSessionCancelled, true},
+		{GroupSeat{Paid: true, Withdrawn: true}, GroupSessionOpen, true},
+		{GroupSeat{Paid: true}, GroupSessionOpen, false},
+		{GroupSeat{Paid: true}, GroupSessionConfirmed, false},
+		{GroupSeat{Paid: true, Refunded: true}, GroupSessionCancelled, false},
+		{GroupSeat{Paid: true, Withdrawn: true, Refunded: true}, GroupSessionOpen, false},
+		{GroupSeat{Withdrawn: true}, GroupSessionCancelled, false},
+	}
+
+	for _, c := range cases {
+		if got := c.seat.refundDue(&GroupSession{Stage: c.stage}); got != c.due {
+			t.Errorf("seat %+v in a %s session: refundDue = %v, want %v", c.seat, c.stage, got, c.due)
+		}
+	}
+}
+
+func TestGroupSessionPaidSeats(t *testing.T) {
+	session := &GroupSession{Seats: []GroupSeat{
+		{Paid: true},
+		{Paid: true},
+		{Paid: true, Withdrawn: true},
+		{Paid: true, Refunded: true},
+		{},
+	}}
+
+	if got := session.PaidSeats(); got != 2 {
+		t.Errorf("PaidSeats = %d, want 2", got)
+	}
+	if got := session.TakenSeats(); got != 4 {
+		t.Errorf("TakenSeats = %d, want 4", got)
+	}
+}
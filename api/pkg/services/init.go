@@ -7,11 +7,11 @@ import (
 	"github.com/cs3305-team-4/api/pkg/database"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
-
-	stripe "github.com/stripe/stripe-go/v72"
 )
 
-func init() {
+// Init reads the config, connects to and migrates the database and sets up the payment provider and mailer.
+// It is called once when the server starts rather than on import, so the package can be tested without a database.
+func Init() {
 	viper.SetConfigName("config")
 	viper.SetConfigType("yaml")
 	viper.AddConfigPath("/")
@@ -40,20 +40,51 @@ Conn:
 		&PasswordHash{},
 		&Profile{},
 		&Qualification{},
+		&AvailabilityException{},
 		&WorkExperience{},
 		&Lesson{},
+		&LessonSeries{},
+		&GroupSession{},
+		&GroupSeat{},
 		&ResourceMetadata{},
 		&ResourceData{},
 		&Subject{},
 		&SubjectRequest{},
 		&SubjectTaught{},
 		&Review{},
+		&BillingEvent{},
+		&LessonMessage{},
+		&Conversation{},
+		&Message{},
+		&EmailVerification{},
+		&PasswordReset{},
+		&Session{},
+		&LoginLockout{},
+		&TwoFactor{},
+		&RecoveryCode{},
+		&OIDCLogin{},
+		&OIDCIdentity{},
+		&AuditEvent{},
+		&LessonStageTransition{},
+		&Dispute{},
 	)
 	// Add some test users so we don't need to manually test things
 	//CreateDebugData()
 
-	// Setup string key
-	stripe.Key = viper.GetString("billing.stripe.secret_key")
+	// Setup the payment provider
+	payments, err = NewPaymentProvider()
+	if err != nil {
+		panic(fmt.Errorf("could not setup payment provider %s\n", err))
+	}
+
+	// Setup the mailer
+	mailer, err = NewMailer()
+	if err != nil {
+		panic(fmt.Errorf("could not setup mailer %s\n", err))
+	}
+
+	// Failed logins are kept in memory until a shared store is needed
+	loginAttempts = NewMemoryLoginAttemptStore()
 
 	SeedDatabase()
 }
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"time"
+
+	"github.com/cs3305-team-4/api/pkg/database"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// LessonStageTransition records a lesson moving from one request stage to another.
+// The lesson itself only keeps its current stage, so these are the full history of the lesson.
+type LessonStageTransition struct {
+	database.Model
+	LessonID uuid.UUID `gorm:"type:uuid;index"`
+
+	// FromStage is empty for the transition that created the lesson
+	FromStage LessonRequestStage
+	ToStage   LessonRequestStage
+
+	// ActorID is the account that changed the stage, it is empty if the system changed it
+	ActorID *uuid.UUID `gorm:"type:uuid"`
+
+	Reason string
+
+	// The times of the lesson before and after the transition, only set if the transition moved the lesson
+	OldStartTime *time.Time
+	OldEndTime   *time.Time
+	NewStartTime *time.Time
+	NewEndTime   *time.Time
+}
+
+// recordLessonTransition writes the history entry for lesson moving to the stage, it must be called before the lesson
+// itself is updated. newStartTime and newEndTime are only needed if the lesson is being moved.
+func recordLessonTransition(tx *gorm.DB, lesson *Lesson, to LessonRequestStage, actorID *uuid.UUID, reason string, newStartTime *time.Time, newEndTime *time.Time) error {
+	transition := &LessonStageTransition{
+		LessonID:  lesson.ID,
+		FromStage: lesson.RequestStage,
+		ToStage:   to,
+		ActorID:   actorID,
+		Reason:    reason,
+	}
+
+	if newStartTime != nil && newEndTime != nil {
+		oldStartTime, oldEndTime := lesson.StartTime, lesson.EndTime
+		transition.OldStartTime = &oldStartTime
+		transition.OldEndTime = &oldEndTime
+		transition.NewStartTime = newStartTime
+		transition.NewEndTime = newEndTime
+	}
+
+	return tx.Create(transition).Error
+}
+
+// ReadLessonHistory returns every stage the lesson has been through, oldest first
+func ReadLessonHistory(lessonID uuid.UUID) ([]LessonStageTransition, error) {
+	db, err := database.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	history := []LessonStageTransition{}
+	return history, db.Where(&LessonStageTransition{LessonID: lessonID}).Order("created_at").Find(&history).Error
+}
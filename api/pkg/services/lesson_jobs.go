@@ -0,0 +1,209 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/cs3305-team-4/api/pkg/database"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LessonJobs returns the background jobs that move lessons along as time passes
+func LessonJobs() []Job {
+	interval := time.Duration(viper.GetInt64("jobs.interval")) * time.Second
+
+	return []Job{
+		{Name: "expire-lesson-requests", Interval: interval, Run: ExpireLessonRequests},
+		{Name: "complete-finished-lessons", Interval: interval, Run: CompleteFinishedLessons},
+		{Name: "refund-tutor-no-shows", Interval: interval, Run: RefundTutorNoShows},
+		{Name: "refund-ended-lessons", Interval: interval, Run: RefundEndedLessons},
+		{Name: "cancel-under-enrolled-group-sessions", Interval: interval, Run: CancelUnderEnrolledGroupSessions},
+		{Name: "complete-finished-group-sessions", Interval: interval, Run: CompleteFinishedGroupSessions},
+		{Name: "release-unpaid-group-seats", Interval: interval, Run: ReleaseUnpaidGroupSeats},
+		{Name: "refund-group-seats", Interval: interval, Run: RefundGroupSeats},
+	}
+}
+
+// expiringLessonStages are the stages a lesson is expired from if it is still in them when it is due to start
+var expiringLessonStages = []LessonRequestStage{Requested, PaymentRequired, Rescheduled}
+
+// expiryDue reports whether the lesson was never accepted, paid for or its reschedule answered before it was due
+// to start
+func (l *Lesson) expiryDue(now time.Time) bool {
+	for _, stage := range expiringLessonStages {
+		if l.RequestStage == stage {
+			return !l.StartTime.After(now)
+		}
+	}
+	return false
+}
+
+// settleAfter is how long after a lesson ends that nobody can report a no-show or dispute it any more
+func settleAfter(now time.Time) time.Time {
+	return now.Add(-time.Duration(viper.GetInt64("lessons.auto_complete_after")) * time.Second)
+}
+
+// autoCompleteDue reports whether the lesson is scheduled and lessons.auto_complete_after has passed since it ended
+func (l *Lesson) autoCompleteDue(now time.Time) bool {
+	return l.RequestStage == Scheduled && !l.EndTime.After(settleAfter(now))
+}
+
+// tutorNoShowRefundDue reports whether the student of a lesson the tutor didn't show up for is still to be refunded,
+// and lessons.auto_complete_after has passed since it ended. Open disputes are left out by the query of the job.
+func (l *Lesson) tutorNoShowRefundDue(now time.Time) bool {
+	return l.RequestStage == NoShowTutor && l.Paid && !l.Refunded && !l.EndTime.After(settleAfter(now))
+}
+
+// refundedLessonStages are the stages of lessons that ended without happening, a paid lesson in them is refunded
+var refundedLessonStages = []LessonRequestStage{Denied, Cancelled, Expired}
+
+// refundDue reports whether the lesson ended without happening and its payment still has to be given back
+func (l *Lesson) refundDue() bool {
+	if !l.Paid || l.Refunded {
+		return false
+	}
+
+	for _, stage := range refundedLessonStages {
+		if l.RequestStage == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// transitionLessonsBySystem moves every lesson that is still due to the stage, a lesson that fails is logged and
+// skipped. The lessons are checked again once locked, as they may have changed since they were queried.
+func transitionLessonsBySystem(ctx context.Context, lessonIDs []uuid.UUID, now time.Time, due func(l *Lesson, now time.Time) bool, to LessonRequestStage, reason string) error {
+	db, err := database.Open()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range lessonIDs {
+		err = db.Transaction(func(tx *gorm.DB) error {
+			lesson := &Lesson{}
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(lesson, id).Error
+			if err != nil || !due(lesson, now) {
+				return err
+			}
+
+			return transitionLesson(ctx, tx, id, nil, to, &LessonChange{Reason: reason})
+		})
+		if err != nil {
+			log.WithError(err).WithField("lesson", id).Errorf("Could not move lesson to %s", to)
+		}
+	}
+
+	return nil
+}
+
+// ExpireLessonRequests expires lesson requests that were never accepted or paid for, and reschedules that were never
+// answered, before the lesson was due to start
+func ExpireLessonRequests(ctx context.Context, now time.Time) error {
+	db, err := database.Open()
+	if err != nil {
+		return err
+	}
+
+	var ids []uuid.UUID
+	err = db.Model(&Lesson{}).
+		Where("request_stage IN ? AND start_time <= ?", expiringLessonStages, now).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return err
+	}
+
+	return transitionLessonsBySystem(ctx, ids, now, (*Lesson).expiryDue, Expired, "the lesson was due to start before the request was completed")
+}
+
+// CompleteFinishedLessons completes scheduled lessons once lessons.auto_complete_after has passed since they ended
+func CompleteFinishedLessons(ctx context.Context, now time.Time) error {
+	db, err := database.Open()
+	if err != nil {
+		return err
+	}
+
+	var ids []uuid.UUID
+	err = db.Model(&Lesson{}).
+		Where("request_stage = ? AND end_time <= ?", Scheduled, settleAfter(now)).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return err
+	}
+
+	return transitionLessonsBySystem(ctx, ids, now, (*Lesson).autoCompleteDue, Completed, "")
+}
+
+// RefundTutorNoShows refunds the students of lessons the tutor didn't show up for, once lessons.auto_complete_after has
+// passed since they ended without the tutor disputing it
+func RefundTutorNoShows(ctx context.Context, now time.Time) error {
+	db, err := database.Open()
+	if err != nil {
+		return err
+	}
+
+	var ids []uuid.UUID
+	err = db.Model(&Lesson{}).
+		Where("request_stage = ? AND paid = ? AND refunded = ? AND end_time <= ?", NoShowTutor, true, false, settleAfter(now)).
+		Scopes(notDisputed).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		err = db.Transaction(func(tx *gorm.DB) error {
+			// lock the lesson so a dispute can't be raised while it is refunded
+			lesson := &Lesson{}
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(lesson, id).Error
+			if err != nil || !lesson.tutorNoShowRefundDue(now) {
+				return err
+			}
+
+			return refundIfPaid(ctx, tx, lesson)
+		})
+		if err != nil {
+			log.WithError(err).WithField("lesson", id).Error("Could not refund lesson")
+		}
+	}
+
+	return nil
+}
+
+// RefundEndedLessons refunds paid lessons that ended without happening but weren't refunded when they ended, such as
+// when the payment provider couldn't be reached. Refunds are keyed by payment intent, so retrying one is safe.
+func RefundEndedLessons(ctx context.Context, now time.Time) error {
+	db, err := database.Open()
+	if err != nil {
+		return err
+	}
+
+	var ids []uuid.UUID
+	err = db.Model(&Lesson{}).
+		Where("request_stage IN ? AND paid = ? AND refunded = ?", refundedLessonStages, true, false).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		err = db.Transaction(func(tx *gorm.DB) error {
+			lesson := &Lesson{}
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(lesson, id).Error
+			if err != nil || !lesson.refundDue() {
+				return err
+			}
+
+			return lesson.refund(ctx, tx)
+		})
+		if err != nil {
+			log.WithError(err).WithField("lesson", id).Error("Could not refund lesson")
+		}
+	}
+
+	return nil
+}
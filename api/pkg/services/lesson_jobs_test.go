@@ -0,0 +1,79 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestLessonJobsDue(t *testing.T) {
+	viper.Set("lessons.auto_complete_after", 3600)
+	defer viper.Set("lessons.auto_complete_after", nil)
+
+	booked := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	lessonAt := func(stage LessonRequestStage, paid bool, refunded bool) *Lesson {
+		return &Lesson{
+			RequestStage: stage,
+			Paid:         paid,
+			Refunded:     refunded,
+			StartTime:    booked.Add(time.Hour),
+			EndTime:      booked.Add(2 * time.Hour),
+		}
+	}
+
+	// The clock is moved through each of these in turn: before the lesson, when it starts, when it ends,
+	// when lessons.auto_complete_after has passed since it ended and a day after that
+	steps := []time.Duration{0, time.Hour, 2 * time.Hour, 3 * time.Hour, 27 * time.Hour}
+
+	jobs := map[string]func(l *Lesson, now time.Time) bool{
+		"expire":          (*Lesson).expiryDue,
+		"complete":        (*Lesson).autoCompleteDue,
+		"refund no-shows": (*Lesson).tutorNoShowRefundDue,
+		"refund ended":    func(l *Lesson, _ time.Time) bool { return l.refundDue() },
+	}
+
+	cases := []struct {
+		job    string
+		lesson *Lesson
+		due    []bool
+	}{
+		{"expire", lessonAt(Requested, false, false), []bool{false, true, true, true, true}},
+		{"expire", lessonAt(PaymentRequired, false, false), []bool{false, true, true, true, true}},
+		{"expire", lessonAt(PaymentRequired, true, false), []bool{false, true, true, true, true}},
+		{"expire", lessonAt(Rescheduled, true, false), []bool{false, true, true, true, true}},
+		{"expire", lessonAt(Scheduled, true, false), []bool{false, false, false, false, false}},
+		{"expire", lessonAt(Cancelled, false, false), []bool{false, false, false, false, false}},
+
+		{"complete", lessonAt(Scheduled, true, false), []bool{false, false, false, true, true}},
+		{"complete", lessonAt(NoShowStudent, true, false), []bool{false, false, false, false, false}},
+		{"complete", lessonAt(NoShowTutor, true, false), []bool{false, false, false, false, false}},
+		{"complete", lessonAt(Completed, true, false), []bool{false, false, false, false, false}},
+
+		{"refund no-shows", lessonAt(NoShowTutor, true, false), []bool{false, false, false, true, true}},
+		{"refund no-shows", lessonAt(NoShowTutor, true, true), []bool{false, false, false, false, false}},
+		{"refund no-shows", lessonAt(NoShowTutor, false, false), []bool{false, false, false, false, false}},
+		{"refund no-shows", lessonAt(Scheduled, true, false), []bool{false, false, false, false, false}},
+
+		{"refund ended", lessonAt(Cancelled, true, false), []bool{true, true, true, true, true}},
+		{"refund ended", lessonAt(Denied, true, false), []bool{true, true, true, true, true}},
+		{"refund ended", lessonAt(Expired, true, false), []bool{true, true, true, true, true}},
+		{"refund ended", lessonAt(Cancelled, true, true), []bool{false, false, false, false, false}},
+		{"refund ended", lessonAt(Cancelled, false, false), []bool{false, false, false, false, false}},
+		{"refund ended", lessonAt(Scheduled, true, false), []bool{false, false, false, false, false}},
+		{"refund ended", lessonAt(NoShowTutor, true, false), []bool{false, false, false, false, false}},
+	}
+
+	for _, c := range cases {
+		clock := useTestClock(t, booked)
+
+		for i, step := range steps {
+			clock.Advance(booked.Add(step).Sub(clock.Now()))
+
+			if got := jobs[c.job](c.lesson, clock.Now()); got != c.due[i] {
+				t.Errorf("%s of a %s lesson (paid %v, refunded %v) at +%s: due = %v, want %v",
+					c.job, c.lesson.RequestStage, c.lesson.Paid, c.lesson.Refunded, step, got, c.due[i])
+			}
+		}
+	}
+}
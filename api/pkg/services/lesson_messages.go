@@ -0,0 +1,97 @@
+package services
+
+import (
+	"math"
+
+	"github.com/cs3305-team-4/api/pkg/database"
+	"github.com/google/uuid"
+)
+
+// LessonMessage is a chat message sent in the classroom of a lesson
+type LessonMessage struct {
+	database.Model
+	LessonID uuid.UUID `gorm:"type:uuid;index"`
+	SenderID uuid.UUID `gorm:"type:uuid"`
+	Body     string    `gorm:"not null;"`
+}
+
+// LessonMessageStore is implemented by anything that can keep the chat of classrooms
+type LessonMessageStore interface {
+	// Create stores a chat message sent in the classroom of a lesson
+	Create(lessonID uuid.UUID, senderID uuid.UUID, body string) (*LessonMessage, error)
+
+	// ReadRecent returns up to limit of the latest chat messages of a lesson, oldest first
+	ReadRecent(lessonID uuid.UUID, limit int) ([]LessonMessage, error)
+}
+
+// databaseLessonMessageStore keeps the chat of classrooms in the database
+type databaseLessonMessageStore struct{}
+
+func (databaseLessonMessageStore) Create(lessonID uuid.UUID, senderID uuid.UUID, body string) (*LessonMessage, error) {
+	return CreateLessonMessage(lessonID, senderID, body)
+}
+
+func (databaseLessonMessageStore) ReadRecent(lessonID uuid.UUID, limit int) ([]LessonMessage, error) {
+	return ReadRecentLessonMessages(lessonID, limit)
+}
+
+var lessonMessages LessonMessageStore = databaseLessonMessageStore{}
+
+// SetLessonMessageStore replaces the store classroom chat is kept in, this is intended for tests
+func SetLessonMessageStore(s LessonMessageStore) {
+	lessonMessages = s
+}
+
+// CreateLessonMessage stores a chat message sent in the classroom of a lesson
+func CreateLessonMessage(lessonID uuid.UUID, senderID uuid.UUID, body string) (*LessonMessage, error) {
+	db, err := database.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	message := &LessonMessage{
+		LessonID: lessonID,
+		SenderID: senderID,
+		Body:     body,
+	}
+	return message, db.Create(message).Error
+}
+
+// ReadLessonMessagesPaginated returns the chat messages of a lesson newest first, along with the total number of pages
+func ReadLessonMessagesPaginated(lessonID uuid.UUID, pageSize int, page int) ([]LessonMessage, int, error) {
+	db, err := database.Open()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int64
+	err = db.Model(&LessonMessage{}).Where(&LessonMessage{LessonID: lessonID}).Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var messages []LessonMessage
+	err = db.Where(&LessonMessage{LessonID: lessonID}).
+		Order("created_at desc").
+		Scopes(Paginate(pageSize, page)).
+		Find(&messages).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return messages, int(math.Ceil(float64(total) / float64(pageSize))), nil
+}
+
+// ReadRecentLessonMessages returns up to limit of the latest chat messages of a lesson, oldest first
+func ReadRecentLessonMessages(lessonID uuid.UUID, limit int) ([]LessonMessage, error) {
+	messages, _, err := ReadLessonMessagesPaginated(lessonID, limit, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+
+	return messages, nil
+}
@@ -0,0 +1,419 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cs3305-team-4/api/pkg/database"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LessonSeriesError types.
+type LessonSeriesError string
+
+func (e LessonSeriesError) Error() string {
+	return string(e)
+}
+
+const (
+	LessonSeriesErrorInvalidRecurrence LessonSeriesError = "The recurrence must be FREQ=WEEKLY with an INTERVAL of 1 or 2 and either a COUNT or an UNTIL date (YYYYMMDD)."
+	LessonSeriesErrorTooLong           LessonSeriesError = "A lesson series can have at most 52 lessons."
+	LessonSeriesErrorNotInSeries       LessonSeriesError = "This lesson is not part of a series."
+	LessonSeriesErrorNoLessons         LessonSeriesError = "There are no lessons in the series that can be changed."
+)
+
+// maxSeriesLessons is the most lessons a series can have, a year of weekly lessons
+const maxSeriesLessons = 52
+
+// Recurrence is a weekly repeat written like an RFC 5545 RRULE, such as FREQ=WEEKLY;INTERVAL=2;COUNT=10
+type Recurrence struct {
+	// Interval is the number of weeks between lessons, 1 (weekly) or 2 (biweekly)
+	Interval int
+
+	// Count is the number of lessons, or 0 if the series runs until Until
+	Count int
+
+	// Until is the last day a lesson can be on, in the time zone of the tutor
+	Until *time.Time
+}
+
+// ParseRecurrence parses a weekly RRULE, only the FREQ, INTERVAL, COUNT and UNTIL parts are supported
+func ParseRecurrence(rule string) (*Recurrence, error) {
+	r := &Recurrence{Interval: 1}
+	freq := ""
+
+	for _, part := range strings.Split(strings.TrimPrefix(strings.ToUpper(rule), "RRULE:"), ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, LessonSeriesErrorInvalidRecurrence
+		}
+
+		var err error
+		switch kv[0] {
+		case "FREQ":
+			freq = kv[1]
+		case "INTERVAL":
+			r.Interval, err = strconv.Atoi(kv[1])
+		case "COUNT":
+			r.Count, err = strconv.Atoi(kv[1])
+		case "UNTIL":
+			var until time.Time
+			until, err = time.Parse("20060102", kv[1])
+			r.Until = &until
+		default:
+			return nil, LessonSeriesErrorInvalidRecurrence
+		}
+		if err != nil {
+			return nil, LessonSeriesErrorInvalidRecurrence
+		}
+	}
+
+	if freq != "WEEKLY" || (r.Interval != 1 && r.Interval != 2) || r.Count < 0 || (r.Count == 0) == (r.Until == nil) {
+		return nil, LessonSeriesErrorInvalidRecurrence
+	}
+	if r.Count > maxSeriesLessons {
+		return nil, LessonSeriesErrorTooLong
+	}
+
+	return r, nil
+}
+
+// String returns the recurrence as an RRULE
+func (r *Recurrence) String() string {
+	rule := fmt.Sprintf("FREQ=WEEKLY;INTERVAL=%d", r.Interval)
+	if r.Count > 0 {
+		return rule + fmt.Sprintf(";COUNT=%d", r.Count)
+	}
+	return rule + ";UNTIL=" + r.Until.Format("20060102")
+}
+
+// Occurrences returns the start times of the lessons of the series, every lesson starts at the same wall clock time
+// as the first in the time zone of loc, even across daylight saving changes
+func (r *Recurrence) Occurrences(start time.Time, loc *time.Location) ([]time.Time, error) {
+	start = start.In(loc)
+
+	times := []time.Time{}
+	for i := 0; r.Count == 0 || i < r.Count; i++ {
+		t := start.AddDate(0, 0, 7*r.Interval*i)
+		if r.Until != nil && dateOf(t).After(dateOf(*r.Until)) {
+			break
+		}
+		if len(times) == maxSeriesLessons {
+			return nil, LessonSeriesErrorTooLong
+		}
+
+		times = append(times, t.UTC())
+	}
+
+	if len(times) == 0 {
+		return nil, LessonSeriesErrorInvalidRecurrence
+	}
+	return times, nil
+}
+
+// LessonSeries is a lesson that repeats every week or two, requested and accepted once for all of its lessons
+type LessonSeries struct {
+	database.Model
+
+	// Recurrence of the series as an RRULE
+	Recurrence string
+
+	// Time of the first lesson
+	StartTime time.Time
+
+	// Duration of each lesson in minutes
+	Duration int
+
+	StudentID       uuid.UUID `gorm:"type:uuid;index"`
+	TutorID         uuid.UUID `gorm:"type:uuid;index"`
+	SubjectTaughtID uuid.UUID `gorm:"type:uuid"`
+	RequesterID     uuid.UUID `gorm:"type:uuid"`
+
+	// LessonDetail contains notes about what the student needs out of the lessons
+	LessonDetail string
+
+	Lessons []Lesson `gorm:"foreignKey:SeriesID"`
+}
+
+// RequestLessonSeries requests every lesson of the recurrence at once, each lesson is checked against the availability
+// of the tutor and the other lessons of both participants. The lessons are paid for one at a time once accepted.
+func RequestLessonSeries(requester *Account, student *Account, subjectTaught *SubjectTaught, startTime time.Time, duration int, recurrence string, lessonDetail string) (*LessonSeries, error) {
+	if !startTime.After(clock.Now()) {
+		return nil, fmt.Errorf("can't request a lesson in the past")
+	}
+
+	rule, err := ParseRecurrence(recurrence)
+	if err != nil {
+		return nil, err
+	}
+
+	tutor, duration, err := checkLessonRequest(requester, student, subjectTaught, duration)
+	if err != nil {
+		return nil, err
+	}
+
+	times, err := rule.Occurrences(startTime, ReadAccountLocation(tutor.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := database.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	series := &LessonSeries{
+		Recurrence:      rule.String(),
+		StartTime:       times[0],
+		Duration:        duration,
+		StudentID:       student.ID,
+		TutorID:         tutor.ID,
+		SubjectTaughtID: subjectTaught.ID,
+		RequesterID:     requester.ID,
+		LessonDetail:    lessonDetail,
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`set transaction isolation level repeatable read`).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Create(series).Error; err != nil {
+			return err
+		}
+
+		for _, t := range times {
+			endTime := t.Add(time.Minute * time.Duration(duration))
+			if err := checkLessonTimeFree(tx, student, tutor, t, endTime); err != nil {
+				return fmt.Errorf("lesson at %s: %w", t.Format(time.RFC3339), err)
+			}
+
+			// The payment intent is made when the student first pays for the lesson
+			l := &Lesson{
+				StartTime:             t,
+				EndTime:               endTime,
+				SeriesID:              &series.ID,
+				RequesterID:           requester.ID,
+				StudentID:             student.ID,
+				TutorID:               tutor.ID,
+				SubjectTaughtID:       subjectTaught.ID,
+				LessonDetail:          lessonDetail,
+				RequestStage:          Requested,
+				RequestStageDetail:    lessonDetail,
+				RequestStageChangerID: requester.ID,
+			}
+			if err := tx.Omit("Student", "Tutor", "SubjectTaught", "Requester", "RequestStageChanger").Create(l).Error; err != nil {
+				return err
+			}
+
+			err := recordLessonTransition(tx, &Lesson{Model: l.Model}, Requested, &requester.ID, lessonDetail, nil, nil)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return series, nil
+}
+
+// ReadLessonSeriesByID returns the series with its lessons in order
+func ReadLessonSeriesByID(id uuid.UUID) (*LessonSeries, error) {
+	db, err := database.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	series := &LessonSeries{}
+	err = db.Preload("Lessons", func(db *gorm.DB) *gorm.DB {
+		return db.Order("start_time")
+	}).Preload("Lessons.SubjectTaught.Subject").First(series, id).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return series, nil
+}
+
+// transitionLessons moves every lesson to the stage in one transaction, in the order given, so either all of them
+// move or none do. The effects of the transitions, like refunds, call the payment provider so they only run once the
+// moves are committed, each in its own transaction. A refund that fails is retried by RefundEndedLessons.
+func transitionLessons(ctx context.Context, lessonIDs []uuid.UUID, actor *Account, to LessonRequestStage, changes []LessonChange) error {
+	if len(lessonIDs) == 0 {
+		return LessonSeriesErrorNoLessons
+	}
+
+	db, err := database.Open()
+	if err != nil {
+		return err
+	}
+
+	applied := make([]*lessonTransition, len(lessonIDs))
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for i, id := range lessonIDs {
+			t, _, err := applyLessonTransition(ctx, tx, id, actor, to, &changes[i])
+			if err != nil {
+				return err
+			}
+			applied[i] = t
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, id := range lessonIDs {
+		t := applied[i]
+		if t.effect == nil {
+			continue
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			// lock the lesson again, it may have been changed or refunded since it was moved
+			lesson := &Lesson{}
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(lesson, id).Error
+			if err != nil || lesson.RequestStage != t.to {
+				return err
+			}
+
+			return t.effect(ctx, tx, lesson)
+		})
+		if err != nil {
+			log.WithError(err).WithField("lesson", id).Errorf("Could not finish moving lesson to %s", to)
+		}
+	}
+
+	return nil
+}
+
+// seriesLessonIDs returns the IDs of the lessons of the series in the stages, in order
+func seriesLessonIDs(seriesID uuid.UUID, from time.Time, stages []LessonRequestStage) ([]uuid.UUID, error) {
+	db, err := database.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uuid.UUID
+	return ids, db.Model(&Lesson{}).
+		Where("series_id = ? AND start_time >= ? AND request_stage IN ?", seriesID, from, stages).
+		Order("start_time").
+		Pluck("id", &ids).Error
+}
+
+// seriesLessonIDsMovableBy returns the IDs of the lessons of the series the account can move to the stage, in order
+func seriesLessonIDsMovableBy(seriesID uuid.UUID, acc *Account, to LessonRequestStage) ([]uuid.UUID, error) {
+	db, err := database.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	var lessons []Lesson
+	err = db.Where("series_id = ?", seriesID).Order("start_time").Find(&lessons).Error
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []uuid.UUID{}
+	for i := range lessons {
+		if _, err := findLessonTransition(&lessons[i], acc, to); err == nil {
+			ids = append(ids, lessons[i].ID)
+		}
+	}
+	return ids, nil
+}
+
+// sameChange returns n copies of the change, one for each lesson it is made to
+func sameChange(n int, change LessonChange) []LessonChange {
+	changes := make([]LessonChange, n)
+	for i := range changes {
+		changes[i] = change
+	}
+	return changes
+}
+
+// Accept accepts every lesson of the series that was requested or rescheduled by the other participant, so the
+// student can pay for them
+func (s *LessonSeries) Accept(ctx context.Context, acceptor *Account) error {
+	ids, err := seriesLessonIDsMovableBy(s.ID, acceptor, PaymentRequired)
+	if err != nil {
+		return err
+	}
+
+	return transitionLessons(ctx, ids, acceptor, PaymentRequired, sameChange(len(ids), LessonChange{}))
+}
+
+// Deny denies every lesson of the series that was requested or rescheduled by the other participant
+func (s *LessonSeries) Deny(ctx context.Context, denier *Account, reason string) error {
+	ids, err := seriesLessonIDsMovableBy(s.ID, denier, Denied)
+	if err != nil {
+		return err
+	}
+
+	return transitionLessons(ctx, ids, denier, Denied, sameChange(len(ids), LessonChange{Reason: reason}))
+}
+
+// MarkCancelledFollowing cancels the lesson and every later lesson of its series that hasn't already ended
+func (l *Lesson) MarkCancelledFollowing(ctx context.Context, cancelee *Account, reason string) error {
+	if l.SeriesID == nil {
+		return LessonSeriesErrorNotInSeries
+	}
+
+	ids, err := seriesLessonIDs(*l.SeriesID, l.StartTime, activeLessonStages)
+	if err != nil {
+		return err
+	}
+
+	return transitionLessons(ctx, ids, cancelee, Cancelled, sameChange(len(ids), LessonChange{Reason: reason}))
+}
+
+// MarkRescheduledFollowing moves the lesson to newTime and every later lesson of its series by the same number of
+// weeks from newTime as it was from the lesson, keeping the wall clock time in the time zone of the tutor
+func (l *Lesson) MarkRescheduledFollowing(ctx context.Context, reschedulee *Account, newTime time.Time, reason string) error {
+	if l.SeriesID == nil {
+		return LessonSeriesErrorNotInSeries
+	}
+
+	db, err := database.Open()
+	if err != nil {
+		return err
+	}
+
+	var lessons []Lesson
+	err = db.Where("series_id = ? AND start_time >= ? AND request_stage IN ?", *l.SeriesID, l.StartTime, activeLessonStages).
+		Order("start_time").
+		Find(&lessons).Error
+	if err != nil {
+		return err
+	}
+
+	// Moving lessons later is done from the last lesson back, so no lesson is moved onto one that hasn't moved yet
+	if newTime.After(l.StartTime) {
+		for i, j := 0, len(lessons)-1; i < j; i, j = i+1, j-1 {
+			lessons[i], lessons[j] = lessons[j], lessons[i]
+		}
+	}
+
+	newLocal := newTime.In(ReadAccountLocation(l.TutorID))
+	ids := make([]uuid.UUID, len(lessons))
+	changes := make([]LessonChange, len(lessons))
+	for i, lesson := range lessons {
+		weeks := int(math.Round(lesson.StartTime.Sub(l.StartTime).Hours() / (7 * 24)))
+		t := newLocal.AddDate(0, 0, 7*weeks)
+
+		ids[i] = lesson.ID
+		changes[i] = LessonChange{Reason: reason, StartTime: &t}
+	}
+
+	return transitionLessons(ctx, ids, reschedulee, Rescheduled, changes)
+}
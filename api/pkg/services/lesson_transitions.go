@@ -0,0 +1,363 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cs3305-team-4/api/pkg/database"
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LessonError types.
+type LessonError string
+
+func (e LessonError) Error() string {
+	return string(e)
+}
+
+const (
+	LessonErrorTransitionNotAllowed LessonError = "The lesson can not be moved to that stage from the stage it is in."
+	LessonErrorActorNotAllowed      LessonError = "You are not allowed to make this change to the lesson."
+	LessonErrorNotPaid              LessonError = "The lesson has not been paid for by the student."
+	LessonErrorNoStartTime          LessonError = "A new time is needed to reschedule the lesson."
+	LessonErrorNoShowWindow         LessonError = "A no-show can only be reported once the lesson has started and until shortly after it ends."
+)
+
+// LessonParty describes who may move a lesson from one stage to another, relative to the lesson
+type LessonParty string
+
+const (
+	// Either the student or the tutor of the lesson
+	PartyParticipant LessonParty = "participant"
+
+	// The student of the lesson
+	PartyStudent LessonParty = "student"
+
+	// The tutor of the lesson
+	PartyTutor LessonParty = "tutor"
+
+	// The participant who requested the lesson
+	PartyRequester LessonParty = "requester"
+
+	// The participant who was sent the lesson request
+	PartyRequestee LessonParty = "requestee"
+
+	// The participant who last changed the stage of the lesson
+	PartyStageChanger LessonParty = "stage-changer"
+
+	// The participant who did not last change the stage of the lesson
+	PartyOtherParty LessonParty = "other-party"
+
+	// The api itself, such as the background jobs
+	PartySystem LessonParty = "system"
+)
+
+// includes returns true if the account is this party of the lesson, a nil account is the system
+func (p LessonParty) includes(l *Lesson, acc *Account) bool {
+	if acc == nil {
+		return p == PartySystem
+	}
+
+	if acc.ID != l.StudentID && acc.ID != l.TutorID {
+		return false
+	}
+
+	switch p {
+	case PartyParticipant:
+		return true
+	case PartyStudent:
+		return acc.ID == l.StudentID
+	case PartyTutor:
+		return acc.ID == l.TutorID
+	case PartyRequester:
+		return acc.ID == l.RequesterID
+	case PartyRequestee:
+		return acc.ID != l.RequesterID
+	case PartyStageChanger:
+		return acc.ID == l.RequestStageChangerID
+	case PartyOtherParty:
+		return acc.ID != l.RequestStageChangerID
+	}
+
+	return false
+}
+
+// LessonChange contains the details given when moving a lesson to another stage
+type LessonChange struct {
+	// Reason is kept as the stage detail of stages that take one
+	Reason string
+
+	// StartTime is the new time of the lesson, it is needed to reschedule the lesson
+	StartTime *time.Time
+}
+
+// lessonTransition describes one move allowed between two stages
+type lessonTransition struct {
+	from LessonRequestStage
+	to   LessonRequestStage
+	by   LessonParty
+
+	// reason is true if the reason of the change becomes the stage detail of the lesson
+	reason bool
+
+	// audit is the action recorded in the audit log for the transition, if any
+	audit AuditAction
+
+	// prepare runs before the lesson is updated, it can reject the transition or add to the update
+	prepare func(tx *gorm.DB, lesson *Lesson, change *LessonChange, update *Lesson) error
+
+	// effect runs once the lesson has been updated
+	effect func(ctx context.Context, tx *gorm.DB, lesson *Lesson) error
+}
+
+// lessonTransitions is every move a lesson can make between stages, anything not listed here is not allowed
+var lessonTransitions = []lessonTransition{
+	{from: Requested, to: PaymentRequired, by: PartyRequestee, prepare: ensurePaymentIntent},
+	{from: Rescheduled, to: PaymentRequired, by: PartyOtherParty, prepare: ensurePaymentIntent},
+
+	// A scheduled lesson keeps its payment when it is rescheduled, so every way a lesson ends without happening
+	// refunds it.
+	{from: Requested, to: Denied, by: PartyRequestee, reason: true, effect: refundIfPaid},
+	{from: Rescheduled, to: Denied, by: PartyOtherParty, reason: true, effect: refundIfPaid},
+
+	{from: PaymentRequired, to: Scheduled, by: PartyParticipant, audit: AuditLessonScheduled, prepare: requirePaid},
+
+	{from: Scheduled, to: Completed, by: PartyTutor},
+	{from: Scheduled, to: Completed, by: PartySystem},
+
+	// The tutor keeps the payment if the student doesn't show up, the student is refunded if the tutor doesn't.
+	// The refund is held until the tutor can no longer dispute it, a tutor who wins the dispute is paid as if the
+	// lesson completed.
+	{from: Scheduled, to: NoShowStudent, by: PartyTutor, reason: true, prepare: requireNoShowWindow},
+	{from: Scheduled, to: NoShowTutor, by: PartyStudent, reason: true, prepare: requireNoShowWindow},
+	{from: NoShowTutor, to: Completed, by: PartySystem, reason: true},
+
+	{from: Requested, to: Expired, by: PartySystem, reason: true, effect: refundIfPaid},
+	{from: PaymentRequired, to: Expired, by: PartySystem, reason: true, effect: refundIfPaid},
+	{from: Rescheduled, to: Expired, by: PartySystem, reason: true, effect: refundIfPaid},
+
+	{from: Scheduled, to: Cancelled, by: PartyParticipant, reason: true, audit: AuditLessonCancelled, effect: refundIfPaid},
+	{from: Requested, to: Cancelled, by: PartyRequester, reason: true, audit: AuditLessonCancelled, effect: refundIfPaid},
+	{from: PaymentRequired, to: Cancelled, by: PartyRequester, reason: true, audit: AuditLessonCancelled, effect: refundIfPaid},
+	{from: Rescheduled, to: Cancelled, by: PartyStageChanger, reason: true, audit: AuditLessonCancelled, effect: refundIfPaid},
+
+	{from: Scheduled, to: Rescheduled, by: PartyParticipant, reason: true, audit: AuditLessonRescheduled, prepare: moveLesson},
+	{from: Requested, to: Rescheduled, by: PartyParticipant, reason: true, audit: AuditLessonRescheduled, prepare: moveLesson},
+	{from: Rescheduled, to: Rescheduled, by: PartyParticipant, reason: true, audit: AuditLessonRescheduled, prepare: moveLesson},
+	{from: PaymentRequired, to: Rescheduled, by: PartyParticipant, reason: true, audit: AuditLessonRescheduled, prepare: moveLesson},
+}
+
+// findLessonTransition returns the transition the account can use to move the lesson to the stage
+func findLessonTransition(l *Lesson, acc *Account, to LessonRequestStage) (*lessonTransition, error) {
+	var err error = fmt.Errorf("%w (%s to %s)", LessonErrorTransitionNotAllowed, l.RequestStage, to)
+
+	for i := range lessonTransitions {
+		t := &lessonTransitions[i]
+		if t.from != l.RequestStage || t.to != to {
+			continue
+		}
+
+		if t.by.includes(l, acc) {
+			return t, nil
+		}
+		err = LessonErrorActorNotAllowed
+	}
+
+	return nil, err
+}
+
+// requirePaid stops a lesson being scheduled before the student has paid for it
+func requirePaid(tx *gorm.DB, lesson *Lesson, change *LessonChange, update *Lesson) error {
+	if !lesson.Paid {
+		return LessonErrorNotPaid
+	}
+	return nil
+}
+
+// ensurePaymentIntent gives the lesson a payment intent for the student to pay, if it doesn't have one already.
+// Lessons of a series are only priced, their intent is made when the student first goes to pay for them.
+func ensurePaymentIntent(tx *gorm.DB, lesson *Lesson, change *LessonChange, update *Lesson) error {
+	if lesson.PaymentIntentID != "" {
+		return nil
+	}
+
+	priced := &Lesson{}
+	err := tx.Preload("Student").Preload("SubjectTaught").First(priced, lesson.ID).Error
+	if err != nil {
+		return err
+	}
+
+	if lesson.SeriesID != nil {
+		priced.setPrice()
+	} else if err = priced.SetupPaymentIntent(); err != nil {
+		return err
+	}
+
+	update.PaymentIntentID = priced.PaymentIntentID
+	update.PriceAmount = priced.PriceAmount
+	update.PayoutAmount = priced.PayoutAmount
+	return nil
+}
+
+// moveLesson moves the lesson to the new time of the change, as long as both participants are free then
+func moveLesson(tx *gorm.DB, lesson *Lesson, change *LessonChange, update *Lesson) error {
+	if change.StartTime == nil {
+		return LessonErrorNoStartTime
+	}
+
+	newTime := change.StartTime.UTC()
+	if !newTime.After(clock.Now()) {
+		return fmt.Errorf("can't reschedule a lesson to the past")
+	}
+
+	endTime := newTime.Add(lesson.Duration())
+
+	student := &Account{Model: database.Model{ID: lesson.StudentID}}
+	tutor := &Account{Model: database.Model{ID: lesson.TutorID}}
+	if err := checkLessonTimeFree(tx, student, tutor, newTime, endTime, lesson.ID); err != nil {
+		return err
+	}
+
+	update.StartTime = newTime
+	update.EndTime = endTime
+	return nil
+}
+
+// requireNoShowWindow only allows a no-show to be reported from the start of the lesson until lessons.no_show_window
+// seconds after it ends
+func requireNoShowWindow(tx *gorm.DB, lesson *Lesson, change *LessonChange, update *Lesson) error {
+	now := clock.Now()
+	closes := lesson.EndTime.Add(time.Duration(viper.GetInt64("lessons.no_show_window")) * time.Second)
+
+	if now.Before(lesson.StartTime) || now.After(closes) {
+		return LessonErrorNoShowWindow
+	}
+	return nil
+}
+
+// refundIfPaid gives the student their money back if they already paid for the lesson
+func refundIfPaid(ctx context.Context, tx *gorm.DB, lesson *Lesson) error {
+	if !lesson.Paid {
+		return nil
+	}
+
+	return lesson.refund(ctx, tx)
+}
+
+// transitionLesson moves the lesson to the stage on behalf of the account, if the transition table allows it.
+// A nil account is the system.
+func transitionLesson(ctx context.Context, tx *gorm.DB, lessonID uuid.UUID, actor *Account, to LessonRequestStage, change *LessonChange) error {
+	t, lesson, err := applyLessonTransition(ctx, tx, lessonID, actor, to, change)
+	if err != nil || t.effect == nil {
+		return err
+	}
+
+	return t.effect(ctx, tx, lesson)
+}
+
+// applyLessonTransition updates the lesson for the transition and returns the transition and the lesson as it was
+// before, without running the effect of the transition, so several lessons can be moved before any of their effects,
+// like refunds, happen
+func applyLessonTransition(ctx context.Context, tx *gorm.DB, lessonID uuid.UUID, actor *Account, to LessonRequestStage, change *LessonChange) (*lessonTransition, *Lesson, error) {
+	if change == nil {
+		change = &LessonChange{}
+	}
+
+	// lock the lesson so two changes can't race each other
+	lesson := &Lesson{}
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(lesson, lessonID).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	t, err := findLessonTransition(lesson, actor, to)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The system isn't an account, so the lesson keeps whoever last changed it
+	var actorID *uuid.UUID
+	update := &Lesson{
+		RequestStage: to,
+	}
+	if actor != nil {
+		actorID = &actor.ID
+		update.RequestStageChangerID = actor.ID
+	}
+	if t.reason {
+		update.RequestStageDetail = change.Reason
+	}
+
+	if t.prepare != nil {
+		if err = t.prepare(tx, lesson, change, update); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var newStartTime, newEndTime *time.Time
+	if !update.StartTime.IsZero() {
+		newStartTime, newEndTime = &update.StartTime, &update.EndTime
+	}
+
+	err = recordLessonTransition(tx, lesson, to, actorID, change.Reason, newStartTime, newEndTime)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	before := lesson.auditState()
+	after := *before
+	after.RequestStage = to
+	if t.reason {
+		after.RequestStageDetail = change.Reason
+	}
+	if newStartTime != nil {
+		after.StartTime = *newStartTime
+		after.EndTime = *newEndTime
+	}
+
+	if err = tx.Model(&Lesson{Model: lesson.Model}).Updates(update).Error; err != nil {
+		return nil, nil, err
+	}
+
+	if t.audit != "" {
+		err = recordAuditEvent(ctx, tx, t.audit, actorID, AuditTargetLesson, lesson.ID, before, &after)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return t, lesson, nil
+}
+
+// Transition moves the lesson to the stage on behalf of the account, if the transition table allows it
+func (l *Lesson) Transition(ctx context.Context, actor *Account, to LessonRequestStage, change *LessonChange) error {
+	db, err := database.Open()
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		return transitionLesson(ctx, tx, l.ID, actor, to, change)
+	})
+}
+
+// AllowedTransitions returns the stages the account can currently move the lesson to
+func (l *Lesson) AllowedTransitions(actor *Account) []LessonRequestStage {
+	allowed := []LessonRequestStage{}
+	seen := map[LessonRequestStage]bool{}
+
+	for _, t := range lessonTransitions {
+		if t.from != l.RequestStage || seen[t.to] || !t.by.includes(l, actor) {
+			continue
+		}
+
+		seen[t.to] = true
+		allowed = append(allowed, t.to)
+	}
+
+	return allowed
+}
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cs3305-team-4/api/pkg/database"
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+var allLessonStages = []LessonRequestStage{
+	Requested, PaymentRequired, Scheduled, Rescheduled, Cancelled, Denied, Completed, NoShowStudent, NoShowTutor, Expired,
+}
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+// transitionActors are the student, the tutor, an account that isn't part of the lesson and the system (nil)
+type transitionActors struct {
+	student, tutor, stranger *Account
+}
+
+func newTransitionActors() transitionActors {
+	return transitionActors{
+		student:  &Account{Model: database.Model{ID: uuid.New()}, Type: Student},
+		tutor:    &Account{Model: database.Model{ID: uuid.New()}, Type: Tutor},
+		stranger: &Account{Model: database.Model{ID: uuid.New()}, Type: Student},
+	}
+}
+
+// lesson returns a lesson in the stage, requested and last changed by the account
+func (a transitionActors) lesson(stage LessonRequestStage, requester *Account, changer *Account) *Lesson {
+	return &Lesson{
+		Model:                 database.Model{ID: uuid.New()},
+		StudentID:             a.student.ID,
+		TutorID:               a.tutor.ID,
+		RequesterID:           requester.ID,
+		RequestStageChangerID: changer.ID,
+		RequestStage:          stage,
+	}
+}
+
+func TestLessonTransitions(t *testing.T) {
+	actors := newTransitionActors()
+
+	type move struct {
+		from, to LessonRequestStage
+	}
+
+	// Who can make each move when the student requested the lesson and was the last to change it
+	allowed := map[move][]string{
+		{Requested, PaymentRequired}:   {"tutor"},
+		{Rescheduled, PaymentRequired}: {"tutor"},
+		{Requested, Denied}:            {"tutor"},
+		{Rescheduled, Denied}:          {"tutor"},
+		{PaymentRequired, Scheduled}:   {"student", "tutor"},
+		{Scheduled, Completed}:         {"tutor", "system"},
+		{Scheduled, NoShowStudent}:     {"tutor"},
+		{Scheduled, NoShowTutor}:       {"student"},
+		{NoShowTutor, Completed}:       {"system"},
+		{Requested, Expired}:           {"system"},
+		{PaymentRequired, Expired}:     {"system"},
+		{Rescheduled, Expired}:         {"system"},
+		{Scheduled, Cancelled}:         {"student", "tutor"},
+		{Requested, Cancelled}:         {"student"},
+		{PaymentRequired, Cancelled}:   {"student"},
+		{Rescheduled, Cancelled}:       {"student"},
+		{Scheduled, Rescheduled}:       {"student", "tutor"},
+		{Requested, Rescheduled}:       {"student", "tutor"},
+		{Rescheduled, Rescheduled}:     {"student", "tutor"},
+		{PaymentRequired, Rescheduled}: {"student", "tutor"},
+	}
+
+	parties := []struct {
+		name    string
+		account *Account
+	}{
+		{"student", actors.student},
+		{"tutor", actors.tutor},
+		{"stranger", actors.stranger},
+		{"system", nil},
+	}
+
+	for _, from := range allLessonStages {
+		for _, to := range allLessonStages {
+			for _, party := range parties {
+				lesson := actors.lesson(from, actors.student, actors.student)
+				_, err := findLessonTransition(lesson, party.account, to)
+
+				want := false
+				for _, name := range allowed[move{from, to}] {
+					want = want || name == party.name
+				}
+
+				switch {
+				case want && err != nil:
+					t.Errorf("%s: %s to %s should be allowed, got %v", party.name, from, to, err)
+				case !want && err == nil:
+					t.Errorf("%s: %s to %s should not be allowed", party.name, from, to)
+				case !want && len(allowed[move{from, to}]) > 0 && !errors.Is(err, LessonErrorActorNotAllowed):
+					t.Errorf("%s: %s to %s should be refused to the actor, got %v", party.name, from, to, err)
+				case !want && len(allowed[move{from, to}]) == 0 && !errors.Is(err, LessonErrorTransitionNotAllowed):
+					t.Errorf("%s: %s to %s should not be a transition, got %v", party.name, from, to, err)
+				}
+			}
+		}
+	}
+}
+
+func TestLessonPartyIncludes(t *testing.T) {
+	actors := newTransitionActors()
+
+	cases := []struct {
+		party     LessonParty
+		requester *Account
+		changer   *Account
+		student   bool
+		tutor     bool
+		system    bool
+	}{
+		{PartyParticipant, actors.student, actors.student, true, true, false},
+		{PartyStudent, actors.student, actors.student, true, false, false},
+		{PartyTutor, actors.student, actors.student, false, true, false},
+		{PartyRequester, actors.student, actors.student, true, false, false},
+		{PartyRequester, actors.tutor, actors.tutor, false, true, false},
+		{PartyRequestee, actors.student, actors.student, false, true, false},
+		{PartyRequestee, actors.tutor, actors.tutor, true, false, false},
+		{PartyStageChanger, actors.student, actors.tutor, false, true, false},
+		{PartyOtherParty, actors.student, actors.tutor, true, false, false},
+		{PartySystem, actors.student, actors.student, false, false, true},
+	}
+
+	for _, c := range cases {
+		lesson := actors.lesson(Scheduled, c.requester, c.changer)
+
+		if got := c.party.includes(lesson, actors.student); got != c.student {
+			t.Errorf("%s includes student = %v, want %v", c.party, got, c.student)
+		}
+		if got := c.party.includes(lesson, actors.tutor); got != c.tutor {
+			t.Errorf("%s includes tutor = %v, want %v", c.party, got, c.tutor)
+		}
+		if got := c.party.includes(lesson, nil); got != c.system {
+			t.Errorf("%s includes system = %v, want %v", c.party, got, c.system)
+		}
+		if c.party.includes(lesson, actors.stranger) {
+			t.Errorf("%s includes an account that isn't part of the lesson", c.party)
+		}
+	}
+}
+
+func TestLessonTransitionPrepare(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	SetClock(fixedClock(now))
+	defer SetClock(systemClock{})
+
+	viper.Set("lessons.no_show_window", 3600)
+	defer viper.Set("lessons.no_show_window", nil)
+
+	past := now.Add(-time.Hour)
+
+	cases := []struct {
+		name    string
+		prepare func(tx *gorm.DB, lesson *Lesson, change *LessonChange, update *Lesson) error
+		lesson  Lesson
+		change  LessonChange
+		want    error
+	}{
+		{"unpaid lesson can't be scheduled", requirePaid, Lesson{}, LessonChange{}, LessonErrorNotPaid},
+		{"paid lesson can be scheduled", requirePaid, Lesson{Paid: true}, LessonChange{}, nil},
+		{"no-show before the lesson starts", requireNoShowWindow,
+			Lesson{StartTime: now.Add(time.Minute), EndTime: now.Add(time.Hour)}, LessonChange{}, LessonErrorNoShowWindow},
+		{"no-show during the lesson", requireNoShowWindow,
+			Lesson{StartTime: now.Add(-time.Minute), EndTime: now.Add(time.Hour)}, LessonChange{}, nil},
+		{"no-show within the window after the lesson", requireNoShowWindow,
+			Lesson{StartTime: now.Add(-2 * time.Hour), EndTime: now.Add(-time.Hour + time.Minute)}, LessonChange{}, nil},
+		{"no-show after the window", requireNoShowWindow,
+			Lesson{StartTime: now.Add(-3 * time.Hour), EndTime: now.Add(-2 * time.Hour)}, LessonChange{}, LessonErrorNoShowWindow},
+		{"reschedule without a time", moveLesson, Lesson{}, LessonChange{}, LessonErrorNoStartTime},
+		{"existing payment intent is kept", ensurePaymentIntent, Lesson{PaymentIntentID: "pi_existing"}, LessonChange{}, nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			update := &Lesson{}
+			err := c.prepare(nil, &c.lesson, &c.change, update)
+			if !errors.Is(err, c.want) {
+				t.Fatalf("got %v, want %v", err, c.want)
+			}
+			if update.PaymentIntentID != "" {
+				t.Errorf("the update was given payment intent %s", update.PaymentIntentID)
+			}
+		})
+	}
+
+	t.Run("reschedule to the past", func(t *testing.T) {
+		if err := moveLesson(nil, &Lesson{}, &LessonChange{StartTime: &past}, &Lesson{}); err == nil {
+			t.Fatal("moving a lesson to the past should fail")
+		}
+	})
+}
+
+func TestLessonTransitionEffects(t *testing.T) {
+	useFakePayments(t)
+
+	cases := []struct {
+		name    string
+		lesson  Lesson
+		wantErr bool
+	}{
+		{"unpaid lesson isn't refunded", Lesson{PaymentIntentID: "pi_unknown"}, false},
+		{"refunded lesson isn't refunded again", Lesson{Paid: true, Refunded: true, PaymentIntentID: "pi_unknown"}, false},
+		{"refund the provider refuses", Lesson{Paid: true, PaymentIntentID: "pi_unknown"}, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := refundIfPaid(context.Background(), nil, &c.lesson)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("got %v, want error %v", err, c.wantErr)
+			}
+		})
+	}
+}
+
+// A lesson that ends without happening must give the student their money back, whichever way it got there
+func TestLessonTransitionsIntoTerminalStagesRefund(t *testing.T) {
+	useFakePayments(t)
+
+	terminal := map[LessonRequestStage]bool{Denied: true, Cancelled: true, Expired: true}
+
+	for _, transition := range lessonTransitions {
+		if !terminal[transition.to] {
+			continue
+		}
+
+		if transition.effect == nil {
+			t.Errorf("%s to %s doesn't refund the lesson", transition.from, transition.to)
+			continue
+		}
+
+		// The fake provider refuses to refund an intent it doesn't know, so an effect that refunds fails here
+		paid := &Lesson{Paid: true, PaymentIntentID: "pi_unknown", RequestStage: transition.to}
+		if err := transition.effect(context.Background(), nil, paid); err == nil {
+			t.Errorf("%s to %s doesn't refund a paid lesson", transition.from, transition.to)
+		}
+	}
+}
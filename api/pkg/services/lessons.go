@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"time"
@@ -104,6 +105,29 @@ type Lesson struct {
 
 	// Resources are
 	Resources []ResourceMetadata `gorm:"foreignKey:LessonID"`
+
+	// History contains every stage change of the lesson, use ReadLessonHistory to load it in order
+	History []LessonStageTransition `gorm:"foreignKey:LessonID"`
+
+	// SeriesID is the series the lesson is part of, if it repeats
+	SeriesID *uuid.UUID `gorm:"type:uuid;index"`
+}
+
+// lessonAuditState is the part of a lesson recorded in audit events when its stage changes
+type lessonAuditState struct {
+	RequestStage       LessonRequestStage `json:"request_stage"`
+	RequestStageDetail string             `json:"request_stage_detail"`
+	StartTime          time.Time          `json:"start_time"`
+	EndTime            time.Time          `json:"end_time"`
+}
+
+func (l *Lesson) auditState() *lessonAuditState {
+	return &lessonAuditState{
+		RequestStage:       l.RequestStage,
+		RequestStageDetail: l.RequestStageDetail,
+		StartTime:          l.StartTime,
+		EndTime:            l.EndTime,
+	}
 }
 
 // ResourceMetadata contains metadata about a resource
@@ -122,55 +146,136 @@ type ResourceData struct {
 	Data []byte `gorm:"type:bytea"`
 }
 
-// LessonAtTime returns true if the account has a lesson at that time
-func LessonAtTime(acc *Account, startTime time.Time, endTime time.Time) (bool, error) {
+// activeLessonStages are the stages of lessons that still take up their time
+var activeLessonStages = []LessonRequestStage{Requested, PaymentRequired, Scheduled, Rescheduled}
+
+// Duration returns how long the lesson is
+func (l *Lesson) Duration() time.Duration {
+	return l.EndTime.Sub(l.StartTime).Round(time.Minute)
+}
+
+// LessonAtTime returns true if the account has a lesson at that time, lessons with the ignored IDs aren't counted
+func LessonAtTime(acc *Account, startTime time.Time, endTime time.Time, ignore ...uuid.UUID) (bool, error) {
 	db, err := database.Open()
 	if err != nil {
 		return false, err
 	}
 
-	var lessons []Lesson
+	return lessonAtTime(db, acc, startTime, endTime, ignore...)
+}
 
-	result := db.Where(
-		"(student_id = ? OR tutor_id = ?) AND (end_time > ? AND start_time < ?)",
-		acc.ID, acc.ID, startTime, endTime,
-	).Find(&lessons)
+// lessonAtTime is LessonAtTime inside a transaction, so lessons moved earlier in it are seen where they now are
+func lessonAtTime(db *gorm.DB, acc *Account, startTime time.Time, endTime time.Time, ignore ...uuid.UUID) (bool, error) {
+	query := db.Where(
+		"(student_id = ? OR tutor_id = ?) AND (end_time > ? AND start_time < ?) AND request_stage IN ?",
+		acc.ID, acc.ID, startTime, endTime, activeLessonStages,
+	)
+	if len(ignore) > 0 {
+		query = query.Where("id NOT IN ?", ignore)
+	}
 
-	if result.Error != nil {
-		return false, result.Error
+	var count int64
+	if err := query.Model(&Lesson{}).Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// checkLessonTimeFree returns an error unless the tutor is available and neither participant has a lesson or group
+// session between startTime and endTime, lessons with the ignored IDs aren't counted
+func checkLessonTimeFree(tx *gorm.DB, student *Account, tutor *Account, startTime time.Time, endTime time.Time, ignore ...uuid.UUID) error {
+	if err := checkTutorAvailable(tutor.ID, startTime, endTime); err != nil {
+		return err
 	}
 
-	if len(lessons) > 1 {
-		return true, nil
+	lat, err := lessonAtTime(tx, student, startTime, endTime, ignore...)
+	if err != nil {
+		return err
+	}
+
+	if !lat {
+		if lat, err = groupSessionAtTime(tx, student, startTime, endTime); err != nil {
+			return err
+		}
 	}
 
-	return false, nil
+	if lat == true {
+		return fmt.Errorf("cannot create lesson: the student has a lesson at that time")
+	}
+
+	lat, err = lessonAtTime(tx, tutor, startTime, endTime, ignore...)
+	if err != nil {
+		return err
+	}
+
+	if !lat {
+		if lat, err = groupSessionAtTime(tx, tutor, startTime, endTime); err != nil {
+			return err
+		}
+	}
+
+	if lat == true {
+		return fmt.Errorf("cannot create lesson: the teacher has a lesson at that time")
+	}
+
+	return nil
 }
 
-//Sends a lesson request between a Student and a Tutor
-//Keeps track of who is sending the current request via the requestor Account
-func RequestLesson(requester *Account, student *Account, subjectTaught *SubjectTaught, startTime time.Time, lessonDetail string) error {
-	if !startTime.After(time.Now()) {
-		return fmt.Errorf("can't request a lesson in the past")
+// checkLessonRequest checks the requester can request lessons of the subject for the student, it returns the tutor
+// of the subject and the duration of the lessons in minutes
+func checkLessonRequest(requester *Account, student *Account, subjectTaught *SubjectTaught, duration int) (*Account, int, error) {
+	if !requester.EmailVerified {
+		return nil, 0, AccountErrorEmailNotVerified
 	}
 
 	tutor, err := ReadAccountByID(subjectTaught.TutorID, nil)
 	if err != nil {
-		return err
+		return nil, 0, err
 	}
 
 	if !(requester.ID == student.ID || requester.ID == tutor.ID) {
-		return errors.New("account requesting the lesson must be involved in the lesson")
+		return nil, 0, errors.New("account requesting the lesson must be involved in the lesson")
 	}
 
 	if student.Type != Student {
-		return fmt.Errorf("specified student account is not a student")
+		return nil, 0, fmt.Errorf("specified student account is not a student")
 	}
 
 	if tutor.Type != Tutor {
-		return fmt.Errorf("specified tutor account is not a tutor")
+		return nil, 0, fmt.Errorf("specified tutor account is not a tutor")
+	}
+
+	if duration == 0 {
+		duration = DefaultLessonDuration
+	}
+
+	if !subjectTaught.Offers(duration) {
+		return nil, 0, SubjectTaughtErrorDurationNotOffered
+	}
+
+	if err = checkTutorNotOnVacation(tutor.ID); err != nil {
+		return nil, 0, err
+	}
+
+	return tutor, duration, nil
+}
+
+//Sends a lesson request between a Student and a Tutor
+//Keeps track of who is sending the current request via the requestor Account
+func RequestLesson(requester *Account, student *Account, subjectTaught *SubjectTaught, startTime time.Time, duration int, lessonDetail string) error {
+	if !startTime.After(clock.Now()) {
+		return fmt.Errorf("can't request a lesson in the past")
 	}
 
+	tutor, duration, err := checkLessonRequest(requester, student, subjectTaught, duration)
+	if err != nil {
+		return err
+	}
+
+	// Lessons are kept in UTC, they are shown in the time zone of whoever is looking at them
+	startTime = startTime.UTC()
+
 	db, err := database.Open()
 	if err != nil {
 		return err
@@ -183,28 +288,12 @@ func RequestLesson(requester *Account, student *Account, subjectTaught *SubjectT
 			return err
 		}
 
-		endTime := startTime.Add(time.Minute*time.Duration(59) + time.Second*time.Duration(59))
+		endTime := startTime.Add(time.Minute * time.Duration(duration))
 
-		lat, err := LessonAtTime(student, startTime, endTime)
-		if err != nil {
-			tx.Rollback()
+		if err = checkLessonTimeFree(tx, student, tutor, startTime, endTime); err != nil {
 			return err
 		}
 
-		if lat == true {
-			return fmt.Errorf("cannot create lesson: the student has a lesson at that time")
-		}
-
-		lat, err = LessonAtTime(tutor, startTime, endTime)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-
-		if lat == true {
-			return fmt.Errorf("cannot create lesson: the teacher has a lesson at that time")
-		}
-
 		// First create stripe invoice for the lesson
 
 		l := &Lesson{
@@ -238,7 +327,8 @@ func RequestLesson(requester *Account, student *Account, subjectTaught *SubjectT
 			return err
 		}
 
-		return nil
+		// The lesson had no stage before it was requested
+		return recordLessonTransition(tx, &Lesson{Model: l.Model}, Requested, &requester.ID, lessonDetail, nil, nil)
 	})
 
 	return err
@@ -307,289 +397,44 @@ func (l *Lesson) CreateResource(name string, mime string, data []byte) error {
 }
 
 //marks a speific lesson as scheduled if the student account has completed payment
-func (l *Lesson) MarkScheduled(requester *Account) error {
-	db, err := database.Open()
+func (l *Lesson) MarkScheduled(ctx context.Context, requester *Account) error {
+	err := l.RefreshPaidStatus()
 	if err != nil {
 		return err
 	}
 
-	err = l.RefreshPaidStatus()
-	if err != nil {
-		return err
-	}
-
-	if l.Paid == false {
-		return errors.New("cannot mark a lesson as scheduled if the lesson has not been paid for by the student")
-	}
-
-	err = db.Transaction(func(tx *gorm.DB) error {
-		// re-read the lesson, stops data races
-		lesson, err := ReadLessonByID(l.ID)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-
-		// Make a decision based off the current stage the lesson is at
-		switch lesson.RequestStage {
-		case PaymentRequired:
-			// Can only mark a lesson as scheduled if it previously required payment
-
-		default:
-			return fmt.Errorf("unsupported stage %s from %s", Scheduled, lesson.RequestStage)
-		}
-
-		db.Model(&lesson).Updates(&Lesson{
-			RequestStage:          Scheduled,
-			RequestStageChangerID: requester.ID,
-		})
-		return nil
-	})
-
-	return err
+	return l.Transition(ctx, requester, Scheduled, nil)
 }
 
 //marks that a lesson is accepted and now requires payment.
-func (l *Lesson) MarkPaymentRequired(acceptor *Account) error {
-	db, err := database.Open()
-	if err != nil {
-		return err
-	}
-
-	err = db.Transaction(func(tx *gorm.DB) error {
-		// re-read the lesson, stops data races
-		lesson, err := ReadLessonByID(l.ID)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-
-		// Make a decision based off the current stage the lesson is at
-		switch lesson.RequestStage {
-		case Requested:
-			if acceptor.ID == lesson.RequesterID {
-				return errors.New("you can not mark a lesson as scheduled if you were the one who created the lesson")
-			}
-
-		case Rescheduled:
-			if acceptor.ID == lesson.RequestStageChangerID {
-				return errors.New("you can not mark a lesson as scheduled if you were the one who rescheduled the lesson")
-			}
-
-		default:
-			return fmt.Errorf("unsupported stage %s from %s", Scheduled, lesson.RequestStage)
-		}
-
-		db.Model(&lesson).Updates(&Lesson{
-			RequestStage:          PaymentRequired,
-			RequestStageChangerID: acceptor.ID,
-		})
-		return nil
-	})
-
-	return err
+func (l *Lesson) MarkPaymentRequired(ctx context.Context, acceptor *Account) error {
+	return l.Transition(ctx, acceptor, PaymentRequired, nil)
 }
 
-func (l *Lesson) MarkDenied(denier *Account, reason string) error {
-	db, err := database.Open()
-	if err != nil {
-		return err
-	}
-
-	err = db.Transaction(func(tx *gorm.DB) error {
-		// re-read the lesson, stops data races
-		lesson, err := ReadLessonByID(l.ID)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-
-		// Make a decision based off the current stage the lesson is at
-		switch lesson.RequestStage {
-		case Requested:
-			if denier.ID == lesson.RequesterID {
-				return errors.New("you can not mark a lesson as denied if you were the one who created the lesson")
-			}
-
-		case Rescheduled:
-			if denier.ID == lesson.RequestStageChangerID {
-				return errors.New("you can not mark a lesson as denied if you were the one who rescheduled the lesson")
-			}
-
-		default:
-			return fmt.Errorf("unsupported stage %s from %s", Denied, lesson.RequestStage)
-		}
-
-		db.Model(&lesson).Updates(&Lesson{
-			RequestStage:          Denied,
-			RequestStageDetail:    reason,
-			RequestStageChangerID: denier.ID,
-		})
-		return nil
-	})
-
-	return err
+func (l *Lesson) MarkDenied(ctx context.Context, denier *Account, reason string) error {
+	return l.Transition(ctx, denier, Denied, &LessonChange{Reason: reason})
 }
 
-func (l *Lesson) MarkCompleted(tutor *Account) error {
-	db, err := database.Open()
-	if err != nil {
-		return err
-	}
-
-	err = db.Transaction(func(tx *gorm.DB) error {
-		// re-read the lesson, stops data races
-		lesson, err := ReadLessonByID(l.ID)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-
-		// Make a decision based off the current stage the lesson is at
-		switch lesson.RequestStage {
-		case Scheduled:
-			if tutor.Type != Tutor {
-				return errors.New("only tutors can mark lesson as completed")
-			}
-			if tutor.ID != lesson.TutorID {
-				return errors.New("you are not the tutor of this lesson")
-			}
-
-		default:
-			return fmt.Errorf("unsupported stage %s from %s", Denied, lesson.RequestStage)
-		}
-
-		db.Model(&lesson).Updates(&Lesson{
-			RequestStage:          Completed,
-			RequestStageDetail:    "",
-			RequestStageChangerID: tutor.ID,
-		})
-		return nil
-	})
-
-	return err
+func (l *Lesson) MarkCompleted(ctx context.Context, tutor *Account) error {
+	return l.Transition(ctx, tutor, Completed, nil)
 }
 
-func (l *Lesson) MarkCancelled(cancelee *Account, reason string) error {
-	db, err := database.Open()
-	if err != nil {
-		return err
+// MarkNoShow reports that the other participant didn't show up to the lesson
+func (l *Lesson) MarkNoShow(ctx context.Context, reporter *Account, reason string) error {
+	stage := NoShowTutor
+	if reporter.ID == l.TutorID {
+		stage = NoShowStudent
 	}
 
-	err = db.Transaction(func(tx *gorm.DB) error {
-		// re-read the lesson, stops data races
-		lesson, err := ReadLessonByID(l.ID)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-
-		// Make a decision based off the current stage the lesson is at
-		switch lesson.RequestStage {
-		case Scheduled:
-
-		case Requested:
-			if cancelee.ID != lesson.RequesterID {
-				return errors.New("only the person who requested the lesson can cancel the request")
-			}
-
-		case PaymentRequired:
-			if cancelee.ID != lesson.RequesterID {
-				return errors.New("only the person who requested the lesson can cancel the request")
-			}
-
-		case Rescheduled:
-			if cancelee.ID != lesson.RequestStageChangerID {
-				return errors.New("only the person who rescheduled the lesson can cancel the request")
-			}
-
-		default:
-			return fmt.Errorf("unsupported stage %s from %s", Cancelled, lesson.RequestStage)
-		}
-
-		if l.Paid == true {
-			err = l.Refund()
-			if err != nil {
-				tx.Rollback()
-				return err
-			}
-		}
-
-		db.Model(&lesson).Updates(&Lesson{
-			RequestStage:          Cancelled,
-			RequestStageDetail:    reason,
-			RequestStageChangerID: cancelee.ID,
-		})
-		return nil
-	})
-
-	return err
+	return l.Transition(ctx, reporter, stage, &LessonChange{Reason: reason})
 }
 
-func (l *Lesson) MarkRescheduled(reschedulee *Account, newTime time.Time, reason string) error {
-	db, err := database.Open()
-	if err != nil {
-		return err
-	}
-
-	if !newTime.After(time.Now()) {
-		return fmt.Errorf("can't reschedule a lesson to the past")
-	}
-
-	err = db.Transaction(func(tx *gorm.DB) error {
-		// re-read the lesson, stops data races
-		lesson, err := ReadLessonByID(l.ID, "Student", "Tutor")
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-
-		// Make a decision based off the current stage the lesson is at
-		switch lesson.RequestStage {
-		case Scheduled:
-			break
-		case Requested:
-			break
-		case Rescheduled:
-			break
-
-		default:
-			return fmt.Errorf("unsupported stage %s from %s", Rescheduled, lesson.RequestStage)
-		}
-
-		endTime := newTime.Add(time.Minute*time.Duration(59) + time.Second*time.Duration(59))
-
-		lat, err := LessonAtTime(&lesson.Student, newTime, endTime)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-
-		if lat == true {
-			return fmt.Errorf("cannot create lesson: the student has a lesson at that time")
-		}
-
-		lat, err = LessonAtTime(&lesson.Tutor, newTime, endTime)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-
-		if lat == true {
-			return fmt.Errorf("cannot create lesson: the teacher has a lesson at that time")
-		}
-
-		db.Model(&lesson).Updates(&Lesson{
-			StartTime:             newTime,
-			EndTime:               endTime,
-			RequestStage:          Rescheduled,
-			RequestStageDetail:    reason,
-			RequestStageChangerID: reschedulee.ID,
-		})
-		return nil
-	})
+func (l *Lesson) MarkCancelled(ctx context.Context, cancelee *Account, reason string) error {
+	return l.Transition(ctx, cancelee, Cancelled, &LessonChange{Reason: reason})
+}
 
-	return err
+func (l *Lesson) MarkRescheduled(ctx context.Context, reschedulee *Account, newTime time.Time, reason string) error {
+	return l.Transition(ctx, reschedulee, Rescheduled, &LessonChange{Reason: reason, StartTime: &newTime})
 }
 
 func (l *Lesson) ReadResourceByID(rid uuid.UUID) (*ResourceMetadata, error) {
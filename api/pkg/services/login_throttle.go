@@ -0,0 +1,176 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/cs3305-team-4/api/pkg/database"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// LoginThrottledError is returned when too many logins have failed from an address or for an email
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return fmt.Sprintf("Too many failed login attempts, please try again in %d seconds.", e.RetryAfterSeconds())
+}
+
+// RetryAfterSeconds is RetryAfter rounded up to whole seconds, as used by the Retry-After header
+func (e *LoginThrottledError) RetryAfterSeconds() int64 {
+	return int64(math.Ceil(e.RetryAfter.Seconds()))
+}
+
+// LoginAttempts are the failed logins recorded against an address or email
+type LoginAttempts struct {
+	Failures int
+
+	// BlockedUntil is when another login may be attempted
+	BlockedUntil time.Time
+}
+
+// LoginAttemptStore is implemented by anything that can keep track of failed logins.
+// Failures are forgotten once window has passed without another failure and the key is no longer blocked.
+type LoginAttemptStore interface {
+	// Read returns the attempts recorded against a key, nil if there are none
+	Read(key string) (*LoginAttempts, error)
+
+	// RecordFailure counts a failed login against a key and blocks it until blockedUntil returns
+	RecordFailure(key string, window time.Duration, blockedUntil func(failures int) time.Time) (*LoginAttempts, error)
+
+	// Reset forgets every attempt recorded against a key
+	Reset(key string) error
+}
+
+var loginAttempts LoginAttemptStore
+
+// SetLoginAttemptStore replaces the store failed logins are recorded in, this is intended for tests and for sharing
+// attempts between multiple instances of the api
+func SetLoginAttemptStore(s LoginAttemptStore) {
+	loginAttempts = s
+}
+
+// LoginLockout is recorded every time an address or email is locked out after too many failed logins
+type LoginLockout struct {
+	database.Model
+
+	// AccountID is the account the email belongs to, if there is one
+	AccountID *uuid.UUID `gorm:"type:uuid;index"`
+
+	Email       string `gorm:"index"`
+	IP          string `gorm:"index"`
+	Failures    int
+	LockedUntil time.Time
+}
+
+func loginIPKey(ip string) string {
+	return "ip:" + ip
+}
+
+func loginEmailKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// loginBlockedUntil backs off exponentially with every failure until maxFailures is reached, after which the key is locked out
+func loginBlockedUntil(maxFailures int) func(failures int) time.Time {
+	return func(failures int) time.Time {
+		if failures >= maxFailures {
+			return time.Now().Add(time.Duration(viper.GetInt64("auth.login.lockout")) * time.Second)
+		}
+
+		backoff := time.Duration(viper.GetInt64("auth.login.backoff_base")) * time.Second << uint(failures-1)
+		max := time.Duration(viper.GetInt64("auth.login.backoff_max")) * time.Second
+		if backoff <= 0 || backoff > max {
+			backoff = max
+		}
+		return time.Now().Add(backoff)
+	}
+}
+
+// CheckLoginAllowed returns a LoginThrottledError if logins from the ip or for the email are currently blocked
+func CheckLoginAllowed(ip string, email string) error {
+	var wait time.Duration
+	for _, key := range []string{loginIPKey(ip), loginEmailKey(email)} {
+		attempts, err := loginAttempts.Read(key)
+		if err != nil {
+			return err
+		}
+
+		if attempts != nil {
+			if remaining := time.Until(attempts.BlockedUntil); remaining > wait {
+				wait = remaining
+			}
+		}
+	}
+
+	if wait > 0 {
+		return &LoginThrottledError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// RecordLoginFailure counts a failed login against the ip and the email, locking them out once they reach the limit.
+// Failures for emails that don't belong to an account are counted too so lockouts don't give away which emails are registered.
+func RecordLoginFailure(ip string, email string) error {
+	window := time.Duration(viper.GetInt64("auth.login.window")) * time.Second
+
+	keys := []struct {
+		key         string
+		maxFailures int
+	}{
+		{loginIPKey(ip), viper.GetInt("auth.login.ip_max_failures")},
+		{loginEmailKey(email), viper.GetInt("auth.login.email_max_failures")},
+	}
+
+	for _, k := range keys {
+		attempts, err := loginAttempts.RecordFailure(k.key, window, loginBlockedUntil(k.maxFailures))
+		if err != nil {
+			return err
+		}
+
+		if attempts.Failures == k.maxFailures {
+			if err = recordLoginLockout(ip, email, attempts); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// RecordLoginSuccess clears the failures recorded against the email. The ip is left alone so one valid login
+// can't be used to keep guessing the passwords of other accounts.
+func RecordLoginSuccess(email string) error {
+	return loginAttempts.Reset(loginEmailKey(email))
+}
+
+func recordLoginLockout(ip string, email string, attempts *LoginAttempts) error {
+	log.WithFields(log.Fields{
+		"ip":           ip,
+		"email":        email,
+		"failures":     attempts.Failures,
+		"locked_until": attempts.BlockedUntil,
+	}).Warn("Locked out logins after too many failures")
+
+	db, err := database.Open()
+	if err != nil {
+		return err
+	}
+
+	lockout := &LoginLockout{
+		Email:       strings.ToLower(strings.TrimSpace(email)),
+		IP:          ip,
+		Failures:    attempts.Failures,
+		LockedUntil: attempts.BlockedUntil,
+	}
+	if account, err := ReadAccountByEmail(email, nil); err == nil {
+		lockout.AccountID = &account.ID
+	}
+
+	return db.Create(lockout).Error
+}
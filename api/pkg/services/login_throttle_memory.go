@@ -0,0 +1,98 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryLoginAttemptStore keeps failed logins in memory, they are lost on restart and not shared between instances
+type MemoryLoginAttemptStore struct {
+	mu        sync.Mutex
+	entries   map[string]*memoryLoginAttempts
+	lastSweep time.Time
+}
+
+type memoryLoginAttempts struct {
+	LoginAttempts
+	expiresAt time.Time
+}
+
+// NewMemoryLoginAttemptStore creates an empty in-memory login attempt store
+func NewMemoryLoginAttemptStore() *MemoryLoginAttemptStore {
+	return &MemoryLoginAttemptStore{
+		entries:   map[string]*memoryLoginAttempts{},
+		lastSweep: time.Now(),
+	}
+}
+
+// sweep drops expired entries every so often so the map doesn't grow forever, the caller must hold mu
+func (s *MemoryLoginAttemptStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+
+	for key, entry := range s.entries {
+		if now.After(entry.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// read returns the unexpired entry for a key, the caller must hold mu
+func (s *MemoryLoginAttemptStore) read(key string, now time.Time) *memoryLoginAttempts {
+	entry, ok := s.entries[key]
+	if !ok {
+		return nil
+	}
+	if now.After(entry.expiresAt) {
+		delete(s.entries, key)
+		return nil
+	}
+	return entry
+}
+
+func (s *MemoryLoginAttemptStore) Read(key string) (*LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := s.read(key, time.Now())
+	if entry == nil {
+		return nil, nil
+	}
+
+	attempts := entry.LoginAttempts
+	return &attempts, nil
+}
+
+func (s *MemoryLoginAttemptStore) RecordFailure(key string, window time.Duration, blockedUntil func(failures int) time.Time) (*LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	entry := s.read(key, now)
+	if entry == nil {
+		entry = &memoryLoginAttempts{}
+		s.entries[key] = entry
+	}
+
+	entry.Failures++
+	entry.BlockedUntil = blockedUntil(entry.Failures)
+	entry.expiresAt = now.Add(window)
+	if entry.BlockedUntil.After(entry.expiresAt) {
+		entry.expiresAt = entry.BlockedUntil
+	}
+
+	attempts := entry.LoginAttempts
+	return &attempts, nil
+}
+
+func (s *MemoryLoginAttemptStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+// Mail is an email to be sent to a single recipient
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer is implemented by anything that can deliver emails to accounts
+type Mailer interface {
+	Send(mail *Mail) error
+}
+
+var mailer Mailer
+
+// SetMailer replaces the mailer in use, this is intended for tests and local development
+func SetMailer(m Mailer) {
+	mailer = m
+}
+
+// NewMailer creates the mailer selected by mail.provider in the config
+func NewMailer() (Mailer, error) {
+	switch viper.GetString("mail.provider") {
+	case "", "log":
+		return NewLogMailer(viper.GetString("mail.log.file")), nil
+	case "smtp":
+		return NewSMTPMailer(
+			viper.GetString("mail.smtp.host"),
+			viper.GetString("mail.smtp.port"),
+			viper.GetString("mail.smtp.username"),
+			viper.GetString("mail.smtp.password"),
+			viper.GetString("mail.from"),
+		), nil
+	default:
+		return nil, fmt.Errorf("unknown mail provider %s", viper.GetString("mail.provider"))
+	}
+}
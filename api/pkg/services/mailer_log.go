@@ -0,0 +1,42 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// LogMailer is a mailer for local development, emails are appended to a file or written to the log if no file is set
+type LogMailer struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewLogMailer creates a mailer that appends emails to the file at path, or logs them if path is empty
+func NewLogMailer(path string) *LogMailer {
+	return &LogMailer{
+		path: path,
+	}
+}
+
+func (m *LogMailer) Send(mail *Mail) error {
+	if m.path == "" {
+		log.WithField("to", mail.To).WithField("subject", mail.Subject).Info(mail.Body)
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := os.OpenFile(m.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n", time.Now().Format(time.RFC1123Z), mail.To, mail.Subject, mail.Body)
+	return err
+}
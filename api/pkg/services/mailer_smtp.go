@@ -0,0 +1,57 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"strings"
+)
+
+// SMTPMailer sends emails through an SMTP server
+type SMTPMailer struct {
+	addr string
+	auth smtp.Auth
+
+	// from is the From header, which can include a display name like "GrindsApp <noreply@grindsapp.com>"
+	from string
+}
+
+// NewSMTPMailer creates a mailer that sends from the specified address, authenticating if a username is set
+func NewSMTPMailer(host string, port string, username string, password string, from string) *SMTPMailer {
+	m := &SMTPMailer{
+		addr: net.JoinHostPort(host, port),
+		from: from,
+	}
+
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return m
+}
+
+func (m *SMTPMailer) Send(mail *Mail) error {
+	// Stop header injection through the recipient or subject
+	if strings.ContainsAny(mail.To+mail.Subject, "\r\n") {
+		return fmt.Errorf("mail headers can not contain line breaks")
+	}
+
+	// The envelope sender is only the address, servers reject one with a display name
+	sender, err := netmail.ParseAddress(m.from)
+	if err != nil {
+		return fmt.Errorf("invalid sender address %q: %w", m.from, err)
+	}
+
+	msg := strings.Join([]string{
+		"From: " + m.from,
+		"To: " + mail.To,
+		"Subject: " + mail.Subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=\"utf-8\"",
+		"",
+		mail.Body,
+	}, "\r\n")
+
+	return smtp.SendMail(m.addr, m.auth, sender.Address, []string{mail.To}, []byte(msg))
+}
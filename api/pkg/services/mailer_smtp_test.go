@@ -0,0 +1,120 @@
+package services
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+)
+
+// smtpStub accepts a single email and records the commands and message it was sent
+type smtpStub struct {
+	listener net.Listener
+	commands []string
+	message  []string
+	done     chan struct{}
+}
+
+func newSMTPStub(t *testing.T) *smtpStub {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpStub{listener: listener, done: make(chan struct{})}
+	t.Cleanup(func() { listener.Close() })
+
+	go s.serve()
+	return s
+}
+
+func (s *smtpStub) serve() {
+	defer close(s.done)
+
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 stub ready")
+	inData := false
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		if inData {
+			if line == "." {
+				inData = false
+				reply("250 queued")
+			} else {
+				s.message = append(s.message, line)
+			}
+			continue
+		}
+
+		s.commands = append(s.commands, line)
+		switch verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); verb {
+		case "EHLO", "HELO":
+			reply("250 stub")
+		case "DATA":
+			inData = true
+			reply("354 go ahead")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	stub := newSMTPStub(t)
+	host, port, err := net.SplitHostPort(stub.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewSMTPMailer(host, port, "", "", "GrindsApp <noreply@grinds.example>")
+	err = m.Send(&Mail{To: "student@grinds.example", Subject: "Welcome", Body: "Hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-stub.done
+
+	wantCommands := []string{"MAIL FROM:<noreply@grinds.example>", "RCPT TO:<student@grinds.example>"}
+	for _, want := range wantCommands {
+		found := false
+		for _, command := range stub.commands {
+			found = found || strings.HasPrefix(command, want)
+		}
+		if !found {
+			t.Errorf("server wasn't sent %q, got %q", want, stub.commands)
+		}
+	}
+
+	if len(stub.message) == 0 || stub.message[0] != "From: GrindsApp <noreply@grinds.example>" {
+		t.Errorf("message should start with the From header including the display name, got %q", stub.message)
+	}
+}
+
+func TestSMTPMailerInvalidSender(t *testing.T) {
+	m := NewSMTPMailer("127.0.0.1", "1", "", "", "GrindsApp")
+	if err := m.Send(&Mail{To: "student@grinds.example", Subject: "Welcome", Body: "Hello"}); err == nil {
+		t.Fatal("a sender without an address was accepted")
+	}
+}
+
+func TestSMTPMailerHeaderInjection(t *testing.T) {
+	m := NewSMTPMailer("127.0.0.1", "1", "", "", "noreply@grinds.example")
+	err := m.Send(&Mail{To: "student@grinds.example\r\nBcc: someone@else.example", Subject: "Welcome", Body: "Hello"})
+	if err == nil {
+		t.Fatal("a recipient with a line break was accepted")
+	}
+}
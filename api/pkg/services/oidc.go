@@ -0,0 +1,499 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cs3305-team-4/api/pkg/database"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OIDCError types.
+type OIDCError string
+
+func (e OIDCError) Error() string {
+	return string(e)
+}
+
+const (
+	OIDCErrorUnknownProvider     OIDCError = "This sign in provider is not supported."
+	OIDCErrorInvalidState        OIDCError = "This sign in attempt is invalid or has expired, please try again."
+	OIDCErrorInvalidIDToken      OIDCError = "The sign in provider returned an invalid identity."
+	OIDCErrorEmailInUse          OIDCError = "An account already exists with this email address, please log in with your password."
+	OIDCErrorAccountTypeRequired OIDCError = "Choose whether you are a student or a tutor to sign up."
+)
+
+// oidcClient is used for every request to OIDC providers
+var oidcClient = &http.Client{Timeout: 10 * time.Second}
+
+// SetOIDCHTTPClient replaces the client used to talk to OIDC providers, this is intended for tests against a stub issuer
+func SetOIDCHTTPClient(c *http.Client) {
+	oidcClient = c
+}
+
+// OIDCProvider is an OpenID Connect issuer accounts can sign in with, configured under auth.oidc.providers
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	RedirectURL  string
+}
+
+// ReadOIDCProvider reads the configuration of a provider, providers without a client ID are disabled
+func ReadOIDCProvider(name string) (*OIDCProvider, error) {
+	key := "auth.oidc.providers." + strings.ToLower(name)
+	if !viper.IsSet(key) || viper.GetString(key+".client_id") == "" {
+		return nil, OIDCErrorUnknownProvider
+	}
+
+	scopes := viper.GetStringSlice(key + ".scopes")
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email"}
+	}
+
+	return &OIDCProvider{
+		Name:         strings.ToLower(name),
+		Issuer:       strings.TrimSuffix(viper.GetString(key+".issuer"), "/"),
+		ClientID:     viper.GetString(key + ".client_id"),
+		ClientSecret: viper.GetString(key + ".client_secret"),
+		Scopes:       scopes,
+		RedirectURL:  strings.TrimSuffix(viper.GetString("auth.oidc.redirect_url"), "/") + "/" + strings.ToLower(name),
+	}, nil
+}
+
+// oidcDiscovery is the part of the provider metadata at /.well-known/openid-configuration that is used
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+
+	fetchedAt time.Time
+}
+
+var (
+	oidcDiscoveryMu    sync.Mutex
+	oidcDiscoveryCache = map[string]*oidcDiscovery{}
+)
+
+// discover fetches the metadata of the provider, it is cached for an hour
+func (p *OIDCProvider) discover() (*oidcDiscovery, error) {
+	oidcDiscoveryMu.Lock()
+	defer oidcDiscoveryMu.Unlock()
+
+	if cached, ok := oidcDiscoveryCache[p.Issuer]; ok && time.Since(cached.fetchedAt) < time.Hour {
+		return cached, nil
+	}
+
+	discovery := &oidcDiscovery{}
+	if err := oidcGetJSON(p.Issuer+"/.well-known/openid-configuration", discovery); err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSuffix(discovery.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("oidc provider %s reported issuer %s, expected %s", p.Name, discovery.Issuer, p.Issuer)
+	}
+
+	discovery.fetchedAt = time.Now()
+	oidcDiscoveryCache[p.Issuer] = discovery
+	return discovery, nil
+}
+
+func oidcGetJSON(url string, out interface{}) error {
+	resp, err := oidcClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// OIDCLogin is started every time someone chooses to sign in with a provider. It holds the PKCE verifier and nonce
+// until the provider redirects back, only a hash of the state is stored as it is the only thing tying the two together.
+type OIDCLogin struct {
+	database.Model
+	Provider     string
+	StateHash    string `gorm:"uniqueIndex;not null;"`
+	Nonce        string
+	CodeVerifier string
+
+	// AccountType is the type of account to create if the identity isn't linked to one yet
+	AccountType AccountType
+
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+// OIDCIdentity links an identity at an OIDC provider to an account
+type OIDCIdentity struct {
+	database.Model
+	AccountID uuid.UUID `gorm:"type:uuid;index"`
+	Issuer    string    `gorm:"uniqueIndex:idx_oidc_identity_issuer_subject;not null;"`
+	Subject   string    `gorm:"uniqueIndex:idx_oidc_identity_issuer_subject;not null;"`
+	Email     string
+}
+
+func oidcRandomString() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func hashOIDCState(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return hex.EncodeToString(sum[:])
+}
+
+// BeginOIDCLogin returns the URL of the provider to send the user to. accountType may be empty if the user
+// is only signing in, it is required for the first sign in with an identity.
+func BeginOIDCLogin(providerName string, accountType AccountType) (string, error) {
+	provider, err := ReadOIDCProvider(providerName)
+	if err != nil {
+		return "", err
+	}
+
+	discovery, err := provider.discover()
+	if err != nil {
+		return "", err
+	}
+
+	state, err := oidcRandomString()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := oidcRandomString()
+	if err != nil {
+		return "", err
+	}
+	verifier, err := oidcRandomString()
+	if err != nil {
+		return "", err
+	}
+
+	db, err := database.Open()
+	if err != nil {
+		return "", err
+	}
+
+	err = db.Create(&OIDCLogin{
+		Provider:     provider.Name,
+		StateHash:    hashOIDCState(state),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		AccountType:  accountType,
+		ExpiresAt:    time.Now().Add(time.Duration(viper.GetInt64("auth.oidc.ttl")) * time.Second),
+	}).Error
+	if err != nil {
+		return "", err
+	}
+
+	return provider.authorizationURL(discovery, state, nonce, verifier)
+}
+
+// authorizationURL returns the URL of the provider that starts a login, with the S256 PKCE challenge of the verifier
+func (p *OIDCProvider) authorizationURL(discovery *oidcDiscovery, state string, nonce string, verifier string) (string, error) {
+	challenge := sha256.Sum256([]byte(verifier))
+	authURL, err := url.Parse(discovery.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.ClientID)
+	query.Set("redirect_uri", p.RedirectURL)
+	query.Set("scope", strings.Join(p.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+
+	return authURL.String(), nil
+}
+
+// FinishOIDCLogin exchanges the code the provider redirected back with for an identity and returns the account
+// linked to it. The first time an identity is seen it is linked to the account with the same verified email,
+// or a new account is created for it.
+func FinishOIDCLogin(providerName string, state string, code string) (*Account, error) {
+	provider, err := ReadOIDCProvider(providerName)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := database.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	login := &OIDCLogin{}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("state_hash = ?", hashOIDCState(state)).
+			First(login).Error
+		if err != nil {
+			return OIDCErrorInvalidState
+		}
+
+		now := time.Now()
+		if login.UsedAt != nil || now.After(login.ExpiresAt) || login.Provider != provider.Name {
+			return OIDCErrorInvalidState
+		}
+
+		return tx.Model(login).Update("used_at", now).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := provider.exchangeCode(code, login)
+	if err != nil {
+		return nil, err
+	}
+
+	return accountFromOIDCIdentity(provider, claims, login.AccountType)
+}
+
+type oidcTokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// exchangeCode swaps an authorization code for an ID token and verifies it
+func (p *OIDCProvider) exchangeCode(code string, login *OIDCLogin) (*oidcIDTokenClaims, error) {
+	discovery, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"client_id":     {p.ClientID},
+		"code_verifier": {login.CodeVerifier},
+	}
+	if p.ClientSecret != "" {
+		form.Set("client_secret", p.ClientSecret)
+	}
+
+	resp, err := oidcClient.PostForm(discovery.TokenEndpoint, form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	token := &oidcTokenResponse{}
+	if err = json.NewDecoder(resp.Body).Decode(token); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK || token.Error != "" {
+		return nil, fmt.Errorf("oidc provider %s rejected code: %s %s", p.Name, token.Error, token.ErrorDescription)
+	}
+
+	return p.verifyIDToken(discovery, token.IDToken, login.Nonce)
+}
+
+// oidcAudience is the aud claim of an ID token, which can be a single string or a list
+type oidcAudience []string
+
+func (a *oidcAudience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = oidcAudience{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+func (a oidcAudience) contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+type oidcIDTokenClaims struct {
+	Issuer        string       `json:"iss"`
+	Subject       string       `json:"sub"`
+	Audience      oidcAudience `json:"aud"`
+	ExpiresAt     int64        `json:"exp"`
+	Nonce         string       `json:"nonce"`
+	Email         string       `json:"email"`
+	EmailVerified bool         `json:"email_verified"`
+}
+
+func (c *oidcIDTokenClaims) Valid() error {
+	if time.Now().Unix() > c.ExpiresAt {
+		return errors.New("id token has expired")
+	}
+	return nil
+}
+
+type oidcJWKS struct {
+	Keys []struct {
+		Kid string `json:"kid"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"keys"`
+}
+
+// verifyIDToken checks the signature of an ID token against the keys of the provider, and that it was issued for this login
+func (p *OIDCProvider) verifyIDToken(discovery *oidcDiscovery, idToken string, nonce string) (*oidcIDTokenClaims, error) {
+	jwks := &oidcJWKS{}
+	if err := oidcGetJSON(discovery.JWKSURI, jwks); err != nil {
+		return nil, err
+	}
+
+	token, err := new(jwt.Parser).ParseWithClaims(idToken, &oidcIDTokenClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, errors.New("expected rsa signed id token")
+		}
+
+		kid, _ := token.Header["kid"].(string)
+		for _, key := range jwks.Keys {
+			if key.Kty != "RSA" || (kid != "" && key.Kid != kid) {
+				continue
+			}
+
+			n, err := base64.RawURLEncoding.DecodeString(key.N)
+			if err != nil {
+				return nil, err
+			}
+			e, err := base64.RawURLEncoding.DecodeString(key.E)
+			if err != nil {
+				return nil, err
+			}
+
+			return &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}, nil
+		}
+
+		return nil, errors.New("no matching key for id token")
+	})
+	if err != nil {
+		return nil, OIDCErrorInvalidIDToken
+	}
+
+	claims, ok := token.Claims.(*oidcIDTokenClaims)
+	if !ok ||
+		strings.TrimSuffix(claims.Issuer, "/") != p.Issuer ||
+		!claims.Audience.contains(p.ClientID) ||
+		claims.Nonce != nonce ||
+		claims.Subject == "" {
+		return nil, OIDCErrorInvalidIDToken
+	}
+
+	return claims, nil
+}
+
+// accountFromOIDCIdentity returns the account linked to an identity, linking or creating one if there isn't one yet
+func accountFromOIDCIdentity(p *OIDCProvider, claims *oidcIDTokenClaims, accountType AccountType) (*Account, error) {
+	db, err := database.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	var account *Account
+	err = db.Transaction(func(tx *gorm.DB) error {
+		identity := &OIDCIdentity{}
+		err := tx.Where(&OIDCIdentity{Issuer: p.Issuer, Subject: claims.Subject}).First(identity).Error
+		if err == nil {
+			account, err = ReadAccountByID(identity.AccountID, tx)
+			return err
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		var existing *Account
+		if claims.Email != "" {
+			existing, err = ReadAccountByEmail(claims.Email, tx)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				existing = nil
+			} else if err != nil {
+				return err
+			}
+		}
+
+		account, err = linkOIDCAccount(claims, existing, accountType)
+		if err != nil {
+			return err
+		}
+		if account != existing {
+			if err = tx.Create(account).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Create(&OIDCIdentity{
+			AccountID: account.ID,
+			Issuer:    p.Issuer,
+			Subject:   claims.Subject,
+			Email:     claims.Email,
+		}).Error
+	})
+	return account, err
+}
+
+// linkOIDCAccount decides which account a new identity is linked to. existing is the account with the email of the
+// identity, if there is one. A new account that still has to be created is returned if there isn't.
+func linkOIDCAccount(claims *oidcIDTokenClaims, existing *Account, accountType AccountType) (*Account, error) {
+	if claims.Email == "" {
+		return nil, OIDCErrorInvalidIDToken
+	}
+
+	if existing != nil {
+		// Only link to an existing account if both sides have proven they own the email
+		if !claims.EmailVerified || !existing.EmailVerified {
+			return nil, OIDCErrorEmailInUse
+		}
+		return existing, nil
+	}
+
+	if accountType == "" {
+		return nil, OIDCErrorAccountTypeRequired
+	}
+
+	return &Account{
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Type:          accountType,
+	}, nil
+}
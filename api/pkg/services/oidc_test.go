@@ -0,0 +1,415 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/spf13/viper"
+)
+
+// oidcStub is an OIDC issuer serving discovery, its keys and a token endpoint that checks the PKCE verifier
+type oidcStub struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu sync.Mutex
+	// issuer is reported by discovery, it is the URL of the stub unless a test changes it
+	issuer string
+	// challenge is the PKCE challenge of the login, codes are only exchanged with its verifier
+	challenge string
+	// idToken is returned for the code "good-code"
+	idToken string
+	// discoveries counts the requests for the provider metadata
+	discoveries int
+}
+
+func newOIDCStub(t *testing.T) *oidcStub {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &oidcStub{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.discoveries++
+
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 s.issuer,
+			"authorization_endpoint": s.URL + "/authorize",
+			"token_endpoint":         s.URL + "/token",
+			"jwks_uri":               s.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": "stub-key",
+				"kty": "RSA",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if r.PostFormValue("grant_type") != "authorization_code" ||
+			r.PostFormValue("client_id") != "stub-client" ||
+			r.PostFormValue("code") != "good-code" ||
+			base64.RawURLEncoding.EncodeToString(verifier[:]) != s.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{"id_token": s.idToken})
+	})
+
+	s.Server = httptest.NewServer(mux)
+	s.issuer = s.URL
+
+	SetOIDCHTTPClient(s.Client())
+	viper.Set("auth.oidc.redirect_url", "https://grinds.example/auth/oidc/")
+	viper.Set("auth.oidc.providers.stub", map[string]interface{}{
+		"issuer":    s.URL,
+		"client_id": "stub-client",
+	})
+
+	t.Cleanup(func() {
+		s.Close()
+		SetOIDCHTTPClient(&http.Client{Timeout: 10 * time.Second})
+		viper.Set("auth.oidc.redirect_url", nil)
+		viper.Set("auth.oidc.providers.stub", nil)
+
+		oidcDiscoveryMu.Lock()
+		oidcDiscoveryCache = map[string]*oidcDiscovery{}
+		oidcDiscoveryMu.Unlock()
+	})
+	return s
+}
+
+// claims returns the claims of a valid ID token for the nonce
+func (s *oidcStub) claims(nonce string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            s.URL,
+		"sub":            "stub-subject",
+		"aud":            "stub-client",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"nonce":          nonce,
+		"email":          "student@grinds.example",
+		"email_verified": true,
+	}
+}
+
+// sign signs the claims with the key of the stub
+func (s *oidcStub) sign(t *testing.T, claims jwt.MapClaims) string {
+	return signOIDCToken(t, s.key, "stub-key", claims)
+}
+
+func signOIDCToken(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// begin starts a login against the stub, returning the login and the query of the authorization URL
+func (s *oidcStub) begin(t *testing.T) (*OIDCProvider, *OIDCLogin, url.Values) {
+	provider, err := ReadOIDCProvider("stub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	discovery, err := provider.discover()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login := &OIDCLogin{Nonce: "stub-nonce", CodeVerifier: "stub-verifier"}
+	authURL, err := provider.authorizationURL(discovery, "stub-state", login.Nonce, login.CodeVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.mu.Lock()
+	s.challenge = parsed.Query().Get("code_challenge")
+	s.mu.Unlock()
+
+	return provider, login, parsed.Query()
+}
+
+func TestOIDCAuthorizationURL(t *testing.T) {
+	stub := newOIDCStub(t)
+	_, _, query := stub.begin(t)
+
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             "stub-client",
+		"redirect_uri":          "https://grinds.example/auth/oidc/stub",
+		"scope":                 "openid email",
+		"state":                 "stub-state",
+		"nonce":                 "stub-nonce",
+		"code_challenge_method": "S256",
+	}
+	for key, value := range want {
+		if got := query.Get(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+
+	challenge := sha256.Sum256([]byte("stub-verifier"))
+	if query.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(challenge[:]) {
+		t.Errorf("code_challenge %s isn't the S256 challenge of the verifier", query.Get("code_challenge"))
+	}
+}
+
+func TestOIDCDiscovery(t *testing.T) {
+	stub := newOIDCStub(t)
+
+	provider, err := ReadOIDCProvider("stub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := provider.discover(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if stub.discoveries != 1 {
+		t.Errorf("metadata was fetched %d times, want it cached after the first", stub.discoveries)
+	}
+
+	if _, err := ReadOIDCProvider("unknown"); !errors.Is(err, OIDCErrorUnknownProvider) {
+		t.Errorf("unknown provider gave %v, want %v", err, OIDCErrorUnknownProvider)
+	}
+}
+
+func TestOIDCDiscoveryIssuerMismatch(t *testing.T) {
+	stub := newOIDCStub(t)
+	stub.mu.Lock()
+	stub.issuer = "https://impostor.example"
+	stub.mu.Unlock()
+
+	provider, err := ReadOIDCProvider("stub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.discover(); err == nil {
+		t.Fatal("metadata reporting another issuer was accepted")
+	}
+}
+
+func TestOIDCExchangeCode(t *testing.T) {
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		// idToken returns the token the stub issues, given valid claims for the login
+		idToken func(t *testing.T, stub *oidcStub, claims jwt.MapClaims) string
+		// code and verifier sent to the token endpoint, they default to the ones the stub accepts
+		code     string
+		verifier string
+		want     error
+		// rejected is true if the exchange should fail with any error
+		rejected bool
+	}{
+		{
+			name: "valid token",
+			idToken: func(t *testing.T, stub *oidcStub, claims jwt.MapClaims) string {
+				return stub.sign(t, claims)
+			},
+		},
+		{
+			name: "audience can be a list",
+			idToken: func(t *testing.T, stub *oidcStub, claims jwt.MapClaims) string {
+				claims["aud"] = []string{"another-client", "stub-client"}
+				return stub.sign(t, claims)
+			},
+		},
+		{
+			name: "verifier that doesn't match the challenge",
+			idToken: func(t *testing.T, stub *oidcStub, claims jwt.MapClaims) string {
+				return stub.sign(t, claims)
+			},
+			verifier: "another-verifier",
+			rejected: true,
+		},
+		{
+			name: "code the provider doesn't know",
+			idToken: func(t *testing.T, stub *oidcStub, claims jwt.MapClaims) string {
+				return stub.sign(t, claims)
+			},
+			code:     "bad-code",
+			rejected: true,
+		},
+		{
+			name: "nonce of another login",
+			idToken: func(t *testing.T, stub *oidcStub, claims jwt.MapClaims) string {
+				claims["nonce"] = "another-nonce"
+				return stub.sign(t, claims)
+			},
+			want: OIDCErrorInvalidIDToken,
+		},
+		{
+			name: "issued for another client",
+			idToken: func(t *testing.T, stub *oidcStub, claims jwt.MapClaims) string {
+				claims["aud"] = "another-client"
+				return stub.sign(t, claims)
+			},
+			want: OIDCErrorInvalidIDToken,
+		},
+		{
+			name: "issued by another issuer",
+			idToken: func(t *testing.T, stub *oidcStub, claims jwt.MapClaims) string {
+				claims["iss"] = "https://impostor.example"
+				return stub.sign(t, claims)
+			},
+			want: OIDCErrorInvalidIDToken,
+		},
+		{
+			name: "expired",
+			idToken: func(t *testing.T, stub *oidcStub, claims jwt.MapClaims) string {
+				claims["exp"] = time.Now().Add(-time.Minute).Unix()
+				return stub.sign(t, claims)
+			},
+			want: OIDCErrorInvalidIDToken,
+		},
+		{
+			name: "without a subject",
+			idToken: func(t *testing.T, stub *oidcStub, claims jwt.MapClaims) string {
+				delete(claims, "sub")
+				return stub.sign(t, claims)
+			},
+			want: OIDCErrorInvalidIDToken,
+		},
+		{
+			name: "key id that isn't in the key set",
+			idToken: func(t *testing.T, stub *oidcStub, claims jwt.MapClaims) string {
+				return signOIDCToken(t, stub.key, "unknown-key", claims)
+			},
+			want: OIDCErrorInvalidIDToken,
+		},
+		{
+			name: "signed by another key",
+			idToken: func(t *testing.T, stub *oidcStub, claims jwt.MapClaims) string {
+				return signOIDCToken(t, otherKey, "stub-key", claims)
+			},
+			want: OIDCErrorInvalidIDToken,
+		},
+		{
+			name: "signed with a shared secret",
+			idToken: func(t *testing.T, stub *oidcStub, claims jwt.MapClaims) string {
+				signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("stub-client"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return signed
+			},
+			want: OIDCErrorInvalidIDToken,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			stub := newOIDCStub(t)
+			provider, login, _ := stub.begin(t)
+
+			stub.mu.Lock()
+			stub.idToken = c.idToken(t, stub, stub.claims(login.Nonce))
+			stub.mu.Unlock()
+
+			code := "good-code"
+			if c.code != "" {
+				code = c.code
+			}
+			if c.verifier != "" {
+				login.CodeVerifier = c.verifier
+			}
+
+			claims, err := provider.exchangeCode(code, login)
+			switch {
+			case c.rejected:
+				if err == nil {
+					t.Fatal("exchange should have been rejected")
+				}
+			case !errors.Is(err, c.want):
+				t.Fatalf("got %v, want %v", err, c.want)
+			case err == nil && (claims.Subject != "stub-subject" || claims.Email != "student@grinds.example" || !claims.EmailVerified):
+				t.Errorf("got claims %+v", claims)
+			}
+		})
+	}
+}
+
+func TestLinkOIDCAccount(t *testing.T) {
+	verified := &Account{Email: "student@grinds.example", EmailVerified: true, Type: Student}
+	unverified := &Account{Email: "student@grinds.example", EmailVerified: false, Type: Student}
+
+	cases := []struct {
+		name          string
+		email         string
+		emailVerified bool
+		existing      *Account
+		accountType   AccountType
+		want          error
+		// linked is true if the identity should be linked to the existing account
+		linked bool
+	}{
+		{"verified email of a verified account", "student@grinds.example", true, verified, "", nil, true},
+		{"unverified email of a verified account", "student@grinds.example", false, verified, "", OIDCErrorEmailInUse, false},
+		{"verified email of an unverified account", "student@grinds.example", true, unverified, "", OIDCErrorEmailInUse, false},
+		{"new account", "student@grinds.example", true, nil, Tutor, nil, false},
+		{"new account without a type", "student@grinds.example", true, nil, "", OIDCErrorAccountTypeRequired, false},
+		{"identity without an email", "", true, nil, Student, OIDCErrorInvalidIDToken, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			claims := &oidcIDTokenClaims{Subject: "stub-subject", Email: c.email, EmailVerified: c.emailVerified}
+
+			account, err := linkOIDCAccount(claims, c.existing, c.accountType)
+			if !errors.Is(err, c.want) {
+				t.Fatalf("got %v, want %v", err, c.want)
+			}
+			if err != nil {
+				return
+			}
+
+			if c.linked != (account == c.existing) {
+				t.Errorf("linked to the existing account = %v, want %v", account == c.existing, c.linked)
+			}
+			if !c.linked && (account.Email != c.email || account.EmailVerified != c.emailVerified || account.Type != c.accountType) {
+				t.Errorf("new account %+v doesn't match the identity", account)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/cs3305-team-4/api/pkg/database"
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PasswordResetError types.
+type PasswordResetError string
+
+func (e PasswordResetError) Error() string {
+	return string(e)
+}
+
+const (
+	PasswordResetErrorInvalid PasswordResetError = "This password reset link is invalid or has expired."
+)
+
+// PasswordReset is issued when an account asks to reset its password.
+// Only a hash of the token is stored, the token itself is only ever sent to the account.
+type PasswordReset struct {
+	database.Model
+	AccountID uuid.UUID `gorm:"type:uuid;index"`
+	TokenHash string    `gorm:"uniqueIndex;not null;"`
+	ExpiresAt time.Time
+
+	// UsedAt is set once the reset has been used or replaced by a newer one
+	UsedAt *time.Time
+}
+
+func hashPasswordResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// RequestPasswordReset emails a password reset link to the account with the email, if there is one.
+// Any previously sent links stop working.
+func RequestPasswordReset(email string) error {
+	account, err := ReadAccountByEmail(email, nil)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	raw := make([]byte, 32)
+	if _, err = rand.Read(raw); err != nil {
+		return err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	db, err := database.Open()
+	if err != nil {
+		return err
+	}
+
+	reset := &PasswordReset{
+		AccountID: account.ID,
+		TokenHash: hashPasswordResetToken(token),
+		ExpiresAt: time.Now().Add(time.Duration(viper.GetInt64("auth.password_reset.ttl")) * time.Second),
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&PasswordReset{}).
+			Where("account_id = ? AND used_at IS NULL", account.ID).
+			Update("used_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(reset).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/reset-password?token=%s", viper.GetString("ui.base_url"), url.QueryEscape(token))
+	return mailer.Send(&Mail{
+		To:      account.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Someone asked to reset the password of your account. If this was you, open the following link to choose a new password:\n\n%s\n\nThis link expires at %s. If you didn't ask for this you can ignore this email.",
+			link,
+			reset.ExpiresAt.UTC().Format(time.RFC1123),
+		),
+	})
+}
+
+// ResetPassword sets the password of the account a reset token was issued to.
+// Every outstanding reset of the account stops working once one has been used.
+func ResetPassword(ctx context.Context, token string, hash *PasswordHash) (*Account, error) {
+	db, err := database.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	var account *Account
+	err = db.Transaction(func(tx *gorm.DB) error {
+		reset := &PasswordReset{}
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", hashPasswordResetToken(token)).
+			First(reset).Error
+		if err != nil {
+			return PasswordResetErrorInvalid
+		}
+
+		now := time.Now()
+		if reset.UsedAt != nil || now.After(reset.ExpiresAt) {
+			return PasswordResetErrorInvalid
+		}
+
+		err = tx.Model(&PasswordReset{}).
+			Where("account_id = ? AND used_at IS NULL", reset.AccountID).
+			Update("used_at", now).Error
+		if err != nil {
+			return err
+		}
+
+		// Whoever has the token is acting as the account
+		account, err = hash.setOnAccountByID(WithActor(ctx, reset.AccountID), tx, reset.AccountID)
+		return err
+	})
+	return account, err
+}
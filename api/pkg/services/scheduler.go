@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Job is work that is run in the background every Interval
+type Job struct {
+	Name     string
+	Interval time.Duration
+
+	// Run is given the time of the clock when the job is run
+	Run func(ctx context.Context, now time.Time) error
+}
+
+// defaultJobInterval is used for jobs that are given no interval, such as when jobs.interval isn't configured
+const defaultJobInterval = time.Minute
+
+// Scheduler runs jobs in the background of the api process
+type Scheduler struct {
+	jobs   []Job
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewScheduler returns a scheduler for the jobs, it does nothing until it is started
+func NewScheduler(jobs ...Job) *Scheduler {
+	return &Scheduler{
+		jobs: jobs,
+	}
+}
+
+// RunOnce runs every job a single time, in order
+func (s *Scheduler) RunOnce(ctx context.Context) {
+	for _, job := range s.jobs {
+		runJob(ctx, job)
+	}
+}
+
+func runJob(ctx context.Context, job Job) {
+	if err := job.Run(ctx, clock.Now()); err != nil {
+		log.WithError(err).WithField("job", job.Name).Error("Background job failed")
+	}
+}
+
+// Start runs every job on its interval until Stop is called
+func (s *Scheduler) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	for _, job := range s.jobs {
+		s.wg.Add(1)
+
+		go func(job Job) {
+			defer s.wg.Done()
+
+			interval := job.Interval
+			if interval <= 0 {
+				log.WithField("job", job.Name).Warnf("Background job has no interval, running it every %s", defaultJobInterval)
+				interval = defaultJobInterval
+			}
+
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					runJob(ctx, job)
+				}
+			}
+		}(job)
+	}
+}
+
+// Stop stops the jobs and waits for any that are running to finish
+func (s *Scheduler) Stop() {
+	if s.cancel == nil {
+		return
+	}
+
+	s.cancel()
+	s.wg.Wait()
+}
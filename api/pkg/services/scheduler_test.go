@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testClock is a clock that only moves when it is advanced
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func useTestClock(t *testing.T, now time.Time) *testClock {
+	c := &testClock{now: now}
+	SetClock(c)
+	t.Cleanup(func() { SetClock(systemClock{}) })
+	return c
+}
+
+// jobRuns records the times a job was run with
+type jobRuns struct {
+	mu    sync.Mutex
+	times []time.Time
+	ran   chan time.Time
+}
+
+func newJobRuns() *jobRuns {
+	return &jobRuns{ran: make(chan time.Time, 100)}
+}
+
+func (r *jobRuns) job(name string, interval time.Duration, err error) Job {
+	return Job{Name: name, Interval: interval, Run: func(ctx context.Context, now time.Time) error {
+		r.mu.Lock()
+		r.times = append(r.times, now)
+		r.mu.Unlock()
+
+		r.ran <- now
+		return err
+	}}
+}
+
+func (r *jobRuns) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.times)
+}
+
+func TestSchedulerRunOnce(t *testing.T) {
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	c := useTestClock(t, start)
+
+	first, second := newJobRuns(), newJobRuns()
+	scheduler := NewScheduler(
+		first.job("first", time.Minute, errors.New("a failed job doesn't stop the others")),
+		second.job("second", time.Minute, nil),
+	)
+
+	for i := 0; i < 3; i++ {
+		scheduler.RunOnce(context.Background())
+		c.Advance(time.Hour)
+	}
+
+	for _, runs := range []*jobRuns{first, second} {
+		if len(runs.times) != 3 {
+			t.Fatalf("job ran %d times, want 3", len(runs.times))
+		}
+		for i, got := range runs.times {
+			if want := start.Add(time.Duration(i) * time.Hour); !got.Equal(want) {
+				t.Errorf("run %d was given %s, want the clock time %s", i, got, want)
+			}
+		}
+	}
+}
+
+func TestSchedulerStart(t *testing.T) {
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	c := useTestClock(t, start)
+
+	runs := newJobRuns()
+	scheduler := NewScheduler(runs.job("ticking", 5*time.Millisecond, nil))
+	scheduler.Start()
+	defer scheduler.Stop()
+
+	waitForRun := func(want time.Time) {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case got := <-runs.ran:
+				if got.Equal(want) {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("job wasn't run with the clock time %s", want)
+			}
+		}
+	}
+
+	waitForRun(start)
+	c.Advance(24 * time.Hour)
+	waitForRun(start.Add(24 * time.Hour))
+
+	scheduler.Stop()
+	stopped := runs.count()
+	time.Sleep(20 * time.Millisecond)
+	if runs.count() != stopped {
+		t.Error("job kept running after the scheduler was stopped")
+	}
+}
+
+func TestSchedulerWithoutInterval(t *testing.T) {
+	runs := newJobRuns()
+	scheduler := NewScheduler(runs.job("unconfigured", 0, nil))
+
+	// A ticker panics on a zero interval, the scheduler falls back to the default instead
+	scheduler.Start()
+	scheduler.Stop()
+
+	if runs.count() != 0 {
+		t.Errorf("job ran %d times before its default interval passed", runs.count())
+	}
+}
+
+func TestSchedulerStopBeforeStart(t *testing.T) {
+	NewScheduler().Stop()
+}
@@ -255,12 +255,17 @@ func SeedDatabase() error {
 		return err
 	}
 
-	accList := stripeAccount.List(nil)
+	// Existing Stripe accounts are reused so reseeding doesn't create duplicates on Stripe
+	_, usingStripe := payments.(*StripePaymentProvider)
+
 	var emailToStripeConnectAccID map[string]string
 	emailToStripeConnectAccID = map[string]string{}
-	for accList.Next() {
-		a := accList.Account()
-		emailToStripeConnectAccID[a.Email] = a.ID
+	if usingStripe {
+		accList := stripeAccount.List(nil)
+		for accList.Next() {
+			a := accList.Account()
+			emailToStripeConnectAccID[a.Email] = a.ID
+		}
 	}
 
 	// Setting a fixed seed so that seeding is derterministic
@@ -323,14 +328,16 @@ func SeedDatabase() error {
 			Suspended:     false,
 			PasswordHash:  *hash,
 		}
-		custList := stripeCustomer.List(&stripe.CustomerListParams{
-			Email: &student.Email,
-		})
-
-		for custList.Next() {
-			c := custList.Customer()
-			if c.Email == student.Email {
-				student.StripeID = c.ID
+		if usingStripe {
+			custList := stripeCustomer.List(&stripe.CustomerListParams{
+				Email: &student.Email,
+			})
+
+			for custList.Next() {
+				c := custList.Customer()
+				if c.Email == student.Email {
+					student.StripeID = c.ID
+				}
 			}
 		}
 		RandomizeProfile(&student)
@@ -383,14 +390,16 @@ func SeedDatabase() error {
 
 		account := CreateRandomAccountWithID(id, i, hash)
 		if account.Type == Student {
-			custList := stripeCustomer.List(&stripe.CustomerListParams{
-				Email: &account.Email,
-			})
+			if usingStripe {
+				custList := stripeCustomer.List(&stripe.CustomerListParams{
+					Email: &account.Email,
+				})
 
-			for custList.Next() {
-				c := custList.Customer()
-				if c.Email == account.Email {
-					account.StripeID = c.ID
+				for custList.Next() {
+					c := custList.Customer()
+					if c.Email == account.Email {
+						account.StripeID = c.ID
+					}
 				}
 			}
 
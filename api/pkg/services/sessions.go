@@ -0,0 +1,220 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"github.com/cs3305-team-4/api/pkg/database"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SessionError types.
+type SessionError string
+
+func (e SessionError) Error() string {
+	return string(e)
+}
+
+const (
+	SessionErrorInvalid SessionError = "This session is invalid or has expired, please log in again."
+)
+
+// Session is created every time an account logs in. Access tokens carry the session ID as their jti so they
+// stop working once the session is revoked, and the refresh token is rotated every time it is used.
+type Session struct {
+	database.Model
+	AccountID        uuid.UUID `gorm:"type:uuid;index"`
+	RefreshTokenHash string    `gorm:"uniqueIndex;not null;"`
+
+	// PreviousTokenHash is the refresh token that was last rotated out, if it is presented again the
+	// refresh token has been stolen and the session is revoked
+	PreviousTokenHash string `gorm:"index"`
+
+	// TwoFactorVerified is set if a TOTP or recovery code was given when the session was started
+	TwoFactorVerified bool
+
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+}
+
+// Active returns true if the session can still be used
+func (s *Session) Active() bool {
+	return s.RevokedAt == nil && time.Now().Before(s.ExpiresAt)
+}
+
+func newRefreshToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func sessionExpiry() time.Time {
+	return time.Now().Add(time.Duration(viper.GetInt64("auth.session.ttl")) * time.Second)
+}
+
+// CreateSession starts a session for the account, returning it along with its refresh token
+func CreateSession(accountID uuid.UUID, twoFactorVerified bool) (*Session, string, error) {
+	db, err := database.Open()
+	if err != nil {
+		return nil, "", err
+	}
+
+	token, err := newRefreshToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	session := &Session{
+		AccountID:         accountID,
+		RefreshTokenHash:  hashRefreshToken(token),
+		ExpiresAt:         sessionExpiry(),
+		TwoFactorVerified: twoFactorVerified,
+	}
+	return session, token, db.Create(session).Error
+}
+
+// ReadActiveSessionByID returns a session if it hasn't been revoked or expired
+func ReadActiveSessionByID(id uuid.UUID) (*Session, error) {
+	db, err := database.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	session := &Session{}
+	if err = db.First(session, id).Error; err != nil {
+		return nil, SessionErrorInvalid
+	}
+
+	if !session.Active() {
+		return nil, SessionErrorInvalid
+	}
+
+	return session, nil
+}
+
+// RefreshSession swaps a refresh token for a new one, extending the session.
+// Presenting a refresh token that has already been swapped revokes the session.
+func RefreshSession(token string) (*Session, string, error) {
+	db, err := database.Open()
+	if err != nil {
+		return nil, "", err
+	}
+
+	next, err := newRefreshToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	hash := hashRefreshToken(token)
+	session := &Session{}
+	reused := false
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("refresh_token_hash = ? OR previous_token_hash = ?", hash, hash).
+			First(session).Error
+		if err != nil {
+			return SessionErrorInvalid
+		}
+
+		if !session.Active() {
+			return SessionErrorInvalid
+		}
+
+		if session.RefreshTokenHash != hash {
+			reused = true
+			return tx.Model(session).Update("revoked_at", time.Now()).Error
+		}
+
+		return tx.Model(session).Updates(map[string]interface{}{
+			"refresh_token_hash":  hashRefreshToken(next),
+			"previous_token_hash": hash,
+			"expires_at":          sessionExpiry(),
+		}).Error
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	if reused {
+		log.Warnf("refresh token of session %s was reused, the session has been revoked", session.ID)
+		return nil, "", SessionErrorInvalid
+	}
+
+	return session, next, nil
+}
+
+// RevokeSession stops a session and every access token issued for it from working
+func RevokeSession(id uuid.UUID) error {
+	db, err := database.Open()
+	if err != nil {
+		return err
+	}
+
+	return db.Model(&Session{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now()).Error
+}
+
+// MarkSessionTwoFactorVerified records that a TOTP code was given during a session
+func MarkSessionTwoFactorVerified(id uuid.UUID) error {
+	db, err := database.Open()
+	if err != nil {
+		return err
+	}
+
+	return db.Model(&Session{}).Where("id = ?", id).Update("two_factor_verified", true).Error
+}
+
+// revokeAccountSessions revokes every session of an account, logging it out everywhere
+func revokeAccountSessions(tx *gorm.DB, accountID uuid.UUID) error {
+	return tx.Model(&Session{}).
+		Where("account_id = ? AND revoked_at IS NULL", accountID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// SetAccountSuspended suspends or unsuspends an account, suspending it also logs it out everywhere
+func SetAccountSuspended(ctx context.Context, id uuid.UUID, suspended bool) (*Account, error) {
+	db, err := database.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	account := &Account{}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		account, err = ReadAccountByID(id, tx)
+		if err != nil {
+			return err
+		}
+
+		before := map[string]interface{}{"suspended": account.Suspended}
+		if err = tx.Model(account).Update("suspended", suspended).Error; err != nil {
+			return err
+		}
+
+		err = recordAuditEvent(ctx, tx, AuditAccountSuspended, nil, AuditTargetAccount, id, before, map[string]interface{}{"suspended": suspended})
+		if err != nil {
+			return err
+		}
+
+		if suspended {
+			return revokeAccountSessions(tx, id)
+		}
+		return nil
+	})
+	return account, err
+}
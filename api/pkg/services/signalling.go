@@ -4,6 +4,7 @@ import (
 	"crypto"
 	"crypto/hmac"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"sync"
 	"time"
@@ -18,11 +19,133 @@ var classrooms = Classrooms{
 	classrooms: map[string]*Classroom{},
 }
 
+// SignallingError types.
+type SignallingError string
+
+func (e SignallingError) Error() string {
+	return string(e)
+}
+
+const (
+	SignallingErrorNotParticipant SignallingError = "Only the student and tutor of a lesson can join its classroom."
+	SignallingErrorNotScheduled   SignallingError = "A classroom can only be joined for a scheduled lesson."
+	SignallingErrorClosed         SignallingError = "The classroom for this lesson is not open at this time."
+	SignallingErrorNotSeated      SignallingError = "Only the tutor and the students who paid for a seat can join the classroom of a group session."
+	SignallingErrorNotConfirmed   SignallingError = "A classroom can only be joined for a confirmed group session."
+)
+
+// CanJoinClassroom checks if an account is allowed to join the classroom of a lesson at the specified time.
+// The classroom opens signalling.join_before minutes before the lesson starts and closes signalling.join_after minutes after it ends.
+func (l *Lesson) CanJoinClassroom(acc *Account, at time.Time) error {
+	if acc.ID != l.StudentID && acc.ID != l.TutorID {
+		return SignallingErrorNotParticipant
+	}
+
+	if l.RequestStage != Scheduled {
+		return SignallingErrorNotScheduled
+	}
+
+	return checkClassroomOpen(l.StartTime, l.EndTime, at)
+}
+
+// CanJoinClassroom checks if an account is allowed to join the classroom of a group session at the specified time.
+// Only the tutor and students with a paid seat they haven't given up can join.
+func (g *GroupSession) CanJoinClassroom(acc *Account, at time.Time) error {
+	if acc.ID != g.TutorID {
+		seat := g.SeatOf(acc.ID)
+		if seat == nil || !seat.Paid || seat.Refunded {
+			return SignallingErrorNotSeated
+		}
+	}
+
+	if g.Stage != GroupSessionConfirmed {
+		return SignallingErrorNotConfirmed
+	}
+
+	return checkClassroomOpen(g.StartTime, g.EndTime, at)
+}
+
+// checkClassroomOpen returns SignallingErrorClosed unless at is between signalling.join_before minutes before start and
+// signalling.join_after minutes after end
+func checkClassroomOpen(start time.Time, end time.Time, at time.Time) error {
+	opens := start.Add(-time.Duration(viper.GetInt("signalling.join_before")) * time.Minute)
+	closes := end.Add(time.Duration(viper.GetInt("signalling.join_after")) * time.Minute)
+	if at.Before(opens) || at.After(closes) {
+		return SignallingErrorClosed
+	}
+
+	return nil
+}
+
 type Credentials struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 }
 
+// SignallingMessageType is the type of a message sent through a classroom
+type SignallingMessageType string
+
+const (
+	// Sent by the server to the rest of the classroom when a peer connects
+	SignallingJoin SignallingMessageType = "join"
+
+	// Sent by the server to the rest of the classroom when a peer disconnects, or by a peer that hangs up while staying connected
+	SignallingLeave SignallingMessageType = "leave"
+
+	// Sent by a peer when it enters the call, peers already in the call connect to it
+	SignallingReady SignallingMessageType = "ready"
+
+	// WebRTC session descriptions and candidates, relayed between peers
+	SignallingOffer        SignallingMessageType = "offer"
+	SignallingAnswer       SignallingMessageType = "answer"
+	SignallingICECandidate SignallingMessageType = "ice-candidate"
+
+	// Asks the peer sharing its screen to stop, so another peer can share theirs
+	SignallingStopStream SignallingMessageType = "stop-stream"
+
+	// Whiteboard changes, relayed between peers. SignallingWhiteboard carries the whole whiteboard to a peer that entered the call
+	SignallingDraw       SignallingMessageType = "draw"
+	SignallingWipe       SignallingMessageType = "wipe"
+	SignallingUndo       SignallingMessageType = "undo"
+	SignallingBackground SignallingMessageType = "change-background"
+	SignallingWhiteboard SignallingMessageType = "whiteboard"
+
+	// Chat messages, the data is the text of the message. They are stored and replayed to peers when they join
+	SignallingChat SignallingMessageType = "chat"
+
+	// Sent by the server with the peers in the classroom, when a peer connects or when a peer asks for it
+	SignallingPresence SignallingMessageType = "presence"
+
+	// Sent by the server to a peer when a message it sent was rejected
+	SignallingRejected SignallingMessageType = "error"
+)
+
+// SignallingMessage is the envelope of every message sent through a classroom.
+// From and AccountID are always set by the server, any values sent by a peer are overwritten.
+type SignallingMessage struct {
+	Type SignallingMessageType `json:"type"`
+
+	// From is the peer ID of the sender, it is empty for messages sent by the server
+	From *uuid.UUID `json:"from,omitempty"`
+
+	// AccountID of the sender
+	AccountID *uuid.UUID `json:"account_id,omitempty"`
+
+	// To is the peer ID of the only peer that should receive the message, if empty the message is sent to every other peer
+	To *uuid.UUID `json:"to,omitempty"`
+
+	Data json.RawMessage `json:"data,omitempty"`
+
+	// SentAt is set by the server on chat messages
+	SentAt *time.Time `json:"sent_at,omitempty"`
+}
+
+// SignallingPeer describes a peer in a classroom
+type SignallingPeer struct {
+	PeerID    uuid.UUID `json:"peer_id"`
+	AccountID uuid.UUID `json:"account_id"`
+}
+
 // Classrooms ...
 type Classrooms struct {
 	classrooms map[string]*Classroom
@@ -31,60 +154,327 @@ type Classrooms struct {
 
 // Classroom ...
 type Classroom struct {
-	Code    string
-	Members []*websocket.Conn
-	mu      sync.Mutex
+	Code string
+
+	// LessonID is the lesson or group session the classroom is for, chat messages are stored against it
+	LessonID uuid.UUID
+	Members  []*ClassroomMember
+	mu       sync.Mutex
+}
+
+// Time allowed to read the next pong from a member before it is considered dead, it is a variable so tests can
+// shorten it
+var signallingPongWait = 60 * time.Second
+
+const (
+	// Time allowed to write a message to a member
+	signallingWriteWait = 10 * time.Second
+
+	// Largest message a member can send, SDP offers are the largest messages
+	signallingMaxMessageSize = 65536
+
+	// Messages that can be queued for a member before it is disconnected as a slow consumer
+	signallingSendQueueSize = 64
+)
+
+// ClassroomMember is a single connection to a classroom, an account connected twice is two members with different peer IDs.
+// Messages to a member are queued and written by its own writer goroutine, so a slow member can't block the classroom.
+type ClassroomMember struct {
+	PeerID    uuid.UUID
+	AccountID uuid.UUID
+	conn      *websocket.Conn
+
+	// send is the queue of messages waiting to be written
+	send chan []byte
+
+	// done is closed when the member is disconnected
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newClassroomMember(ws *websocket.Conn, account *Account) *ClassroomMember {
+	return &ClassroomMember{
+		PeerID:    uuid.New(),
+		AccountID: account.ID,
+		conn:      ws,
+		send:      make(chan []byte, signallingSendQueueSize),
+		done:      make(chan struct{}),
+	}
+}
+
+// enqueue queues a message to be written to the member without blocking.
+// If the queue is full the member isn't keeping up and is disconnected.
+func (m *ClassroomMember) enqueue(message *SignallingMessage) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	select {
+	case <-m.done:
+	case m.send <- data:
+	default:
+		log.Warnf("disconnecting %s, send queue is full", m.PeerID)
+		m.close()
+	}
+}
+
+// close disconnects the member, it is safe to call more than once
+func (m *ClassroomMember) close() {
+	m.closeOnce.Do(func() {
+		close(m.done)
+		m.conn.Close()
+	})
+}
+
+// writePump writes queued messages and pings to the member until it is disconnected. Pings are sent with the period,
+// which must be less than signallingPongWait.
+func (m *ClassroomMember) writePump(pingPeriod time.Duration) {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		m.close()
+	}()
+
+	for {
+		select {
+		case <-m.done:
+			return
+
+		case data := <-m.send:
+			m.conn.SetWriteDeadline(time.Now().Add(signallingWriteWait))
+			if err := m.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				log.Error(err)
+				return
+			}
+
+		case <-ticker.C:
+			m.conn.SetWriteDeadline(time.Now().Add(signallingWriteWait))
+			if err := m.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
 }
 
-func SignallingAddToClassroom(ws *websocket.Conn, classroomId string) {
+// SignallingAddToClassroom adds the connection to the classroom of the lesson or group session and handles its
+// messages until it disconnects
+func SignallingAddToClassroom(ws *websocket.Conn, lessonID uuid.UUID, account *Account) {
+	member := newClassroomMember(ws, account)
+	go member.writePump((signallingPongWait * 9) / 10)
+
+	classroomId := lessonID.String()
 	classrooms.mu.Lock()
 	if _, ok := classrooms.classrooms[classroomId]; !ok {
 		log.Infof("Creating Classroom: %s", classroomId)
 		classrooms.classrooms[classroomId] = &Classroom{
-			Code:    classroomId,
-			Members: []*websocket.Conn{},
+			Code:     classroomId,
+			LessonID: lessonID,
+			Members:  []*ClassroomMember{},
 		}
 	}
-	log.Infof("%s connecting to %s", ws.RemoteAddr(), classroomId)
+	log.Infof("%s connecting to %s as %s", ws.RemoteAddr(), classroomId, member.PeerID)
 	classroom := classrooms.classrooms[classroomId]
-	classroom.Members = append(classroom.Members, ws)
+	classroom.mu.Lock()
+	classroom.Members = append(classroom.Members, member)
+	classroom.mu.Unlock()
 	classrooms.mu.Unlock()
-	messageHandler(ws, classroom)
+
+	classroom.sendPresence(member)
+	classroom.sendChatHistory(member)
+	classroom.broadcast(member, &SignallingMessage{Type: SignallingJoin})
+
+	messageHandler(member, classroom)
 }
 
-func messageHandler(conn *websocket.Conn, class *Classroom) {
+func messageHandler(member *ClassroomMember, class *Classroom) {
+	defer func() {
+		// Disconnected
+		class.remove(member)
+		class.broadcast(member, &SignallingMessage{Type: SignallingLeave})
+	}()
+
+	member.conn.SetReadLimit(signallingMaxMessageSize)
+	member.conn.SetReadDeadline(time.Now().Add(signallingPongWait))
+	member.conn.SetPongHandler(func(string) error {
+		return member.conn.SetReadDeadline(time.Now().Add(signallingPongWait))
+	})
+
 	for {
-		messageType, message, err := conn.ReadMessage()
+		_, raw, err := member.conn.ReadMessage()
 		if err != nil {
-			// Disconnected
-			log.Error(err)
-			class.mu.Lock()
-			for i, member := range class.Members {
-				if member == conn {
-					class.Members = append(class.Members[:i], class.Members[i+1:]...)
-					// Remove Class when all members have disconnected
-					if len(class.Members) == 0 {
-						log.Infof("Deleting Classroom: %s", class.Code)
-						classrooms.mu.Lock()
-						delete(classrooms.classrooms, class.Code)
-						classrooms.mu.Unlock()
-					}
-					class.mu.Unlock()
-					return
-				}
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Error(err)
 			}
+			return
 		}
 
-		// Send received message to all other members of the class
-		class.mu.Lock()
-		for _, c := range class.Members {
-			if conn == c {
+		var message SignallingMessage
+		if err = json.Unmarshal(raw, &message); err != nil {
+			class.reject(member, "messages must be a json object")
+			continue
+		}
+
+		switch message.Type {
+		case SignallingChat:
+			if !class.saveChat(member, &message) {
 				continue
 			}
-			c.WriteMessage(messageType, message)
+			fallthrough
+
+		case SignallingOffer, SignallingAnswer, SignallingICECandidate, SignallingReady, SignallingLeave, SignallingStopStream,
+			SignallingDraw, SignallingWipe, SignallingUndo, SignallingBackground, SignallingWhiteboard:
+			if message.To != nil {
+				class.direct(member, *message.To, &message)
+			} else {
+				class.broadcast(member, &message)
+			}
+
+		case SignallingPresence:
+			class.sendPresence(member)
+
+		default:
+			class.reject(member, fmt.Sprintf("unsupported message type %q", message.Type))
+		}
+	}
+}
+
+// remove takes the member out of the classroom, deleting the classroom when it's empty
+func (class *Classroom) remove(member *ClassroomMember) {
+	classrooms.mu.Lock()
+	defer classrooms.mu.Unlock()
+	class.mu.Lock()
+	defer class.mu.Unlock()
+
+	for i, m := range class.Members {
+		if m == member {
+			class.Members = append(class.Members[:i], class.Members[i+1:]...)
+			break
 		}
-		class.mu.Unlock()
 	}
+
+	// Remove Class when all members have disconnected
+	if len(class.Members) == 0 {
+		log.Infof("Deleting Classroom: %s", class.Code)
+		delete(classrooms.classrooms, class.Code)
+	}
+	member.close()
+}
+
+// stamp sets the sender of a message
+func stamp(from *ClassroomMember, message *SignallingMessage) {
+	message.From = &from.PeerID
+	message.AccountID = &from.AccountID
+}
+
+// broadcast sends a message from a member to every other member of the classroom
+func (class *Classroom) broadcast(from *ClassroomMember, message *SignallingMessage) {
+	stamp(from, message)
+	message.To = nil
+
+	class.mu.Lock()
+	defer class.mu.Unlock()
+	for _, m := range class.Members {
+		if m == from {
+			continue
+		}
+		m.enqueue(message)
+	}
+}
+
+// direct sends a message from a member to a single peer in the classroom
+func (class *Classroom) direct(from *ClassroomMember, to uuid.UUID, message *SignallingMessage) {
+	stamp(from, message)
+
+	class.mu.Lock()
+	for _, m := range class.Members {
+		if m.PeerID == to && m != from {
+			m.enqueue(message)
+			class.mu.Unlock()
+			return
+		}
+	}
+	class.mu.Unlock()
+
+	class.reject(from, fmt.Sprintf("peer %s is not in this classroom", to))
+}
+
+// sendPresence sends a member the peers in the classroom, including itself
+func (class *Classroom) sendPresence(to *ClassroomMember) {
+	class.mu.Lock()
+	peers := []SignallingPeer{}
+	for _, m := range class.Members {
+		peers = append(peers, SignallingPeer{
+			PeerID:    m.PeerID,
+			AccountID: m.AccountID,
+		})
+	}
+	class.mu.Unlock()
+
+	data, err := json.Marshal(peers)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	to.enqueue(&SignallingMessage{Type: SignallingPresence, Data: data})
+}
+
+// saveChat stores a chat message from a member and stamps it with the time it was sent, returns false if it was rejected
+func (class *Classroom) saveChat(from *ClassroomMember, message *SignallingMessage) bool {
+	var body string
+	if err := json.Unmarshal(message.Data, &body); err != nil || body == "" {
+		class.reject(from, "chat messages must have text data")
+		return false
+	}
+
+	saved, err := lessonMessages.Create(class.LessonID, from.AccountID, body)
+	if err != nil {
+		log.Error(err)
+		class.reject(from, "chat message could not be saved")
+		return false
+	}
+
+	message.SentAt = &saved.CreatedAt
+	return true
+}
+
+// sendChatHistory replays the latest chat messages of the lesson to a member
+func (class *Classroom) sendChatHistory(to *ClassroomMember) {
+	messages, err := lessonMessages.ReadRecent(class.LessonID, viper.GetInt("signalling.chat_history"))
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	for _, m := range messages {
+		data, err := json.Marshal(m.Body)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+
+		senderID := m.SenderID
+		sentAt := m.CreatedAt
+		to.enqueue(&SignallingMessage{
+			Type:      SignallingChat,
+			AccountID: &senderID,
+			Data:      data,
+			SentAt:    &sentAt,
+		})
+	}
+}
+
+// reject tells a member that a message it sent was not delivered
+func (class *Classroom) reject(to *ClassroomMember, reason string) {
+	data, err := json.Marshal(reason)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	to.enqueue(&SignallingMessage{Type: SignallingRejected, Data: data})
 }
 
 func GenerateTURNCredentials(id uuid.UUID) Credentials {
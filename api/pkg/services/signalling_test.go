@@ -0,0 +1,484 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cs3305-team-4/api/pkg/database"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/spf13/viper"
+)
+
+// memoryLessonMessageStore keeps classroom chat in memory
+type memoryLessonMessageStore struct {
+	messages []LessonMessage
+	mu       sync.Mutex
+}
+
+func (s *memoryLessonMessageStore) Create(lessonID uuid.UUID, senderID uuid.UUID, body string) (*LessonMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message := LessonMessage{
+		Model:    database.Model{ID: uuid.New(), CreatedAt: time.Now()},
+		LessonID: lessonID,
+		SenderID: senderID,
+		Body:     body,
+	}
+	s.messages = append(s.messages, message)
+	return &message, nil
+}
+
+func (s *memoryLessonMessageStore) ReadRecent(lessonID uuid.UUID, limit int) ([]LessonMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages := []LessonMessage{}
+	for _, m := range s.messages {
+		if m.LessonID == lessonID {
+			messages = append(messages, m)
+		}
+	}
+	if len(messages) > limit {
+		messages = messages[len(messages)-limit:]
+	}
+	return messages, nil
+}
+
+// classroomServer adds every websocket connection to the classroom and account given in its query
+type classroomServer struct {
+	*httptest.Server
+	handlers sync.WaitGroup
+}
+
+func newClassroomServer(t *testing.T) *classroomServer {
+	SetLessonMessageStore(&memoryLessonMessageStore{})
+	viper.Set("signalling.chat_history", 2)
+
+	s := &classroomServer{}
+	upgrader := websocket.Upgrader{}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.handlers.Add(1)
+		defer s.handlers.Done()
+
+		classroomID := uuid.MustParse(r.URL.Query().Get("classroom"))
+		account := &Account{Model: database.Model{ID: uuid.MustParse(r.URL.Query().Get("account"))}}
+
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		SignallingAddToClassroom(ws, classroomID, account)
+	}))
+
+	// The classroom handlers are waited for as hijacked connections aren't tracked by the test server
+	t.Cleanup(func() {
+		s.Close()
+		s.handlers.Wait()
+		SetLessonMessageStore(databaseLessonMessageStore{})
+		viper.Set("signalling.chat_history", nil)
+	})
+	return s
+}
+
+// testPeer is a client connected to a classroom
+type testPeer struct {
+	t           *testing.T
+	conn        *websocket.Conn
+	classroomID uuid.UUID
+	peerID      uuid.UUID
+	accountID   uuid.UUID
+}
+
+// join connects a new account to the classroom and learns its peer ID from the presence it is sent
+func (s *classroomServer) join(t *testing.T, classroomID uuid.UUID) *testPeer {
+	p := &testPeer{t: t, classroomID: classroomID, accountID: uuid.New()}
+
+	url := "ws" + strings.TrimPrefix(s.URL, "http") + "/?classroom=" + classroomID.String() + "&account=" + p.accountID.String()
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.conn = conn
+	t.Cleanup(func() { conn.Close() })
+
+	presence := p.read()
+	if presence.Type != SignallingPresence {
+		t.Fatalf("first message was %s, want presence", presence.Type)
+	}
+
+	var peers []SignallingPeer
+	if err := json.Unmarshal(presence.Data, &peers); err != nil {
+		t.Fatal(err)
+	}
+	for _, peer := range peers {
+		if peer.AccountID == p.accountID {
+			p.peerID = peer.PeerID
+		}
+	}
+	if p.peerID == uuid.Nil {
+		t.Fatalf("presence %s doesn't include the peer", presence.Data)
+	}
+
+	return p
+}
+
+// joinAll connects count peers to a new classroom, reading the joins of the peers that come after each one
+func (s *classroomServer) joinAll(t *testing.T, count int) []*testPeer {
+	classroomID := uuid.New()
+
+	peers := []*testPeer{}
+	for i := 0; i < count; i++ {
+		joined := s.join(t, classroomID)
+		for _, p := range peers {
+			p.expect(SignallingJoin, joined)
+		}
+		peers = append(peers, joined)
+	}
+	return peers
+}
+
+func (p *testPeer) send(raw string) {
+	if err := p.conn.WriteMessage(websocket.TextMessage, []byte(raw)); err != nil {
+		p.t.Fatal(err)
+	}
+}
+
+func (p *testPeer) read() *SignallingMessage {
+	p.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	message := &SignallingMessage{}
+	if err := p.conn.ReadJSON(message); err != nil {
+		p.t.Fatal(err)
+	}
+	return message
+}
+
+// expect reads the next message and checks it is of the type and was sent by the peer, or the server if from is nil
+func (p *testPeer) expect(messageType SignallingMessageType, from *testPeer) *SignallingMessage {
+	message := p.read()
+	if message.Type != messageType {
+		p.t.Fatalf("got %s message %s, want %s", message.Type, message.Data, messageType)
+	}
+
+	switch {
+	case from == nil && message.From != nil:
+		p.t.Errorf("%s message from %s, want the server", messageType, message.From)
+	case from != nil && (message.From == nil || *message.From != from.peerID):
+		p.t.Errorf("%s message from %v, want %s", messageType, message.From, from.peerID)
+	case from != nil && (message.AccountID == nil || *message.AccountID != from.accountID):
+		p.t.Errorf("%s message from account %v, want %s", messageType, message.AccountID, from.accountID)
+	}
+	return message
+}
+
+const signallingSentinel = `{"type": "draw", "data": "sentinel"}`
+
+// expectSentinel checks the next message is the sentinel, so nothing was delivered before it
+func (p *testPeer) expectSentinel(from *testPeer) {
+	message := p.expect(SignallingDraw, from)
+	if string(message.Data) != `"sentinel"` {
+		p.t.Fatalf("got draw %s, want the sentinel", message.Data)
+	}
+}
+
+func TestSignallingRelay(t *testing.T) {
+	server := newClassroomServer(t)
+
+	cases := []struct {
+		name string
+		// message is the raw message the first peer sends, given the peers of the classroom
+		message func(peers []*testPeer) string
+		// reply is the type the server should send back to the sender, if any
+		reply SignallingMessageType
+		// delivered is the type each of the other peers should receive, if any
+		delivered []SignallingMessageType
+	}{
+		{
+			name: "offer is broadcast with the sender stamped over a spoofed one",
+			message: func(peers []*testPeer) string {
+				return `{"type": "offer", "from": "` + uuid.New().String() + `", "account_id": "` + uuid.New().String() + `", "data": {"sdp": "v=0"}}`
+			},
+			delivered: []SignallingMessageType{SignallingOffer, SignallingOffer},
+		},
+		{
+			name: "ice candidate is only sent to the peer it is for",
+			message: func(peers []*testPeer) string {
+				return `{"type": "ice-candidate", "to": "` + peers[1].peerID.String() + `", "data": {"candidate": "c"}}`
+			},
+			delivered: []SignallingMessageType{SignallingICECandidate, ""},
+		},
+		{
+			name: "ready is broadcast",
+			message: func(peers []*testPeer) string {
+				return `{"type": "ready"}`
+			},
+			delivered: []SignallingMessageType{SignallingReady, SignallingReady},
+		},
+		{
+			name: "whiteboard is only sent to the peer it is for",
+			message: func(peers []*testPeer) string {
+				return `{"type": "whiteboard", "to": "` + peers[2].peerID.String() + `", "data": []}`
+			},
+			delivered: []SignallingMessageType{"", SignallingWhiteboard},
+		},
+		{
+			name: "background change is broadcast",
+			message: func(peers []*testPeer) string {
+				return `{"type": "change-background", "data": "#fff"}`
+			},
+			delivered: []SignallingMessageType{SignallingBackground, SignallingBackground},
+		},
+		{
+			name: "chat is broadcast",
+			message: func(peers []*testPeer) string {
+				return `{"type": "chat", "data": "hello"}`
+			},
+			delivered: []SignallingMessageType{SignallingChat, SignallingChat},
+		},
+		{
+			name: "chat without text is rejected",
+			message: func(peers []*testPeer) string {
+				return `{"type": "chat", "data": ""}`
+			},
+			reply:     SignallingRejected,
+			delivered: []SignallingMessageType{"", ""},
+		},
+		{
+			name: "unknown type is rejected",
+			message: func(peers []*testPeer) string {
+				return `{"type": "shout", "data": "hello"}`
+			},
+			reply:     SignallingRejected,
+			delivered: []SignallingMessageType{"", ""},
+		},
+		{
+			name: "message to a peer that isn't in the classroom is rejected",
+			message: func(peers []*testPeer) string {
+				return `{"type": "answer", "to": "` + uuid.New().String() + `", "data": {"sdp": "v=0"}}`
+			},
+			reply:     SignallingRejected,
+			delivered: []SignallingMessageType{"", ""},
+		},
+		{
+			name: "message to itself is rejected",
+			message: func(peers []*testPeer) string {
+				return `{"type": "offer", "to": "` + peers[0].peerID.String() + `", "data": {"sdp": "v=0"}}`
+			},
+			reply:     SignallingRejected,
+			delivered: []SignallingMessageType{"", ""},
+		},
+		{
+			name: "message that isn't json is rejected",
+			message: func(peers []*testPeer) string {
+				return `offer`
+			},
+			reply:     SignallingRejected,
+			delivered: []SignallingMessageType{"", ""},
+		},
+		{
+			name: "presence is sent back to the sender",
+			message: func(peers []*testPeer) string {
+				return `{"type": "presence"}`
+			},
+			reply:     SignallingPresence,
+			delivered: []SignallingMessageType{"", ""},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			peers := server.joinAll(t, 3)
+			sender := peers[0]
+
+			sender.send(c.message(peers))
+			sender.send(signallingSentinel)
+
+			if c.reply != "" {
+				sender.expect(c.reply, nil)
+			}
+
+			for i, messageType := range c.delivered {
+				receiver := peers[i+1]
+				if messageType != "" {
+					message := receiver.expect(messageType, sender)
+					if message.To != nil && *message.To != receiver.peerID {
+						t.Errorf("peer %d got a message to %s", i+1, message.To)
+					}
+					if messageType == SignallingChat && message.SentAt == nil {
+						t.Errorf("peer %d got a chat message without the time it was sent", i+1)
+					}
+				}
+				receiver.expectSentinel(sender)
+			}
+		})
+	}
+}
+
+func TestSignallingChatReplayedOnJoin(t *testing.T) {
+	server := newClassroomServer(t)
+
+	classroomID := uuid.New()
+	first := server.join(t, classroomID)
+	for _, body := range []string{"one", "two", "three"} {
+		first.send(`{"type": "chat", "data": "` + body + `"}`)
+	}
+	// Messages are handled in order, so the chat has been stored once presence comes back
+	first.send(`{"type": "presence"}`)
+	first.expect(SignallingPresence, nil)
+
+	second := server.join(t, classroomID)
+	for _, want := range []string{`"two"`, `"three"`} {
+		message := second.expect(SignallingChat, nil)
+		if string(message.Data) != want {
+			t.Errorf("replayed chat %s, want %s", message.Data, want)
+		}
+		if message.AccountID == nil || *message.AccountID != first.accountID {
+			t.Errorf("replayed chat from account %v, want %s", message.AccountID, first.accountID)
+		}
+	}
+	first.expect(SignallingJoin, second)
+}
+
+func TestSignallingPresence(t *testing.T) {
+	server := newClassroomServer(t)
+	peers := server.joinAll(t, 3)
+
+	peers[0].send(`{"type": "presence"}`)
+	message := peers[0].expect(SignallingPresence, nil)
+
+	var got []SignallingPeer
+	if err := json.Unmarshal(message.Data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(peers) {
+		t.Fatalf("presence has %d peers, want %d", len(got), len(peers))
+	}
+	for i, p := range peers {
+		if got[i].PeerID != p.peerID || got[i].AccountID != p.accountID {
+			t.Errorf("presence peer %d is %v, want %s of %s", i, got[i], p.peerID, p.accountID)
+		}
+	}
+}
+
+func TestSignallingLeave(t *testing.T) {
+	server := newClassroomServer(t)
+	peers := server.joinAll(t, 3)
+
+	peers[1].conn.Close()
+	peers[0].expect(SignallingLeave, peers[1])
+	peers[2].expect(SignallingLeave, peers[1])
+
+	// A peer can also hang up while staying connected
+	peers[2].send(`{"type": "leave"}`)
+	peers[0].expect(SignallingLeave, peers[2])
+
+	peers[0].send(`{"type": "presence"}`)
+	message := peers[0].expect(SignallingPresence, nil)
+
+	var got []SignallingPeer
+	if err := json.Unmarshal(message.Data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("presence has %d peers after one disconnected, want 2", len(got))
+	}
+}
+
+func TestSignallingClassroomDeletedWhenEmpty(t *testing.T) {
+	server := newClassroomServer(t)
+
+	classroomID := uuid.New()
+	peer := server.join(t, classroomID)
+	peer.conn.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		classrooms.mu.Lock()
+		_, open := classrooms.classrooms[classroomID.String()]
+		classrooms.mu.Unlock()
+
+		if !open {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("classroom was kept after its last peer disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// connected reports whether the server still has the peer in its classroom
+func (p *testPeer) connected() bool {
+	classrooms.mu.Lock()
+	defer classrooms.mu.Unlock()
+
+	class, ok := classrooms.classrooms[p.classroomID.String()]
+	if !ok {
+		return false
+	}
+
+	class.mu.Lock()
+	defer class.mu.Unlock()
+	for _, m := range class.Members {
+		if m.PeerID == p.peerID {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSignallingSlowConsumerDisconnected(t *testing.T) {
+	server := newClassroomServer(t)
+	peers := server.joinAll(t, 2)
+	sender, stalled := peers[0], peers[1]
+
+	// The stalled peer stops reading, so once the socket buffers are full its queue fills and it is dropped
+	draw := `{"type": "draw", "data": "` + strings.Repeat("x", 60000) + `"}`
+	for sent := 0; stalled.connected(); sent++ {
+		if sent > 5000 {
+			t.Fatal("peer that stopped reading was never disconnected")
+		}
+		sender.send(draw)
+	}
+
+	sender.expect(SignallingLeave, stalled)
+}
+
+func TestSignallingPeerWithoutPongDropped(t *testing.T) {
+	pongWait := signallingPongWait
+	signallingPongWait = 500 * time.Millisecond
+	// Cleanups run last first, so this runs once the classroom handlers have finished
+	t.Cleanup(func() { signallingPongWait = pongWait })
+
+	server := newClassroomServer(t)
+	connecting := time.Now()
+	peers := server.joinAll(t, 2)
+	reading, silent := peers[0], peers[1]
+
+	// The silent peer never reads again so never answers a ping, the reading peer answers the pings it reads while
+	// it waits for the leave
+	reading.expect(SignallingLeave, silent)
+	if waited := time.Since(connecting); waited < signallingPongWait {
+		t.Errorf("peer was dropped after %s, before the pong wait of %s", waited, signallingPongWait)
+	}
+
+	reading.send(`{"type": "presence"}`)
+	message := reading.expect(SignallingPresence, nil)
+
+	var got []SignallingPeer
+	if err := json.Unmarshal(message.Data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].PeerID != reading.peerID {
+		t.Fatalf("presence is %s, want only the reading peer", message.Data)
+	}
+}
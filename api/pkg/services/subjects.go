@@ -1,8 +1,11 @@
 package services
 
 import (
+	"database/sql/driver"
+	"errors"
 	"fmt"
 	"math"
+	"strconv"
 	"strings"
 
 	"github.com/cs3305-team-4/api/pkg/database"
@@ -42,13 +45,80 @@ func (e SubjectTaughtError) Error() string {
 }
 
 const (
-	SubjectTaughtErrorDoesNotExist SubjectTaughtError = "This tutor subject relation does not exist"
+	SubjectTaughtErrorDoesNotExist       SubjectTaughtError = "This tutor subject relation does not exist"
+	SubjectTaughtErrorInvalidDuration    SubjectTaughtError = "Lessons can only be 30, 45, 60, 90 or 120 minutes long"
+	SubjectTaughtErrorDurationNotOffered SubjectTaughtError = "The tutor doesn't offer lessons of this length for this subject"
 )
 
+// LessonDurations that a lesson can be, in minutes
+var LessonDurations = []int{30, 45, 60, 90, 120}
+
+// DefaultLessonDuration is the length of a lesson in minutes when no other length is chosen.
+// Subject prices are for a lesson of this length.
+const DefaultLessonDuration = 60
+
+// Durations are lesson lengths in minutes
+type Durations []int
+
+// Scan scan value into durations, implements sql.Scanner interface.
+func (d *Durations) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	text, ok := value.(string)
+	if !ok {
+		return errors.New("invalid value for durations.")
+	}
+
+	out := Durations{}
+	text = strings.Trim(text, "{}")
+	if text != "" {
+		for _, minutes := range strings.Split(text, ",") {
+			n, err := strconv.Atoi(minutes)
+			if err != nil {
+				return err
+			}
+			out = append(out, n)
+		}
+	}
+	*d = out
+	return nil
+}
+
+// Value return durations value, implement driver.Valuer interface.
+func (d Durations) Value() (driver.Value, error) {
+	if d == nil {
+		return nil, nil
+	}
+
+	minutes := []string{}
+	for _, n := range d {
+		minutes = append(minutes, strconv.Itoa(n))
+	}
+	return "{" + strings.Join(minutes, ",") + "}", nil
+}
+
+// Validate returns an error unless every duration is one a lesson can be
+func (d Durations) Validate() error {
+	for _, n := range d {
+		valid := false
+		for _, allowed := range LessonDurations {
+			valid = valid || n == allowed
+		}
+		if !valid {
+			return SubjectTaughtErrorInvalidDuration
+		}
+	}
+	return nil
+}
+
 func CreateSubject(name string, image string, slug string, db *gorm.DB) error {
-	db, err := database.Open()
-	if err != nil {
-		return err
+	if db == nil {
+		var err error
+		db, err = database.Open()
+		if err != nil {
+			return err
+		}
 	}
 
 	return db.Create(&Subject{Name: name, Slug: slug}).Error
@@ -69,6 +139,32 @@ type SubjectTaught struct {
 
 	Description string `gorm:"not null;"`
 	Price       int64  `gorn:"not null;"`
+
+	// Durations are the lesson lengths the tutor offers for the subject, only DefaultLessonDuration if empty
+	Durations Durations `gorm:"type:int[]"`
+}
+
+// OfferedDurations returns the lesson lengths in minutes the tutor offers for the subject
+func (st *SubjectTaught) OfferedDurations() Durations {
+	if len(st.Durations) == 0 {
+		return Durations{DefaultLessonDuration}
+	}
+	return st.Durations
+}
+
+// Offers returns true if the tutor teaches lessons of this many minutes for the subject
+func (st *SubjectTaught) Offers(minutes int) bool {
+	for _, offered := range st.OfferedDurations() {
+		if offered == minutes {
+			return true
+		}
+	}
+	return false
+}
+
+// PriceFor returns the price of a lesson of this many minutes, Price is the price of a DefaultLessonDuration lesson
+func (st *SubjectTaught) PriceFor(minutes int) int64 {
+	return st.Price * int64(minutes) / DefaultLessonDuration
 }
 
 //gets all subjects in the DB
@@ -165,6 +261,7 @@ func GetTutorsBySubjectsPaginated(subjects *[]Subject, pageSize int, page int, q
 	for _, q := range strings.Split(query, " ") {
 		scopes = append(scopes, Search(SearchQuery{"profiles.first_name", q}, SearchQuery{"profiles.last_name", q}, SearchQuery{"profiles.country", q}, SearchQuery{"profiles.city", q}, SearchQuery{"profiles.description", q}))
 	}
+	scopes = append(scopes, notOnVacation)
 
 	var totalTutors int64
 	db.Model(&SubjectTaught{}).
@@ -235,6 +332,7 @@ func GetAllTutorsPaginated(db *gorm.DB, pageSize int, query string, sort string,
 	for _, q := range strings.Split(query, " ") {
 		scopes = append(scopes, Search(SearchQuery{"profiles.first_name", q}, SearchQuery{"profiles.last_name", q}, SearchQuery{"profiles.country", q}, SearchQuery{"profiles.city", q}, SearchQuery{"profiles.description", q}, SearchQuery{"subjects.name", q}))
 	}
+	scopes = append(scopes, notOnVacation)
 
 	var totalTutors int64
 	db.Model(&SubjectTaught{}).
@@ -304,7 +402,7 @@ func GetSubjectsTaughtByTutorID(tpid uuid.UUID, db *gorm.DB, preloads ...string)
 }
 
 //creats a StudentTaught based on the subject and tutor with a set price description.
-func TeachSubject(subject *Subject, tutor *Account, description string, price int64, db *gorm.DB) error {
+func TeachSubject(subject *Subject, tutor *Account, description string, price int64, durations Durations, db *gorm.DB) error {
 	db, err := database.Open()
 	if err != nil {
 		return err
@@ -315,6 +413,9 @@ func TeachSubject(subject *Subject, tutor *Account, description string, price in
 	if subject == nil {
 		return fmt.Errorf("there must be a subject to teach")
 	}
+	if err = durations.Validate(); err != nil {
+		return err
+	}
 
 	err = db.Transaction(func(tx *gorm.DB) error {
 		err = tx.Exec(`set transaction isolation level repeatable read`).Error
@@ -329,6 +430,7 @@ func TeachSubject(subject *Subject, tutor *Account, description string, price in
 			TutorProfileID: tutor.Profile.ID,
 			Description:    description,
 			Price:          price,
+			Durations:      durations,
 		}).Error
 
 		if err != nil {
@@ -381,6 +483,30 @@ func UpdateDescription(stid uuid.UUID, description string, db *gorm.DB) (*Subjec
 	})
 }
 
+// updates the lesson lengths a tutor offers for a subjecttaught by the sid
+func UpdateDurations(stid uuid.UUID, tutorID uuid.UUID, durations Durations) (*SubjectTaught, error) {
+	if err := durations.Validate(); err != nil {
+		return nil, err
+	}
+
+	db, err := database.Open()
+	if err != nil {
+		return nil, err
+	}
+	var subjectTaught *SubjectTaught
+	return subjectTaught, db.Transaction(func(tx *gorm.DB) error {
+		dbSubjectTaught, err := GetSubjectTaughtByID(stid, tx)
+		if err != nil {
+			return err
+		}
+		if subjectTaught = dbSubjectTaught; subjectTaught.ID == uuid.Nil || subjectTaught.TutorID != tutorID {
+			return SubjectTaughtErrorDoesNotExist
+		}
+		subjectTaught.Durations = durations
+		return tx.Model(subjectTaught).Update("Durations", durations).Error
+	})
+}
+
 // Request a subject to be added
 func RequestSubject(tutor *Account, name string) error {
 	db, err := database.Open()
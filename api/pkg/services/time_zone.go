@@ -0,0 +1,41 @@
+package services
+
+import (
+	"time"
+
+	// Time zones are embedded so they can be loaded on hosts without a zoneinfo database
+	_ "time/tzdata"
+
+	"github.com/google/uuid"
+)
+
+// Location returns the time zone of the profile, UTC if it has none
+func (p *Profile) Location() *time.Location {
+	if p.TimeZone == "" {
+		return time.UTC
+	}
+
+	loc, err := time.LoadLocation(p.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// UpdateProfileTimeZone sets the IANA time zone of the profile of the account, such as "Europe/Dublin"
+func UpdateProfileTimeZone(id uuid.UUID, timeZone string) (*Profile, error) {
+	if _, err := time.LoadLocation(timeZone); err != nil || timeZone == "" {
+		return nil, AccountErrorInvalidTimeZone
+	}
+
+	return UpdateProfileField(id, "time_zone", timeZone)
+}
+
+// ReadAccountLocation returns the time zone of the profile of the account, UTC if it has no profile
+func ReadAccountLocation(id uuid.UUID) *time.Location {
+	profile, err := readProfileByAccountID(id, nil)
+	if err != nil {
+		return time.UTC
+	}
+	return profile.Location()
+}
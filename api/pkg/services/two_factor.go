@@ -0,0 +1,320 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cs3305-team-4/api/pkg/database"
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TwoFactorError types.
+type TwoFactorError string
+
+func (e TwoFactorError) Error() string {
+	return string(e)
+}
+
+const (
+	TwoFactorErrorAlreadyEnabled TwoFactorError = "Two-factor authentication is already enabled on this account."
+	TwoFactorErrorNotEnrolled    TwoFactorError = "Two-factor authentication has not been set up on this account."
+	TwoFactorErrorInvalidCode    TwoFactorError = "The two-factor authentication code is invalid."
+	TwoFactorErrorRequired       TwoFactorError = "You must enable two-factor authentication and log in with it first."
+)
+
+const (
+	// totpPeriod is how long each TOTP code is valid for
+	totpPeriod = 30 * time.Second
+
+	totpDigits = 6
+
+	// totpSkew is how many periods either side of now a code is accepted for, to allow for clock drift
+	totpSkew = 1
+)
+
+// TwoFactor is the TOTP secret of an account. It does nothing until it has been confirmed with a code
+// generated from it, which proves the secret was saved in an authenticator app.
+type TwoFactor struct {
+	database.Model
+	AccountID uuid.UUID `gorm:"type:uuid;uniqueIndex"`
+	Secret    string    `gorm:"not null;"`
+	Enabled   bool
+
+	// LastUsedStep is the period of the last code accepted, codes can't be used more than once
+	LastUsedStep int64
+}
+
+// RecoveryCode can be used once instead of a TOTP code if the authenticator app is lost.
+// Only a hash is stored, the same way as a PasswordHash.
+type RecoveryCode struct {
+	database.Model
+	AccountID uuid.UUID `gorm:"type:uuid;index"`
+	Hash      []byte    `gorm:"type:text"`
+	UsedAt    *time.Time
+}
+
+func newTOTPSecret() (string, error) {
+	raw := make([]byte, 20)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw), nil
+}
+
+// totpCode generates the code of a base32 secret for a period, as described in RFC 6238
+func totpCode(secret string, step int64) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		return "", err
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// matchTOTP returns the period the code was generated for if it matches the secret around now
+func matchTOTP(secret string, code string) (int64, bool) {
+	now := time.Now().Unix() / int64(totpPeriod.Seconds())
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		expected, err := totpCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func normalizeTwoFactorCode(code string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+}
+
+func newRecoveryCode() (string, error) {
+	raw := make([]byte, 10)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	code := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw)
+	return code[:8] + "-" + code[8:], nil
+}
+
+// ReadTwoFactorByAccountID returns the TOTP secret of an account
+func ReadTwoFactorByAccountID(id uuid.UUID, conn *gorm.DB) (*TwoFactor, error) {
+	if conn == nil {
+		var err error
+		conn, err = database.Open()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	twoFactor := &TwoFactor{}
+	return twoFactor, conn.Where(&TwoFactor{AccountID: id}).First(twoFactor).Error
+}
+
+// TwoFactorEnabled returns true if logging into the account needs a TOTP or recovery code
+func (a *Account) TwoFactorEnabled() (bool, error) {
+	db, err := database.Open()
+	if err != nil {
+		return false, err
+	}
+
+	var count int64
+	err = db.Model(&TwoFactor{}).Where("account_id = ? AND enabled = ?", a.ID, true).Count(&count).Error
+	return count > 0, err
+}
+
+// BeginTwoFactorEnrollment generates a new TOTP secret for the account, returning it along with an otpauth URI
+// that authenticator apps can scan. Any previous unconfirmed secret is replaced.
+func (a *Account) BeginTwoFactorEnrollment() (string, string, error) {
+	db, err := database.Open()
+	if err != nil {
+		return "", "", err
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		twoFactor, err := ReadTwoFactorByAccountID(a.ID, tx)
+		if err == nil && twoFactor.Enabled {
+			return TwoFactorErrorAlreadyEnabled
+		}
+		if err == nil {
+			if err = tx.Unscoped().Delete(twoFactor).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Create(&TwoFactor{
+			AccountID: a.ID,
+			Secret:    secret,
+		}).Error
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	issuer := viper.GetString("auth.two_factor.issuer")
+	uri := url.URL{
+		Scheme: "otpauth",
+		Host:   "totp",
+		Path:   "/" + issuer + ":" + a.Email,
+		RawQuery: url.Values{
+			"secret":    {secret},
+			"issuer":    {issuer},
+			"algorithm": {"SHA1"},
+			"digits":    {fmt.Sprint(totpDigits)},
+			"period":    {fmt.Sprint(int64(totpPeriod.Seconds()))},
+		}.Encode(),
+	}
+
+	return secret, uri.String(), nil
+}
+
+// ConfirmTwoFactorEnrollment enables two-factor authentication once a code from the new secret is given,
+// returning the recovery codes of the account. The recovery codes can't be read again afterwards.
+func (a *Account) ConfirmTwoFactorEnrollment(code string) ([]string, error) {
+	db, err := database.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	err = db.Transaction(func(tx *gorm.DB) error {
+		twoFactor := &TwoFactor{}
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where(&TwoFactor{AccountID: a.ID}).First(twoFactor).Error
+		if err != nil {
+			return TwoFactorErrorNotEnrolled
+		}
+
+		if twoFactor.Enabled {
+			return TwoFactorErrorAlreadyEnabled
+		}
+
+		step, ok := matchTOTP(twoFactor.Secret, normalizeTwoFactorCode(code))
+		if !ok {
+			return TwoFactorErrorInvalidCode
+		}
+
+		err = tx.Model(twoFactor).Updates(map[string]interface{}{
+			"enabled":        true,
+			"last_used_step": step,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		codes, err = replaceRecoveryCodes(tx, a.ID)
+		return err
+	})
+	return codes, err
+}
+
+// replaceRecoveryCodes generates a new set of recovery codes, the old ones stop working
+func replaceRecoveryCodes(tx *gorm.DB, accountID uuid.UUID) ([]string, error) {
+	if err := tx.Where(&RecoveryCode{AccountID: accountID}).Delete(&RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, viper.GetInt("auth.two_factor.recovery_codes"))
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = tx.Create(&RecoveryCode{AccountID: accountID, Hash: hash}).Error; err != nil {
+			return nil, err
+		}
+		codes[i] = code
+	}
+
+	return codes, nil
+}
+
+// VerifyTwoFactor checks a TOTP or unused recovery code of the account, recovery codes are used up once they have been checked
+func (a *Account) VerifyTwoFactor(code string) error {
+	db, err := database.Open()
+	if err != nil {
+		return err
+	}
+
+	code = normalizeTwoFactorCode(code)
+	return db.Transaction(func(tx *gorm.DB) error {
+		twoFactor := &TwoFactor{}
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where(&TwoFactor{AccountID: a.ID}).First(twoFactor).Error
+		if err != nil || !twoFactor.Enabled {
+			return TwoFactorErrorNotEnrolled
+		}
+
+		if step, ok := matchTOTP(twoFactor.Secret, code); ok {
+			if step <= twoFactor.LastUsedStep {
+				return TwoFactorErrorInvalidCode
+			}
+			return tx.Model(twoFactor).Update("last_used_step", step).Error
+		}
+
+		var recoveryCodes []RecoveryCode
+		err = tx.Where("account_id = ? AND used_at IS NULL", a.ID).Find(&recoveryCodes).Error
+		if err != nil {
+			return err
+		}
+
+		for _, recoveryCode := range recoveryCodes {
+			if bcrypt.CompareHashAndPassword(recoveryCode.Hash, []byte(code)) == nil {
+				return tx.Model(&recoveryCode).Update("used_at", time.Now()).Error
+			}
+		}
+
+		return TwoFactorErrorInvalidCode
+	})
+}
+
+// DisableTwoFactor turns off two-factor authentication for the account, code must be a valid TOTP or recovery code
+func (a *Account) DisableTwoFactor(code string) error {
+	if err := a.VerifyTwoFactor(code); err != nil {
+		return err
+	}
+
+	db, err := database.Open()
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where(&TwoFactor{AccountID: a.ID}).Delete(&TwoFactor{}).Error; err != nil {
+			return err
+		}
+		return tx.Where(&RecoveryCode{AccountID: a.ID}).Delete(&RecoveryCode{}).Error
+	})
+}
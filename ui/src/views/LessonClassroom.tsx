@@ -18,11 +18,11 @@ import { useAsync } from 'react-async-hook';
 import { useHistory, useParams } from 'react-router-dom';
 import styled from 'styled-components';
 import { APIContext } from '../api/api';
-import { AccountType, ProfileResponseDTO } from '../api/definitions';
+import { ProfileResponseDTO } from '../api/definitions';
 import Messaging, { Message } from '../components/Messaging';
 import { UserAvatar } from '../components/UserAvatar';
 import { SettingsCTX } from '../api/classroom';
-import { Signalling, MESSAGE_TYPE } from '../webrtc/signalling';
+import { MESSAGE_TYPE, SignallingMessage } from '../webrtc/signalling';
 import { WebRTCHandler } from '../webrtc/webrtc';
 import { StreamType } from '../webrtc/stream_types';
 import { screenStream } from '../webrtc/devices';
@@ -149,7 +149,16 @@ export function LessonClassroom(): ReactElement {
   const history = useHistory();
   const api = useContext(APIContext);
 
-  const [messages, setMessages] = React.useState<Message[]>([]);
+  const signalling = settings.signalling;
+
+  // Chat messages have no profile when they were sent by this account
+  const messageFromChat = (message: SignallingMessage): Message => ({
+    text: message.data,
+    date: message.sent_at ? new Date(message.sent_at) : new Date(),
+    profile: message.account_id === signalling?.id ? undefined : settings.otherProfiles[message.account_id ?? ''],
+  });
+
+  const [messages, setMessages] = React.useState<Message[]>(() => (signalling?.chat ?? []).map(messageFromChat));
   const [webcamDisplays, setWebcamDisplays] = React.useState<IWebcam[]>([]);
   const [settingsOpen, setSettingsOpen] = React.useState(false);
   const [webcamEnabled, setWebcamEnabled] = React.useState(true);
@@ -157,7 +166,6 @@ export function LessonClassroom(): ReactElement {
   const [micEnabled, setMicEnabled] = React.useState(true);
   const [screen, setScreen] = React.useState<MediaStream>();
 
-  const signalling = settings.signalling;
   const handler = useRef<WebRTCHandler>();
   const [addingPeer, setAddingPeer] = React.useState(false);
   const [streamingID, setStreamingID] = React.useState<string>('');
@@ -203,7 +211,7 @@ export function LessonClassroom(): ReactElement {
   };
 
   const onDisconnect = (id: string) => {
-    setWebcamDisplays((prev) => prev.filter((v) => v.profile.account_id !== id));
+    setWebcamDisplays((prev) => prev.filter((v) => v.ref.key !== id));
     setStreamingID((prev) => {
       console.log('Screen no longer receiving', prev);
       if (prev !== '') {
@@ -221,35 +229,27 @@ export function LessonClassroom(): ReactElement {
   };
 
   const signallingOnMessage = async (event: MessageEvent): Promise<void> => {
-    const message = JSON.parse(event.data);
-    // Should never be able to reveive message from self but ignore just in case
-    if (message.src === signalling.id) return;
-
-    // Ignore messages explicitly not sent to us
-    if (message.to !== undefined && message.to !== signalling.id) return;
+    const message: SignallingMessage = JSON.parse(event.data);
+    // Messages sent by the server have no sender
+    const from = message.from ?? '';
 
-    const type: MESSAGE_TYPE = message.type;
-    switch (type) {
-      case MESSAGE_TYPE.PROBE: {
-        signalling.send(MESSAGE_TYPE.PROBE_RESPONSE, message.src, settings.otherProfiles[signalling.id]);
-        break;
-      }
-      case MESSAGE_TYPE.AHOY_HOY: {
-        handler.current!.addPeer(message.src);
+    switch (message.type) {
+      case MESSAGE_TYPE.READY: {
+        handler.current!.addPeer(from);
         break;
       }
       case MESSAGE_TYPE.CHAT: {
         console.log('New Message: ', message);
-        const messageData = Object.assign(message.data, { date: new Date(message.data.date) });
-        setMessages((prev) => prev.concat(messageData));
+        setMessages((prev) => prev.concat(messageFromChat(message)));
         break;
       }
-      case MESSAGE_TYPE.SDP: {
-        await handler.current!.incomingSDP(message.src, message.data);
+      case MESSAGE_TYPE.OFFER:
+      case MESSAGE_TYPE.ANSWER: {
+        await handler.current!.incomingSDP(from, message.data);
         break;
       }
-      case MESSAGE_TYPE.CANDIDATE: {
-        await handler.current!.incomingCandidate(message.src, message.data);
+      case MESSAGE_TYPE.ICE_CANDIDATE: {
+        await handler.current!.incomingCandidate(from, message.data);
         break;
       }
       case MESSAGE_TYPE.STOP_STREAM: {
@@ -286,7 +286,11 @@ export function LessonClassroom(): ReactElement {
         break;
       }
       case MESSAGE_TYPE.LEAVE: {
-        onDisconnect(message.data);
+        if (handler.current?.peers[from]) {
+          handler.current.peers[from].conn.close();
+          delete handler.current.peers[from];
+        }
+        onDisconnect(from);
         break;
       }
     }
@@ -308,7 +312,7 @@ export function LessonClassroom(): ReactElement {
             }}
           />
         );
-        const profile = settings.otherProfiles[id];
+        const profile = settings.otherProfiles[signalling?.accountOf(id) ?? ''];
         console.log(settings.otherProfiles);
         setWebcamDisplays((webcams) => webcams.concat({ stream, ref, profile, streaming: true }));
         break;
@@ -370,7 +374,7 @@ export function LessonClassroom(): ReactElement {
     console.log(handler.current);
 
     signalling.onmessage(signallingOnMessage);
-    signalling.send(MESSAGE_TYPE.AHOY_HOY, '', null);
+    signalling.send(MESSAGE_TYPE.READY, '', null);
 
     handler.current.ontrackremove = trackRemove;
     handler.current.ondisconnect = onDisconnect;
@@ -383,17 +387,14 @@ export function LessonClassroom(): ReactElement {
     }
   }, [bg]);
 
-  useAsync(async () => {
-    const last = messages[messages.length - 1];
-    if (last && !last.profile) {
-      console.log(last);
-      const profile = await api.services.readProfileByAccountID(
-        api.account?.id ?? '',
-        api.account?.type ?? AccountType.Tutor,
-      );
-      signalling?.send(MESSAGE_TYPE.CHAT, '', { text: last.text, date: last.date, profile });
+  // Messages only change through Messaging when a message is sent by this account
+  const sendMessages = (next: Message[]) => {
+    const last = next[next.length - 1];
+    if (last) {
+      signalling?.send(MESSAGE_TYPE.CHAT, '', last.text);
     }
-  }, [api.account?.id, messages]);
+    setMessages(next);
+  };
 
   const addWebcam = (web: IWebcam) => {
     console.log('Adding Webcam:', web);
@@ -524,7 +525,7 @@ export function LessonClassroom(): ReactElement {
     settings.webcamStream?.getTracks().forEach((v) => {
       v.stop();
     });
-    signalling?.send(MESSAGE_TYPE.LEAVE, '', api.account?.id);
+    signalling?.send(MESSAGE_TYPE.LEAVE, '', null);
     history.push(`/lessons/${lid}/goodbye`);
   };
 
@@ -637,7 +638,7 @@ export function LessonClassroom(): ReactElement {
               </StyledWebcam>
             );
           })}
-          <Messaging messages={messages} setMessages={setMessages} height={webcamDisplays.length * webcamHeight} />
+          <Messaging messages={messages} setMessages={sendMessages} height={webcamDisplays.length * webcamHeight} />
         </StyledSider>
         <Layout.Content>
           <StyledVideo
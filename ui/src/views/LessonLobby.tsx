@@ -15,7 +15,7 @@ import { APIContext } from '../api/api';
 import { UserAvatar } from '../components/UserAvatar';
 import { ISettings, SettingsCTX } from '../api/classroom';
 import { LessonClassroom } from './LessonClassroom';
-import { MESSAGE_TYPE, Signalling } from '../webrtc/signalling';
+import { MESSAGE_TYPE, Signalling, SignallingMessage, SignallingPeer } from '../webrtc/signalling';
 import * as Devices from '../webrtc/devices';
 import config from '../config';
 import {
@@ -83,7 +83,7 @@ export function LessonLobby(): ReactElement {
   const [otherProfiles, setOtherProfiles] = React.useState<{ [id: string]: ProfileResponseDTO }>({});
   const [metadata, setMetadata] = useState<LessonResponseDTO>();
   const [completed, setCompleted] = useState(false);
-  const [alreadyInClass, setAlreadyInClass] = useState<ProfileResponseDTO[] | undefined>(undefined);
+  const [alreadyInClass, setAlreadyInClass] = useState<string[] | undefined>(undefined);
 
   if (!joined && !history.location.pathname.endsWith('lobby')) {
     history.push(`/lessons/${lid}/lobby`);
@@ -107,19 +107,19 @@ export function LessonLobby(): ReactElement {
   };
 
   const connect = () => {
-    signalling.current = new Signalling(api.claims!.sub, `${config.signallingUrl}/${lid}`, {
+    signalling.current = new Signalling(api.claims!.sub, `${config.signallingUrl}/${lid}?token=${api.bearerToken}`, {
       onopen: (event: Event) => {
         console.log('Connected to WS: ', lid);
-        signalling.current?.send(MESSAGE_TYPE.PROBE, '', null);
       },
       onmessage: (event) => {
-        const message = JSON.parse(event.data);
-        if (message.src === api.claims!.sub) return;
-        if (message.to !== undefined && message.to !== api.claims!.sub) return;
+        const message: SignallingMessage = JSON.parse(event.data);
 
-        const type: MESSAGE_TYPE = message.type;
-        if (type === MESSAGE_TYPE.PROBE_RESPONSE) {
-          setAlreadyInClass(alreadyInClass ? alreadyInClass.concat(message.data) : [message.data]);
+        // The server sends the peers in the classroom when connecting, other connections of this account are left out
+        if (message.type === MESSAGE_TYPE.PRESENCE) {
+          const peers = message.data as SignallingPeer[];
+          setAlreadyInClass(
+            peers.map((peer) => peer.account_id).filter((id, i, ids) => id !== api.claims!.sub && ids.indexOf(id) === i),
+          );
         }
       },
       onclose: () => {
@@ -315,7 +315,9 @@ export function LessonLobby(): ReactElement {
               <Typography.Text style={{ color: '#fff' }}>Already in this meeting:</Typography.Text>
             </Typography>
             <Row align="middle" justify="center">
-              {alreadyInClass?.map((profile) => {
+              {alreadyInClass?.map((id) => {
+                const profile = otherProfiles[id];
+                if (!profile) return null;
                 return (
                   <Col key={profile.account_id}>
                     <Avatar.Group size="default">
@@ -5,47 +5,118 @@ export interface Callbacks {
   onerror?: (ev: Event) => void;
 }
 
+// Types of the messages sent through a classroom, they match SignallingMessageType on the server
 export enum MESSAGE_TYPE {
-  PROBE = 1,
-  PROBE_RESPONSE,
-  AHOY_HOY,
-  CHAT,
-  SDP,
-  CANDIDATE,
-  STOP_STREAM,
-  DRAW,
-  WIPE,
-  UNDO,
-  CHANGE_BG,
-  INIT,
-  LEAVE,
+  // Sent by the server when a peer connects or disconnects, a peer also sends LEAVE when it hangs up
+  JOIN = 'join',
+  LEAVE = 'leave',
+  // Sent by a peer when it enters the call, peers already in the call connect to it
+  READY = 'ready',
+  OFFER = 'offer',
+  ANSWER = 'answer',
+  ICE_CANDIDATE = 'ice-candidate',
+  CHAT = 'chat',
+  PRESENCE = 'presence',
+  ERROR = 'error',
+  STOP_STREAM = 'stop-stream',
+  DRAW = 'draw',
+  WIPE = 'wipe',
+  UNDO = 'undo',
+  CHANGE_BG = 'change-background',
+  INIT = 'whiteboard',
+}
+
+export interface SignallingPeer {
+  peer_id: string;
+  account_id: string;
+}
+
+// SignallingMessage is the envelope of every message, from and account_id are set by the server
+export interface SignallingMessage {
+  type: MESSAGE_TYPE;
+  from?: string;
+  account_id?: string;
+  to?: string;
+  data?: any;
+  sent_at?: string;
 }
 
 export class Signalling {
   ws: WebSocket;
+  // Account ID of this peer, the server gives every connection its own peer ID
   id: string;
+  // Account IDs of the peers in the classroom by peer ID
+  peers: { [peerId: string]: string };
+  // Chat messages of the lesson, the server replays the latest ones when connecting
+  chat: SignallingMessage[];
 
   constructor(id: string, classUrl: string, callbacks: Callbacks) {
     this.id = id;
+    this.peers = {};
+    this.chat = [];
 
     this.ws = new WebSocket(classUrl);
     if (callbacks.onopen) this.ws.onopen = callbacks.onopen;
-    if (callbacks.onmessage) this.ws.onmessage = callbacks.onmessage;
+    this.onmessage(callbacks.onmessage);
     if (callbacks.onclose) this.ws.onclose = callbacks.onclose;
     if (callbacks.onerror) this.ws.onerror = callbacks.onerror;
   }
 
   send(message_type: MESSAGE_TYPE, to: string, data: any): void {
     console.log('Sending: ' + this.ws.readyState + ' - ' + message_type);
-    this.ws.send(JSON.stringify({ src: this.id, dest: to, type: message_type, data: data }));
+    const message: SignallingMessage = { type: message_type, data: data };
+    if (to) message.to = to;
+    this.ws.send(JSON.stringify(message));
+
+    if (message_type === MESSAGE_TYPE.CHAT) {
+      this.chat.push({ type: message_type, account_id: this.id, data: data, sent_at: new Date().toISOString() });
+    }
+  }
+
+  // accountOf returns the account ID of a peer
+  accountOf(peerId: string): string {
+    return this.peers[peerId] ?? '';
+  }
+
+  // track keeps the peers and chat of the classroom up to date
+  private track(message: SignallingMessage) {
+    switch (message.type) {
+      case MESSAGE_TYPE.PRESENCE: {
+        this.peers = {};
+        (message.data as SignallingPeer[]).forEach((peer) => {
+          this.peers[peer.peer_id] = peer.account_id;
+        });
+        break;
+      }
+      case MESSAGE_TYPE.JOIN:
+      case MESSAGE_TYPE.READY: {
+        if (message.from && message.account_id) this.peers[message.from] = message.account_id;
+        break;
+      }
+      case MESSAGE_TYPE.LEAVE: {
+        if (message.from) delete this.peers[message.from];
+        break;
+      }
+      case MESSAGE_TYPE.CHAT: {
+        this.chat.push(message);
+        break;
+      }
+      case MESSAGE_TYPE.ERROR: {
+        console.error('Signalling: ' + message.data);
+        break;
+      }
+    }
   }
 
   onopen(func: (ev: Event) => void) {
     this.ws.onopen = func;
   }
 
-  onmessage(func: (ev: MessageEvent) => void) {
-    this.ws.onmessage = func;
+  onmessage(func?: (ev: MessageEvent) => void) {
+    this.ws.onmessage = (ev: MessageEvent) => {
+      this.track(JSON.parse(ev.data));
+      if (func) func(ev);
+    };
   }
 
   onclose(func: (ev: CloseEvent) => void) {
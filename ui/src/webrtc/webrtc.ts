@@ -82,7 +82,7 @@ export class WebRTCHandler {
     peer.conn.onicecandidate = (event) => {
       if (event.candidate) {
         console.log('Sending Candidate: ' + id);
-        this.signaller.send(MESSAGE_TYPE.CANDIDATE, id, event.candidate);
+        this.signaller.send(MESSAGE_TYPE.ICE_CANDIDATE, id, event.candidate);
       }
     };
 
@@ -91,7 +91,7 @@ export class WebRTCHandler {
       try {
         peer.makingOffer = true;
         await peer.conn.setLocalDescription({});
-        this.signaller.send(MESSAGE_TYPE.SDP, id, peer.conn.localDescription);
+        this.sendDescription(id, peer.conn.localDescription);
       } catch (err) {
         console.error(err);
       } finally {
@@ -118,6 +118,12 @@ export class WebRTCHandler {
     return peer;
   }
 
+  // Offers and answers are sent as their own message types
+  sendDescription(id: string, sdp: RTCSessionDescription | null) {
+    if (!sdp) return;
+    this.signaller.send(sdp.type === 'offer' ? MESSAGE_TYPE.OFFER : MESSAGE_TYPE.ANSWER, id, sdp);
+  }
+
   // Correlate track IDs with content type e.g. Webcam, Screenshare
   incomingCorrelation(id: string, event: MessageEvent<any>) {
     const correlation = JSON.parse(event.data);
@@ -160,7 +166,7 @@ export class WebRTCHandler {
 
       if (sdp.type === 'offer') {
         await peer.conn.setLocalDescription({});
-        this.signaller.send(MESSAGE_TYPE.SDP, id, peer.conn.localDescription);
+        this.sendDescription(id, peer.conn.localDescription);
       }
     } catch (err) {
       console.error(err);